@@ -0,0 +1,221 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: api/attestation.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AttestationServiceClient is the client API for AttestationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AttestationServiceClient interface {
+	// CreateNonce issues a single-use nonce for a later Verify call.
+	CreateNonce(ctx context.Context, in *CreateNonceRequest, opts ...grpc.CallOption) (*CreateNonceResponse, error)
+	// Attest asks the NSM for an attestation document.
+	Attest(ctx context.Context, in *AttestRequest, opts ...grpc.CallOption) (*AttestResponse, error)
+	// Verify checks a document's signature, certificate chain, nonce and PCR policy.
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// Inspect decodes a document WITHOUT verifying it.
+	Inspect(ctx context.Context, in *InspectRequest, opts ...grpc.CallOption) (*InspectResponse, error)
+}
+
+type attestationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAttestationServiceClient(cc grpc.ClientConnInterface) AttestationServiceClient {
+	return &attestationServiceClient{cc}
+}
+
+func (c *attestationServiceClient) CreateNonce(ctx context.Context, in *CreateNonceRequest, opts ...grpc.CallOption) (*CreateNonceResponse, error) {
+	out := new(CreateNonceResponse)
+	err := c.cc.Invoke(ctx, "/nitro.attest.v1.AttestationService/CreateNonce", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *attestationServiceClient) Attest(ctx context.Context, in *AttestRequest, opts ...grpc.CallOption) (*AttestResponse, error) {
+	out := new(AttestResponse)
+	err := c.cc.Invoke(ctx, "/nitro.attest.v1.AttestationService/Attest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *attestationServiceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, "/nitro.attest.v1.AttestationService/Verify", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *attestationServiceClient) Inspect(ctx context.Context, in *InspectRequest, opts ...grpc.CallOption) (*InspectResponse, error) {
+	out := new(InspectResponse)
+	err := c.cc.Invoke(ctx, "/nitro.attest.v1.AttestationService/Inspect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AttestationServiceServer is the server API for AttestationService service.
+// All implementations must embed UnimplementedAttestationServiceServer
+// for forward compatibility
+type AttestationServiceServer interface {
+	// CreateNonce issues a single-use nonce for a later Verify call.
+	CreateNonce(context.Context, *CreateNonceRequest) (*CreateNonceResponse, error)
+	// Attest asks the NSM for an attestation document.
+	Attest(context.Context, *AttestRequest) (*AttestResponse, error)
+	// Verify checks a document's signature, certificate chain, nonce and PCR policy.
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// Inspect decodes a document WITHOUT verifying it.
+	Inspect(context.Context, *InspectRequest) (*InspectResponse, error)
+	mustEmbedUnimplementedAttestationServiceServer()
+}
+
+// UnimplementedAttestationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAttestationServiceServer struct {
+}
+
+func (UnimplementedAttestationServiceServer) CreateNonce(context.Context, *CreateNonceRequest) (*CreateNonceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateNonce not implemented")
+}
+func (UnimplementedAttestationServiceServer) Attest(context.Context, *AttestRequest) (*AttestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Attest not implemented")
+}
+func (UnimplementedAttestationServiceServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedAttestationServiceServer) Inspect(context.Context, *InspectRequest) (*InspectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Inspect not implemented")
+}
+func (UnimplementedAttestationServiceServer) mustEmbedUnimplementedAttestationServiceServer() {}
+
+// UnsafeAttestationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AttestationServiceServer will
+// result in compilation errors.
+type UnsafeAttestationServiceServer interface {
+	mustEmbedUnimplementedAttestationServiceServer()
+}
+
+func RegisterAttestationServiceServer(s grpc.ServiceRegistrar, srv AttestationServiceServer) {
+	s.RegisterService(&AttestationService_ServiceDesc, srv)
+}
+
+func _AttestationService_CreateNonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateNonceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttestationServiceServer).CreateNonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nitro.attest.v1.AttestationService/CreateNonce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttestationServiceServer).CreateNonce(ctx, req.(*CreateNonceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AttestationService_Attest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AttestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttestationServiceServer).Attest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nitro.attest.v1.AttestationService/Attest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttestationServiceServer).Attest(ctx, req.(*AttestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AttestationService_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttestationServiceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nitro.attest.v1.AttestationService/Verify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttestationServiceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AttestationService_Inspect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InspectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttestationServiceServer).Inspect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nitro.attest.v1.AttestationService/Inspect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttestationServiceServer).Inspect(ctx, req.(*InspectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AttestationService_ServiceDesc is the grpc.ServiceDesc for AttestationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AttestationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nitro.attest.v1.AttestationService",
+	HandlerType: (*AttestationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateNonce",
+			Handler:    _AttestationService_CreateNonce_Handler,
+		},
+		{
+			MethodName: "Attest",
+			Handler:    _AttestationService_Attest_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _AttestationService_Verify_Handler,
+		},
+		{
+			MethodName: "Inspect",
+			Handler:    _AttestationService_Inspect_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/attestation.proto",
+}
@@ -0,0 +1,194 @@
+// Package api is the network API for attestation: a gRPC service mapped onto the
+// attestation package, and a REST/JSON gateway in front of it.
+//
+// The messages, service stubs and gateway are generated from attestation.proto; run
+// go generate after changing it.
+package api
+
+//go:generate protoc -I .. -I ../third_party/googleapis --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative --grpc-gateway_out=.. --grpc-gateway_opt=paths=source_relative ../api/attestation.proto
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/hf/nitrite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"nitro/attest/attestation"
+	"nitro/attest/keyring"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNonceLifetime is the lifetime of nonces issued without one.
+	DefaultNonceLifetime = 5 * time.Minute
+	// MaxNonceLifetime bounds the lifetime a client may ask for.
+	MaxNonceLifetime = time.Hour
+	// DefaultMaxNonces bounds the outstanding nonces when no limit is configured.
+	DefaultMaxNonces = 10000
+)
+
+// Service implements AttestationServiceServer. gRPC calls its methods concurrently and
+// only the nonce table is guarded by a lock, so fill in the other fields before
+// registering the service.
+type Service struct {
+	UnimplementedAttestationServiceServer
+
+	// Session is the NSM session used by Attest. If nil, Attest is unavailable, as on a
+	// parent instance.
+	Session attestation.Session
+	// Keys are the enclave's keys, the only public keys Attest binds into documents. If
+	// nil, Attest refuses every public key.
+	Keys *keyring.Manager
+	// Roots are the trusted roots for Verify. If nil, the AWS Nitro Enclaves root is used.
+	Roots *x509.CertPool
+	// MaxNonces bounds the nonces issued and neither used nor expired, since anyone can
+	// ask for them. Zero means DefaultMaxNonces.
+	MaxNonces int
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// CreateNonce issues a nonce and remembers it until it is used by Verify or expires.
+// While MaxNonces are outstanding, requests fail with ResourceExhausted.
+func (s *Service) CreateNonce(ctx context.Context, req *CreateNonceRequest) (*CreateNonceResponse, error) {
+	lifetime := time.Duration(req.LifetimeSeconds) * time.Second
+	if lifetime == 0 {
+		lifetime = DefaultNonceLifetime
+	}
+	if lifetime > MaxNonceLifetime {
+		return nil, status.Errorf(codes.InvalidArgument, "nonce lifetime exceeds %s", MaxNonceLifetime)
+	}
+	n, err := attestation.CreateNonce(lifetime)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "could not create nonce")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nonces == nil {
+		s.nonces = map[string]time.Time{}
+	}
+	now := time.Now()
+	for k, exp := range s.nonces {
+		if now.After(exp) {
+			delete(s.nonces, k)
+		}
+	}
+	max := s.MaxNonces
+	if max <= 0 {
+		max = DefaultMaxNonces
+	}
+	if len(s.nonces) >= max {
+		return nil, status.Error(codes.ResourceExhausted, "too many outstanding nonces")
+	}
+	s.nonces[string(n.Value)] = n.Expiration
+	return &CreateNonceResponse{Nonce: n.Value, ExpiresAt: timestamppb.New(n.Expiration)}, nil
+}
+
+// Attest retrieves an attestation document from the NSM. A public key in the request must
+// be a valid key of Keys, otherwise any caller could have the enclave vouch for a key of
+// its own, and the user data is attested as attestation.RelayedUserData so that callers
+// cannot obtain documents passing for the enclave's own bindings.
+func (s *Service) Attest(ctx context.Context, req *AttestRequest) (*AttestResponse, error) {
+	if s.Session == nil {
+		return nil, status.Error(codes.Unavailable, "nsm unavailable")
+	}
+	if len(req.PublicKey) > 0 {
+		if s.Keys == nil {
+			return nil, status.Error(codes.InvalidArgument, "public_key must be a key held by the enclave")
+		}
+		if _, ok := s.Keys.Key(keyring.Thumbprint(req.PublicKey)); !ok {
+			return nil, status.Error(codes.InvalidArgument, "public_key must be a key held by the enclave")
+		}
+	}
+	doc, err := attestation.RetrieveAttestationFrom(s.Session, req.Nonce, attestation.RelayedUserData(req.UserData), req.PublicKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "attestation failed: %v", err)
+	}
+	return &AttestResponse{Document: doc}, nil
+}
+
+// Verify verifies a document. A nonce in the request must have been issued by
+// CreateNonce; it is consumed whether or not verification succeeds.
+func (s *Service) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	if _, err := attestation.ParseDocument(req.Document); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "malformed document: %v", err)
+	}
+	opts := attestation.VerifyOptions{Roots: s.Roots}
+	if len(req.Nonce) > 0 {
+		exp, ok := s.takeNonce(req.Nonce)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "unknown or already used nonce")
+		}
+		opts.Nonce = &attestation.Nonce{Value: req.Nonce, Expiration: exp}
+	}
+	if len(req.Pcrs) > 0 {
+		opts.Policy = &attestation.Policy{PCRs: map[uint]attestation.Measurement{}}
+		for index, value := range req.Pcrs {
+			opts.Policy.PCRs[uint(index)] = value
+		}
+	}
+	if req.CurrentTime != nil {
+		if err := req.CurrentTime.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid current_time: %v", err)
+		}
+		opts.CurrentTime = req.CurrentTime.AsTime()
+	}
+	res, err := attestation.VerifyDocument(req.Document, opts)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "attestation rejected: %v", err)
+	}
+	return &VerifyResponse{Document: toDocument(res.Document)}, nil
+}
+
+// Inspect decodes a document without verifying it.
+func (s *Service) Inspect(ctx context.Context, req *InspectRequest) (*InspectResponse, error) {
+	doc, err := attestation.ParseDocument(req.Document)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "malformed document: %v", err)
+	}
+	return &InspectResponse{Document: toDocument(doc)}, nil
+}
+
+// takeNonce removes an issued nonce and returns its expiration.
+func (s *Service) takeNonce(nonce []byte) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.nonces[string(nonce)]
+	delete(s.nonces, string(nonce))
+	return exp, ok
+}
+
+func toDocument(doc *nitrite.Document) *Document {
+	pcrs := make(map[uint32][]byte, len(doc.PCRs))
+	for index, value := range doc.PCRs {
+		pcrs[uint32(index)] = value
+	}
+	return &Document{
+		ModuleId:    doc.ModuleID,
+		Timestamp:   timestamppb.New(attestation.DocumentTime(doc)),
+		Digest:      doc.Digest,
+		Pcrs:        pcrs,
+		Certificate: doc.Certificate,
+		Cabundle:    doc.CABundle,
+		PublicKey:   doc.PublicKey,
+		UserData:    doc.UserData,
+		Nonce:       doc.Nonce,
+	}
+}
+
+// NewGateway returns a REST/JSON handler that forwards to the service behind conn.
+// Pre: Parameter conn is a connection to a server with the service registered.
+// Post: The handler or an error is returned.
+func NewGateway(ctx context.Context, conn *grpc.ClientConn) (http.Handler, error) {
+	mux := runtime.NewServeMux()
+	if err := RegisterAttestationServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/authn"
+	"nitro/attest/keyring"
+	"testing"
+	"time"
+)
+
+// serve runs svc on an in-process listener and returns a client connection to it.
+func serve(t *testing.T, svc *Service) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterAttestationServiceServer(srv, svc)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func requireCode(t *testing.T, err error, code codes.Code, msg string) {
+	require.Error(t, err)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, code, st.Code())
+	require.Contains(t, st.Message(), msg)
+}
+
+func TestService(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0a}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	ctx := context.Background()
+	client := NewAttestationServiceClient(serve(t, &Service{Session: sim, Roots: sim.Roots()}))
+
+	attest := func(t *testing.T, nonce []byte) []byte {
+		res, err := client.Attest(ctx, &AttestRequest{Nonce: nonce, UserData: []byte("user data")})
+		require.NoError(t, err)
+		return res.Document
+	}
+
+	t.Run("attest and verify", func(t *testing.T) {
+		n, err := client.CreateNonce(ctx, &CreateNonceRequest{})
+		require.NoError(t, err)
+		require.NotEmpty(t, n.Nonce)
+		require.WithinDuration(t, time.Now().Add(DefaultNonceLifetime), n.ExpiresAt.AsTime(), time.Minute)
+
+		doc := attest(t, n.Nonce)
+		res, err := client.Verify(ctx, &VerifyRequest{Document: doc, Nonce: n.Nonce, Pcrs: map[uint32][]byte{0: pcr}})
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleId)
+		require.Equal(t, "SHA384", res.Document.Digest)
+		require.Equal(t, pcr, res.Document.Pcrs[0])
+		require.Equal(t, n.Nonce, res.Document.Nonce)
+		require.Equal(t, attestation.RelayedUserData([]byte("user data")), res.Document.UserData)
+		require.NotEmpty(t, res.Document.Certificate)
+		require.WithinDuration(t, time.Now(), res.Document.Timestamp.AsTime(), time.Minute)
+	})
+
+	t.Run("nonce is single use", func(t *testing.T) {
+		n, err := client.CreateNonce(ctx, &CreateNonceRequest{LifetimeSeconds: 60})
+		require.NoError(t, err)
+		doc := attest(t, n.Nonce)
+		_, err = client.Verify(ctx, &VerifyRequest{Document: doc, Nonce: n.Nonce})
+		require.NoError(t, err)
+		_, err = client.Verify(ctx, &VerifyRequest{Document: doc, Nonce: n.Nonce})
+		requireCode(t, err, codes.FailedPrecondition, "unknown or already used nonce")
+	})
+
+	t.Run("unknown nonce", func(t *testing.T) {
+		doc := attest(t, []byte("not issued"))
+		_, err := client.Verify(ctx, &VerifyRequest{Document: doc, Nonce: []byte("not issued")})
+		requireCode(t, err, codes.FailedPrecondition, "unknown or already used nonce")
+	})
+
+	t.Run("mismatched nonce", func(t *testing.T) {
+		n, err := client.CreateNonce(ctx, &CreateNonceRequest{})
+		require.NoError(t, err)
+		doc := attest(t, []byte("another nonce"))
+		_, err = client.Verify(ctx, &VerifyRequest{Document: doc, Nonce: n.Nonce})
+		requireCode(t, err, codes.PermissionDenied, "attestation rejected: mismatched nonce")
+	})
+
+	t.Run("pcr mismatch", func(t *testing.T) {
+		doc := attest(t, nil)
+		_, err := client.Verify(ctx, &VerifyRequest{Document: doc, Pcrs: map[uint32][]byte{0: make([]byte, 48)}})
+		requireCode(t, err, codes.PermissionDenied, "attestation rejected: PCR0 mismatch")
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		other, err := attestationtest.NewSimulator(nil)
+		require.NoError(t, err)
+		c := NewAttestationServiceClient(serve(t, &Service{Session: other}))
+		res, err := c.Attest(ctx, &AttestRequest{})
+		require.NoError(t, err)
+		_, err = client.Verify(ctx, &VerifyRequest{Document: res.Document})
+		requireCode(t, err, codes.PermissionDenied, "attestation rejected")
+	})
+
+	t.Run("verify at a given time", func(t *testing.T) {
+		doc := attest(t, nil)
+		_, err := client.Verify(ctx, &VerifyRequest{Document: doc, CurrentTime: timestampIn(10 * 365 * 24 * time.Hour)})
+		requireCode(t, err, codes.PermissionDenied, "attestation rejected")
+	})
+
+	t.Run("inspect", func(t *testing.T) {
+		res, err := client.Inspect(ctx, &InspectRequest{Document: attest(t, []byte("nonce"))})
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleId)
+		require.Equal(t, []byte("nonce"), res.Document.Nonce)
+	})
+
+	t.Run("malformed document", func(t *testing.T) {
+		_, err := client.Inspect(ctx, &InspectRequest{Document: []byte("garbage")})
+		requireCode(t, err, codes.InvalidArgument, "malformed document")
+		_, err = client.Verify(ctx, &VerifyRequest{Document: []byte("garbage")})
+		requireCode(t, err, codes.InvalidArgument, "malformed document")
+	})
+
+	t.Run("nonce lifetime too long", func(t *testing.T) {
+		_, err := client.CreateNonce(ctx, &CreateNonceRequest{LifetimeSeconds: uint32(2 * MaxNonceLifetime / time.Second)})
+		requireCode(t, err, codes.InvalidArgument, "nonce lifetime exceeds 1h0m0s")
+	})
+
+	t.Run("outstanding nonces are capped", func(t *testing.T) {
+		c := NewAttestationServiceClient(serve(t, &Service{Session: sim, Roots: sim.Roots(), MaxNonces: 2}))
+		var issued [][]byte
+		for i := 0; i < 2; i++ {
+			n, err := c.CreateNonce(ctx, &CreateNonceRequest{})
+			require.NoError(t, err)
+			issued = append(issued, n.Nonce)
+		}
+		_, err := c.CreateNonce(ctx, &CreateNonceRequest{})
+		requireCode(t, err, codes.ResourceExhausted, "too many outstanding nonces")
+
+		res, err := c.Attest(ctx, &AttestRequest{Nonce: issued[0]})
+		require.NoError(t, err)
+		_, err = c.Verify(ctx, &VerifyRequest{Document: res.Document, Nonce: issued[0]})
+		require.NoError(t, err)
+		_, err = c.CreateNonce(ctx, &CreateNonceRequest{})
+		require.NoError(t, err, "used nonces free their slot")
+	})
+
+	t.Run("only enclave keys are attested", func(t *testing.T) {
+		keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+		require.NoError(t, err)
+		c := NewAttestationServiceClient(serve(t, &Service{Session: sim, Roots: sim.Roots(), Keys: keys}))
+		own := keys.Keys()[0].PublicKey
+		res, err := c.Attest(ctx, &AttestRequest{PublicKey: own})
+		require.NoError(t, err)
+		doc, err := attestation.ParseDocument(res.Document)
+		require.NoError(t, err)
+		require.Equal(t, own, doc.PublicKey)
+
+		foreign, _, err := attestation.GenerateKeypairFrom(rand.Reader, attestation.P256)
+		require.NoError(t, err)
+		_, err = c.Attest(ctx, &AttestRequest{PublicKey: foreign})
+		requireCode(t, err, codes.InvalidArgument, "public_key must be a key held by the enclave")
+		_, err = client.Attest(ctx, &AttestRequest{PublicKey: own})
+		requireCode(t, err, codes.InvalidArgument, "public_key must be a key held by the enclave")
+	})
+
+	t.Run("caller user data cannot forge bindings", func(t *testing.T) {
+		binding := make([]byte, 32)
+		_, err := rand.Read(binding)
+		require.NoError(t, err)
+		res, err := client.Attest(ctx, &AttestRequest{UserData: binding})
+		require.NoError(t, err)
+		auth := &authn.Authenticator{Options: attestation.VerifyOptions{Roots: sim.Roots()}}
+		_, err = auth.Authenticate(base64.StdEncoding.EncodeToString(res.Document), "", binding)
+		require.EqualError(t, err, "attestation rejected: document is not bound to this connection")
+	})
+
+	t.Run("no nsm", func(t *testing.T) {
+		c := NewAttestationServiceClient(serve(t, &Service{}))
+		_, err := c.Attest(ctx, &AttestRequest{})
+		requireCode(t, err, codes.Unavailable, "nsm unavailable")
+	})
+}
+
+func TestGateway(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0b}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gw, err := NewGateway(ctx, serve(t, &Service{Session: sim, Roots: sim.Roots()}))
+	require.NoError(t, err)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	post := func(t *testing.T, path string, body interface{}, into interface{}) int {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		res, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(b))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.NoError(t, json.NewDecoder(res.Body).Decode(into))
+		return res.StatusCode
+	}
+	b64 := base64.StdEncoding.EncodeToString
+
+	// attest issues a nonce and a document answering it, so that every subtest has its
+	// own.
+	attest := func(t *testing.T) (string, string) {
+		var nonce struct {
+			Nonce     string `json:"nonce"`
+			ExpiresAt string `json:"expires_at"`
+		}
+		require.Equal(t, http.StatusOK, post(t, "/v1/nonces", map[string]interface{}{}, &nonce))
+		require.NotEmpty(t, nonce.Nonce)
+		_, err := time.Parse(time.RFC3339Nano, nonce.ExpiresAt)
+		require.NoError(t, err)
+		var attested struct {
+			Document string `json:"document"`
+		}
+		require.Equal(t, http.StatusOK, post(t, "/v1/attestations", map[string]string{"nonce": nonce.Nonce}, &attested))
+		return nonce.Nonce, attested.Document
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		nonce, doc := attest(t)
+		var verified struct {
+			Document struct {
+				ModuleID string            `json:"module_id"`
+				PCRs     map[string]string `json:"pcrs"`
+				Nonce    string            `json:"nonce"`
+			} `json:"document"`
+		}
+		body := map[string]interface{}{
+			"document": doc,
+			"nonce":    nonce,
+			"pcrs":     map[string]string{"0": b64(pcr)},
+		}
+		require.Equal(t, http.StatusOK, post(t, "/v1/attestations:verify", body, &verified))
+		require.Equal(t, sim.ModuleID, verified.Document.ModuleID)
+		require.Equal(t, b64(pcr), verified.Document.PCRs["0"])
+		require.Equal(t, nonce, verified.Document.Nonce)
+	})
+
+	t.Run("errors map to http status", func(t *testing.T) {
+		var failed struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		nonce, doc := attest(t)
+		body := map[string]string{"document": doc, "nonce": nonce}
+		require.Equal(t, http.StatusOK, post(t, "/v1/attestations:verify", body, &struct{}{}))
+		require.Equal(t, http.StatusBadRequest, post(t, "/v1/attestations:verify", body, &failed))
+		require.Equal(t, int(codes.FailedPrecondition), failed.Code)
+		require.Equal(t, "unknown or already used nonce", failed.Message)
+
+		require.Equal(t, http.StatusBadRequest, post(t, "/v1/attestations:inspect", map[string]string{"document": b64([]byte("garbage"))}, &failed))
+		require.Equal(t, int(codes.InvalidArgument), failed.Code)
+	})
+
+	t.Run("inspect", func(t *testing.T) {
+		var inspected struct {
+			Document struct {
+				ModuleID string `json:"module_id"`
+			} `json:"document"`
+		}
+		_, doc := attest(t)
+		require.Equal(t, http.StatusOK, post(t, "/v1/attestations:inspect", map[string]string{"document": doc}, &inspected))
+		require.Equal(t, sim.ModuleID, inspected.Document.ModuleID)
+	})
+}
+
+func timestampIn(d time.Duration) *timestamppb.Timestamp {
+	return timestamppb.New(time.Now().Add(d))
+}
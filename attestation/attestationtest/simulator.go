@@ -0,0 +1,363 @@
+// Package attestationtest provides a simulated Nitro Secure Module for tests. Documents
+// it produces are real COSE_Sign1 attestation documents, signed under a throwaway
+// certificate authority that stands in for the AWS Nitro Enclaves PKI.
+package attestationtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hf/nitrite"
+	"github.com/jessicatrinh/nsm/request"
+	"github.com/jessicatrinh/nsm/response"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPCRs is the number of PCRs the simulated NSM exposes.
+	maxPCRs = 32
+	// bootPCRs is the number of PCRs measured and locked at boot.
+	bootPCRs = 16
+	// caBackdate is how long before its creation the CA is valid, bounding NewSimulatorAt.
+	caBackdate = 30 * 24 * time.Hour
+	// pcrSize is the length of a SHA-384 PCR value.
+	pcrSize = sha512.Size384
+)
+
+// CA is a stand-in for the AWS Nitro Enclaves root of trust.
+type CA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// NewCA creates a self-signed P-384 root certificate authority.
+// Pre: None.
+// Post: A CA or an error is returned.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"Simulated"}, CommonName: "simulated.nitro-enclaves"},
+		NotBefore:             now.Add(-caBackdate),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key}, nil
+}
+
+// Certificate returns the root certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Roots returns a pool holding the root certificate, for use as VerifyOptions.Roots.
+func (ca *CA) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// NewSimulator creates a simulated NSM whose documents chain to the CA.
+// Pre: Parameter pcrs holds the boot measurements; missing PCRs below 16 are all zeroes.
+// Post: A Simulator or an error is returned.
+func (ca *CA) NewSimulator(pcrs map[uint][]byte) (*Simulator, error) {
+	return ca.NewSimulatorAt(pcrs, time.Time{})
+}
+
+// NewSimulatorAt creates a simulated NSM that booted at a past time: its certificate is
+// valid for three hours from an hour before then, and its documents are all stamped with
+// that time. It stands in for an enclave whose certificate has since expired.
+// Pre: Parameter pcrs holds the boot measurements. Parameter at is zero, meaning the
+// present, or at most 30 days before the CA was created.
+// Post: A Simulator or an error is returned.
+func (ca *CA) NewSimulatorAt(pcrs map[uint][]byte, at time.Time) (*Simulator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	moduleID := fmt.Sprintf("i-%016x-enc%016x", serial.Uint64()>>1, serial.Uint64())
+	now := at
+	if now.IsZero() {
+		now = time.Now()
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Simulated"}, CommonName: moduleID},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(3 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Simulator{
+		ModuleID: moduleID,
+		ca:       ca,
+		cert:     der,
+		key:      key,
+		at:       at,
+	}
+	for i := range s.pcrs {
+		s.pcrs[i] = make([]byte, pcrSize)
+		if i < bootPCRs {
+			s.locked[i] = true
+		}
+	}
+	for index, value := range pcrs {
+		if index >= maxPCRs || len(value) != pcrSize {
+			return nil, fmt.Errorf("invalid boot measurement for PCR%d", index)
+		}
+		s.pcrs[index] = append([]byte(nil), value...)
+		s.locked[index] = true
+	}
+	return s, nil
+}
+
+// NewSimulator creates a simulated NSM with its own CA.
+// Pre: Parameter pcrs holds the boot measurements; missing PCRs below 16 are all zeroes.
+// Post: A Simulator or an error is returned.
+func NewSimulator(pcrs map[uint][]byte) (*Simulator, error) {
+	ca, err := NewCA()
+	if err != nil {
+		return nil, err
+	}
+	return ca.NewSimulator(pcrs)
+}
+
+// Simulator implements attestation.Session in memory. It answers Attestation,
+// DescribePCR, ExtendPCR, LockPCR, LockPCRs, DescribeNSM and GetRandom requests the way
+// the Nitro Secure Module does.
+type Simulator struct {
+	// ModuleID is reported in every document.
+	ModuleID string
+
+	ca   *CA
+	cert []byte
+	key  *ecdsa.PrivateKey
+	// at, if set, is the time stamped on every document.
+	at time.Time
+
+	mu     sync.Mutex
+	pcrs   [maxPCRs][]byte
+	locked [maxPCRs]bool
+}
+
+// Roots returns a pool holding the simulator's root certificate.
+func (s *Simulator) Roots() *x509.CertPool {
+	return s.ca.Roots()
+}
+
+// CA returns the certificate authority the simulator's documents chain to.
+func (s *Simulator) CA() *CA {
+	return s.ca
+}
+
+// PCRs returns the current values of the locked PCRs, as reported in documents.
+func (s *Simulator) PCRs() map[uint][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lockedPCRs()
+}
+
+// Read fills into with random bytes.
+func (s *Simulator) Read(into []byte) (int, error) {
+	return rand.Read(into)
+}
+
+// Close is a no-op; a simulator may be reused after Close.
+func (s *Simulator) Close() error {
+	return nil
+}
+
+// Send answers an NSM request.
+func (s *Simulator) Send(req request.Request) (response.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r := req.(type) {
+	case *request.Attestation:
+		if len(r.UserData) > 512 || len(r.Nonce) > 512 || len(r.PublicKey) > 1024 {
+			return response.Response{Error: response.ECInputTooLarge}, nil
+		}
+		doc, err := s.attest(r)
+		if err != nil {
+			return response.Response{}, err
+		}
+		return response.Response{Attestation: &response.Attestation{Document: doc}}, nil
+	case *request.DescribePCR:
+		if int(r.Index) >= maxPCRs {
+			return response.Response{Error: response.ECInvalidArgument}, nil
+		}
+		return response.Response{DescribePCR: &response.DescribePCR{
+			Lock: s.locked[r.Index],
+			Data: append([]byte(nil), s.pcrs[r.Index]...),
+		}}, nil
+	case *request.ExtendPCR:
+		if int(r.Index) >= maxPCRs {
+			return response.Response{Error: response.ECInvalidArgument}, nil
+		}
+		if s.locked[r.Index] {
+			return response.Response{Error: response.ECReadOnlyIndex}, nil
+		}
+		h := sha512.New384()
+		h.Write(s.pcrs[r.Index])
+		h.Write(r.Data)
+		s.pcrs[r.Index] = h.Sum(nil)
+		return response.Response{ExtendPCR: &response.ExtendPCR{Data: append([]byte(nil), s.pcrs[r.Index]...)}}, nil
+	case *request.LockPCR:
+		if int(r.Index) >= maxPCRs {
+			return response.Response{Error: response.ECInvalidArgument}, nil
+		}
+		s.locked[r.Index] = true
+		return response.Response{LockPCR: &response.LockPCR{}}, nil
+	case *request.LockPCRs:
+		if int(r.Range) > maxPCRs {
+			return response.Response{Error: response.ECInvalidArgument}, nil
+		}
+		for i := 0; i < int(r.Range); i++ {
+			s.locked[i] = true
+		}
+		return response.Response{LockPCRs: &response.LockPCRs{}}, nil
+	case *request.DescribeNSM:
+		var locked []uint16
+		for i, l := range s.locked {
+			if l {
+				locked = append(locked, uint16(i))
+			}
+		}
+		return response.Response{DescribeNSM: &response.DescribeNSM{
+			VersionMajor: 1,
+			ModuleID:     s.ModuleID,
+			MaxPCRs:      maxPCRs,
+			LockedPCRs:   locked,
+			Digest:       response.DigestSHA384,
+		}}, nil
+	case *request.GetRandom:
+		random := make([]byte, 256)
+		if _, err := rand.Read(random); err != nil {
+			return response.Response{}, err
+		}
+		return response.Response{GetRandom: &response.GetRandom{Random: random}}, nil
+	}
+	return response.Response{Error: response.ECInvalidOperation}, nil
+}
+
+// lockedPCRs copies the locked PCRs. The caller must hold s.mu.
+func (s *Simulator) lockedPCRs() map[uint][]byte {
+	pcrs := make(map[uint][]byte)
+	for i, value := range s.pcrs {
+		if s.locked[i] {
+			pcrs[uint(i)] = append([]byte(nil), value...)
+		}
+	}
+	return pcrs
+}
+
+// attest builds and signs an attestation document. The caller must hold s.mu.
+func (s *Simulator) attest(r *request.Attestation) ([]byte, error) {
+	now := s.at
+	if now.IsZero() {
+		now = time.Now()
+	}
+	payload, err := cbor.Marshal(&nitrite.Document{
+		ModuleID:    s.ModuleID,
+		Timestamp:   uint64(now.UnixNano() / int64(time.Millisecond)),
+		Digest:      string(response.DigestSHA384),
+		PCRs:        s.lockedPCRs(),
+		Certificate: s.cert,
+		CABundle:    [][]byte{s.ca.cert.Raw},
+		PublicKey:   nonEmpty(r.PublicKey),
+		UserData:    nonEmpty(r.UserData),
+		Nonce:       nonEmpty(r.Nonce),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sign1(s.key, payload)
+}
+
+// nonEmpty maps empty optional fields to nil so that they encode as CBOR null.
+func nonEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// coseSign1 is the COSE_Sign1 structure of an attestation document.
+type coseSign1 struct {
+	_ struct{} `cbor:",toarray"`
+
+	Protected   []byte
+	Unprotected map[interface{}]interface{}
+	Payload     []byte
+	Signature   []byte
+}
+
+// sigStructure is the COSE Sig_structure that is signed.
+type sigStructure struct {
+	_ struct{} `cbor:",toarray"`
+
+	Context     string
+	Protected   []byte
+	ExternalAAD []byte
+	Payload     []byte
+}
+
+// sign1 wraps payload in a COSE_Sign1 structure signed with ES384.
+// Pre: Parameter key is a P-384 signing key. Parameter payload is the CBOR document.
+// Post: The encoded COSE_Sign1 or an error is returned.
+func sign1(key *ecdsa.PrivateKey, payload []byte) ([]byte, error) {
+	protected, err := cbor.Marshal(map[int]int{1: -35})
+	if err != nil {
+		return nil, err
+	}
+	toSign, err := cbor.Marshal(&sigStructure{
+		Context:     "Signature1",
+		Protected:   protected,
+		ExternalAAD: []byte{},
+		Payload:     payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.Sum384(toSign)
+	r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 2*len(digest))
+	r.FillBytes(signature[:len(digest)])
+	sig.FillBytes(signature[len(digest):])
+	return cbor.Marshal(&coseSign1{
+		Protected:   protected,
+		Unprotected: map[interface{}]interface{}{},
+		Payload:     payload,
+		Signature:   signature,
+	})
+}
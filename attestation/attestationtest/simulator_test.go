@@ -0,0 +1,60 @@
+package attestationtest
+
+import (
+	"bytes"
+	"github.com/jessicatrinh/nsm/request"
+	"github.com/jessicatrinh/nsm/response"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"testing"
+)
+
+func TestSimulator(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{1}, 48)
+	sim, err := NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+
+	t.Run("verifiable document", func(t *testing.T) {
+		doc, err := attestation.RetrieveAttestationFrom(sim, []byte{1, 2, 3}, []byte("user"), nil)
+		require.NoError(t, err)
+		res, err := attestation.VerifyDocument(doc, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+		require.Equal(t, pcr0, res.Document.PCRs[0])
+		require.Equal(t, []byte{1, 2, 3}, res.Document.Nonce)
+		require.Equal(t, []byte("user"), res.Document.UserData)
+		require.Nil(t, res.Document.PublicKey)
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		doc, err := attestation.RetrieveAttestationFrom(sim, nil, nil, nil)
+		require.NoError(t, err)
+		other, err := NewCA()
+		require.NoError(t, err)
+		_, err = attestation.VerifyDocument(doc, attestation.VerifyOptions{Roots: other.Roots()})
+		require.Error(t, err)
+	})
+
+	t.Run("extend and lock", func(t *testing.T) {
+		res, err := sim.Send(&request.ExtendPCR{Index: 16, Data: []byte("measured")})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExtendPCR)
+		_, ok := sim.PCRs()[16]
+		require.False(t, ok)
+
+		res, err = sim.Send(&request.LockPCR{Index: 16})
+		require.NoError(t, err)
+		require.NotNil(t, res.LockPCR)
+		require.Contains(t, sim.PCRs(), uint(16))
+
+		res, err = sim.Send(&request.ExtendPCR{Index: 16, Data: []byte("again")})
+		require.NoError(t, err)
+		require.Equal(t, response.ECReadOnlyIndex, res.Error)
+	})
+
+	t.Run("boot PCRs are read only", func(t *testing.T) {
+		res, err := sim.Send(&request.ExtendPCR{Index: 0, Data: []byte("x")})
+		require.NoError(t, err)
+		require.Equal(t, response.ECReadOnlyIndex, res.Error)
+	})
+}
@@ -0,0 +1,169 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ModuleIDScope says how closely a bootstrapped policy pins the module ID.
+type ModuleIDScope string
+
+const (
+	// ModuleIDAny admits any well-formed module ID.
+	ModuleIDAny ModuleIDScope = "any"
+	// ModuleIDInstance admits enclaves on the reference document's parent instance.
+	ModuleIDInstance ModuleIDScope = "instance"
+	// ModuleIDExact admits only the reference document's enclave.
+	ModuleIDExact ModuleIDScope = "exact"
+)
+
+// pcrMeanings describes the PCRs Nitro Enclaves defines, for policy comments.
+var pcrMeanings = map[uint]string{
+	0: "enclave image",
+	1: "kernel and bootstrap",
+	2: "application",
+	3: "parent instance IAM role",
+	4: "parent instance ID",
+	8: "image signing certificate",
+}
+
+// BootstrapOptions controls BootstrapPolicy.
+type BootstrapOptions struct {
+	// PCRs are the indices to pin. If empty, PCR0, PCR1 and PCR2 are pinned.
+	PCRs []uint
+	// Name labels the build, e.g. with a release tag. If empty, the reference
+	// document's module ID and timestamp are used.
+	Name string
+	// ModuleID selects the module ID pattern. If empty, ModuleIDAny is used.
+	ModuleID ModuleIDScope
+	// AllowDebug admits debug-mode enclaves. Without it a debug-mode reference
+	// document is refused, since its PCRs are all zeroes.
+	AllowDebug bool
+	// Now is the time recorded in the comment. If zero, the current time is used.
+	Now time.Time
+}
+
+// BootstrapPolicy derives a policy from a trusted reference document: an attestation
+// made in a controlled environment by the build to be approved. The policy admits that
+// build alone; Merge adds later builds to it.
+// Pre: Parameter res was returned by VerifyDocument. Parameter root is the root it
+// chains to, as returned by ChainRoots.
+// Post: The policy or an error is returned.
+func BootstrapPolicy(res *nitrite.Result, root *x509.Certificate, opts BootstrapOptions) (*Policy, error) {
+	doc := res.Document
+	if IsDebug(doc) && !opts.AllowDebug {
+		return nil, errors.New("reference document comes from a debug-mode enclave")
+	}
+	indices := opts.PCRs
+	if len(indices) == 0 {
+		indices = []uint{0, 1, 2}
+	}
+	build := Build{Name: opts.Name, PCRs: map[uint]Measurement{}}
+	if build.Name == "" {
+		build.Name = fmt.Sprintf("%s at %s", doc.ModuleID, DocumentTime(doc).UTC().Format(time.RFC3339))
+	}
+	for _, index := range indices {
+		value, ok := doc.PCRs[index]
+		if !ok {
+			return nil, fmt.Errorf("PCR%d missing from reference document", index)
+		}
+		build.PCRs[index] = append(Measurement(nil), value...)
+	}
+	pattern, err := moduleIDPattern(doc.ModuleID, opts.ModuleID)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{
+		Builds:          []Build{build},
+		RootFingerprint: RootFingerprint(root),
+		ModuleID:        pattern,
+		Debug:           DebugDeny,
+	}
+	if opts.AllowDebug {
+		policy.Debug = DebugAllow
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	pinned := make([]string, 0, len(indices))
+	for _, index := range sortedIndices(build.PCRs) {
+		if meaning, ok := pcrMeanings[index]; ok {
+			pinned = append(pinned, fmt.Sprintf("PCR%d (%s)", index, meaning))
+		} else {
+			pinned = append(pinned, fmt.Sprintf("PCR%d", index))
+		}
+	}
+	stance := "are rejected"
+	if opts.AllowDebug {
+		stance = "are admitted; do not use this policy in production"
+	}
+	policy.Comment = []string{
+		fmt.Sprintf("Bootstrapped on %s from a verified reference attestation document.", now.UTC().Format(time.RFC3339)),
+		fmt.Sprintf("Enclaves must report the %s of one of the builds.", strings.Join(pinned, ", ")),
+		fmt.Sprintf("Documents must chain to the root %q, whose SHA-256 is root_fingerprint.", root.Subject.String()),
+		fmt.Sprintf("Module IDs must match module_id (%s).", scopeOrDefault(opts.ModuleID)),
+		fmt.Sprintf("Debug-mode enclaves %s.", stance),
+	}
+	return policy, nil
+}
+
+// Merge adds the builds of another policy, typically one bootstrapped from a new
+// release, to the policy's allowlist. Builds it already admits are skipped.
+// Pre: Parameter other makes the same requirements as the policy apart from its builds.
+// Post: The number of builds added is returned, or an error if the policies disagree,
+// in which case the policy is unchanged.
+func (p *Policy) Merge(other *Policy) (int, error) {
+	switch {
+	case !equalPCRs(p.PCRs, other.PCRs):
+		return 0, errors.New("cannot merge policies requiring different common PCRs")
+	case !sameFingerprint(p.RootFingerprint, other.RootFingerprint):
+		return 0, errors.New("cannot merge policies trusting different roots")
+	case p.ModuleID != other.ModuleID:
+		return 0, errors.New("cannot merge policies with different module ID patterns")
+	case p.Debug != other.Debug:
+		return 0, errors.New("cannot merge policies with different debug stances")
+	}
+	added := 0
+next:
+	for _, build := range other.Builds {
+		for _, existing := range p.Builds {
+			if equalPCRs(existing.PCRs, build.PCRs) {
+				continue next
+			}
+		}
+		p.Builds = append(p.Builds, build)
+		added++
+	}
+	return added, nil
+}
+
+// moduleIDPattern returns the module ID regular expression for a scope.
+func moduleIDPattern(moduleID string, scope ModuleIDScope) (string, error) {
+	switch scope {
+	case "", ModuleIDAny:
+		return `^i-[0-9a-f]+-enc[0-9a-f]+$`, nil
+	case ModuleIDInstance:
+		i := strings.LastIndex(moduleID, "-enc")
+		if i < 0 {
+			return "", fmt.Errorf("module ID %s does not name a parent instance", moduleID)
+		}
+		return "^" + regexp.QuoteMeta(moduleID[:i+len("-enc")]) + "[0-9a-f]+$", nil
+	case ModuleIDExact:
+		return "^" + regexp.QuoteMeta(moduleID) + "$", nil
+	}
+	return "", fmt.Errorf("unknown module ID scope %q", scope)
+}
+
+func scopeOrDefault(scope ModuleIDScope) ModuleIDScope {
+	if scope == "" {
+		return ModuleIDAny
+	}
+	return scope
+}
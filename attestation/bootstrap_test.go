@@ -0,0 +1,132 @@
+package attestation
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+	"time"
+)
+
+func TestBootstrapPolicy(t *testing.T) {
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	reference := func(t *testing.T, pcrs map[uint][]byte) (*attestationtest.Simulator, VerifyOptions, *Policy) {
+		sim, err := ca.NewSimulator(pcrs)
+		require.NoError(t, err)
+		doc, err := RetrieveAttestationFrom(sim, nil, nil, nil)
+		require.NoError(t, err)
+		opts := VerifyOptions{Roots: ca.Roots()}
+		res, err := VerifyDocument(doc, opts)
+		require.NoError(t, err)
+		roots, err := ChainRoots(res, opts)
+		require.NoError(t, err)
+		require.Len(t, roots, 1)
+		require.Equal(t, ca.Certificate().Raw, roots[0].Raw)
+		policy, err := BootstrapPolicy(res, roots[0], BootstrapOptions{Name: "v1"})
+		require.NoError(t, err)
+		return sim, opts, policy
+	}
+	v1 := map[uint][]byte{0: bytes.Repeat([]byte{1}, 48), 1: bytes.Repeat([]byte{2}, 48), 2: bytes.Repeat([]byte{3}, 48)}
+	v2 := map[uint][]byte{0: bytes.Repeat([]byte{4}, 48), 1: v1[1], 2: bytes.Repeat([]byte{5}, 48)}
+
+	t.Run("bootstrap", func(t *testing.T) {
+		sim, opts, policy := reference(t, v1)
+		require.Equal(t, []Build{{Name: "v1", PCRs: map[uint]Measurement{0: v1[0], 1: v1[1], 2: v1[2]}}}, policy.Builds)
+		require.Equal(t, RootFingerprint(ca.Certificate()), policy.RootFingerprint)
+		require.Equal(t, DebugDeny, policy.Debug)
+		require.Len(t, policy.Comment, 5)
+		require.Contains(t, policy.Comment[1], "PCR0 (enclave image), PCR1 (kernel and bootstrap), PCR2 (application)")
+
+		// Another enclave running the same build satisfies the policy.
+		other, err := ca.NewSimulator(v1)
+		require.NoError(t, err)
+		require.NotEqual(t, sim.ModuleID, other.ModuleID)
+		doc, err := RetrieveAttestationFrom(other, nil, nil, nil)
+		require.NoError(t, err)
+		opts.Policy = policy
+		_, err = VerifyDocument(doc, opts)
+		require.NoError(t, err)
+
+		// A different build does not.
+		other, err = ca.NewSimulator(v2)
+		require.NoError(t, err)
+		doc, err = RetrieveAttestationFrom(other, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = VerifyDocument(doc, opts)
+		require.EqualError(t, err, "PCRs match none of the 1 allowed builds")
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		_, opts, policy := reference(t, v1)
+		_, _, next := reference(t, v2)
+		added, err := policy.Merge(next)
+		require.NoError(t, err)
+		require.Equal(t, 1, added)
+		require.Len(t, policy.Builds, 2)
+		added, err = policy.Merge(next)
+		require.NoError(t, err)
+		require.Zero(t, added, "a build already allowed is not added twice")
+
+		for _, pcrs := range []map[uint][]byte{v1, v2} {
+			sim, err := ca.NewSimulator(pcrs)
+			require.NoError(t, err)
+			doc, err := RetrieveAttestationFrom(sim, nil, nil, nil)
+			require.NoError(t, err)
+			opts.Policy = policy
+			_, err = VerifyDocument(doc, opts)
+			require.NoError(t, err)
+		}
+
+		next.Debug = DebugAllow
+		_, err = policy.Merge(next)
+		require.EqualError(t, err, "cannot merge policies with different debug stances")
+		next.Debug, next.RootFingerprint = DebugDeny, "00"
+		_, err = policy.Merge(next)
+		require.EqualError(t, err, "cannot merge policies trusting different roots")
+		require.Len(t, policy.Builds, 2)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		sim, err := ca.NewSimulator(v1)
+		require.NoError(t, err)
+		doc, err := RetrieveAttestationFrom(sim, nil, nil, nil)
+		require.NoError(t, err)
+		res, err := VerifyDocument(doc, VerifyOptions{Roots: ca.Roots()})
+		require.NoError(t, err)
+		now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+		policy, err := BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{PCRs: []uint{4, 0}, ModuleID: ModuleIDExact, Now: now})
+		require.NoError(t, err)
+		require.Equal(t, []uint{0, 4}, policy.Indices())
+		require.Contains(t, policy.Builds[0].Name, sim.ModuleID)
+		require.Contains(t, policy.Comment[0], "2021-11-01T12:00:00Z")
+		require.Equal(t, "^"+sim.ModuleID+"$", policy.ModuleID)
+		require.NoError(t, policy.Check(res.Document))
+
+		policy, err = BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{ModuleID: ModuleIDInstance})
+		require.NoError(t, err)
+		require.NoError(t, policy.Check(res.Document))
+		require.Regexp(t, `^\^i-[0-9a-f]+-enc\[0-9a-f\]\+\$$`, policy.ModuleID)
+
+		_, err = BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{ModuleID: "rack"})
+		require.EqualError(t, err, `unknown module ID scope "rack"`)
+		_, err = BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{PCRs: []uint{20}})
+		require.EqualError(t, err, "PCR20 missing from reference document")
+	})
+
+	t.Run("debug-mode reference", func(t *testing.T) {
+		sim, err := ca.NewSimulator(nil)
+		require.NoError(t, err)
+		doc, err := RetrieveAttestationFrom(sim, nil, nil, nil)
+		require.NoError(t, err)
+		res, err := VerifyDocument(doc, VerifyOptions{Roots: ca.Roots()})
+		require.NoError(t, err)
+		_, err = BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{})
+		require.EqualError(t, err, "reference document comes from a debug-mode enclave")
+		policy, err := BootstrapPolicy(res, ca.Certificate(), BootstrapOptions{AllowDebug: true})
+		require.NoError(t, err)
+		require.Equal(t, DebugAllow, policy.Debug)
+		require.NoError(t, policy.Check(res.Document))
+	})
+}
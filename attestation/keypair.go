@@ -0,0 +1,254 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/curve25519"
+	"io"
+	"time"
+)
+
+// KeyAlgorithm names the kind of keypair produced by GenerateKeypair.
+type KeyAlgorithm string
+
+// Supported key algorithms.
+const (
+	P256    KeyAlgorithm = "P-256"
+	P384    KeyAlgorithm = "P-384"
+	Ed25519 KeyAlgorithm = "Ed25519"
+	X25519  KeyAlgorithm = "X25519"
+	RSA     KeyAlgorithm = "RSA"
+)
+
+// rsaKeyBits is the modulus size of RSA keypairs.
+const rsaKeyBits = 2048
+
+// oidX25519 identifies X25519 keys in a SubjectPublicKeyInfo (RFC 8410).
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// X25519PublicKey is a Curve25519 public key used for key agreement.
+type X25519PublicKey []byte
+
+// X25519PrivateKey is a Curve25519 private key. It can neither sign nor decrypt; use
+// SharedKey to agree on a secret with a peer.
+type X25519PrivateKey struct {
+	scalar []byte
+	public X25519PublicKey
+}
+
+// NewX25519PrivateKey creates an X25519 private key from its scalar.
+// Pre: Parameter scalar is 32 bytes.
+// Post: The private key or an error is returned.
+func NewX25519PrivateKey(scalar []byte) (*X25519PrivateKey, error) {
+	if len(scalar) != curve25519.ScalarSize {
+		return nil, errors.New("invalid X25519 private key length")
+	}
+	public, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519PrivateKey{scalar: append([]byte(nil), scalar...), public: public}, nil
+}
+
+// Public returns the X25519PublicKey corresponding to k.
+func (k *X25519PrivateKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// SharedKey performs X25519 key agreement.
+// Pre: Parameter peer is the other party's public key.
+// Post: The 32 byte shared secret or an error is returned.
+func (k *X25519PrivateKey) SharedKey(peer X25519PublicKey) ([]byte, error) {
+	return curve25519.X25519(k.scalar, peer)
+}
+
+// pkixPublicKey mirrors the SubjectPublicKeyInfo structure for algorithms crypto/x509
+// does not know about.
+type pkixPublicKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	BitString asn1.BitString
+}
+
+// pkcs8PrivateKey mirrors the PKCS #8 PrivateKeyInfo structure for algorithms crypto/x509
+// does not know about.
+type pkcs8PrivateKey struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// GenerateKeypairFrom creates a keypair of the given algorithm using entropy from rand.
+// Pre: Parameter rand is a source of entropy, such as a Session. Parameter alg is a
+// supported KeyAlgorithm.
+// Post: The PKIX DER encoded public key and the private key are returned, or an error is
+// returned.
+func GenerateKeypairFrom(rand io.Reader, alg KeyAlgorithm) ([]byte, crypto.PrivateKey, error) {
+	start := time.Now()
+	xpub, xprv, class, err := generateKeypair(rand, alg)
+	observe(OpGenerateKeypair, start, class)
+	if err != nil {
+		logEntry(LevelError, "keypair generation failed", Field{Key: FieldAlgorithm, Value: string(alg)}, CheckField(class), ErrorField(err))
+	} else {
+		logEntry(LevelDebug, "keypair generated", Field{Key: FieldAlgorithm, Value: string(alg)}, Field{Key: FieldPublicKey, Value: xpub})
+	}
+	return xpub, xprv, err
+}
+
+// generateKeypair implements GenerateKeypairFrom, classifying its failures.
+func generateKeypair(rand io.Reader, alg KeyAlgorithm) ([]byte, crypto.PrivateKey, ErrorClass, error) {
+	var xprv crypto.PrivateKey
+	switch alg {
+	case P256, P384:
+		curve := elliptic.P256()
+		if alg == P384 {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, rand)
+		if err != nil {
+			return nil, nil, ClassEntropy, err
+		}
+		xprv = key
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand)
+		if err != nil {
+			return nil, nil, ClassEntropy, err
+		}
+		xprv = key
+	case X25519:
+		scalar := make([]byte, curve25519.ScalarSize)
+		if _, err := io.ReadFull(rand, scalar); err != nil {
+			return nil, nil, ClassEntropy, err
+		}
+		key, err := NewX25519PrivateKey(scalar)
+		if err != nil {
+			return nil, nil, ClassEntropy, err
+		}
+		xprv = key
+	case RSA:
+		key, err := rsa.GenerateKey(rand, rsaKeyBits)
+		if err != nil {
+			return nil, nil, ClassEntropy, err
+		}
+		xprv = key
+	default:
+		return nil, nil, ClassUnsupported, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+	xpub, err := MarshalPublicKey(xprv.(interface{ Public() crypto.PublicKey }).Public())
+	if err != nil {
+		return nil, nil, ClassUnsupported, err
+	}
+	return xpub, xprv, ClassNone, nil
+}
+
+// MarshalPublicKey encodes a public key as PKIX DER, the form placed in the public_key
+// field of an attestation request.
+// Pre: Parameter pub is an ECDSA, Ed25519, RSA or X25519 public key.
+// Post: The DER bytes or an error is returned.
+func MarshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	if key, ok := pub.(X25519PublicKey); ok {
+		return asn1.Marshal(pkixPublicKey{
+			Algo:      pkix.AlgorithmIdentifier{Algorithm: oidX25519},
+			BitString: asn1.BitString{Bytes: key, BitLength: 8 * len(key)},
+		})
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// MarshalPrivateKey encodes a private key as PKCS #8 DER, X25519 keys as in RFC 8410.
+// Pre: Parameter priv is a private key returned by GenerateKeypair.
+// Post: The DER bytes or an error is returned.
+func MarshalPrivateKey(priv crypto.PrivateKey) ([]byte, error) {
+	if key, ok := priv.(*X25519PrivateKey); ok {
+		scalar, err := asn1.Marshal(key.scalar)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8PrivateKey{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oidX25519},
+			PrivateKey: scalar,
+		})
+	}
+	return x509.MarshalPKCS8PrivateKey(priv)
+}
+
+// ParsePrivateKey decodes a PKCS #8 DER private key, as written by MarshalPrivateKey.
+// Pre: Parameter der is the encoded private key.
+// Post: The private key and its KeyAlgorithm are returned, or an error is returned.
+func ParsePrivateKey(der []byte) (crypto.PrivateKey, KeyAlgorithm, error) {
+	var info pkcs8PrivateKey
+	if _, err := asn1.Unmarshal(der, &info); err == nil && info.Algo.Algorithm.Equal(oidX25519) {
+		var scalar []byte
+		if rest, err := asn1.Unmarshal(info.PrivateKey, &scalar); err != nil || len(rest) != 0 {
+			return nil, "", errors.New("could not parse private key: malformed X25519 key")
+		}
+		key, err := NewX25519PrivateKey(scalar)
+		if err != nil {
+			return nil, "", err
+		}
+		return key, X25519, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not parse private key")
+	}
+	alg, err := KeyAlgorithmOf(key.(interface{ Public() crypto.PublicKey }).Public())
+	if err != nil {
+		return nil, "", err
+	}
+	return key, alg, nil
+}
+
+// ParsePublicKey decodes a PKIX DER public key, as found in the public_key field of an
+// attestation document.
+// Pre: Parameter der is the encoded public key.
+// Post: The public key and its KeyAlgorithm are returned, or an error is returned.
+func ParsePublicKey(der []byte) (crypto.PublicKey, KeyAlgorithm, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err == nil && len(rest) == 0 &&
+		spki.Algo.Algorithm.Equal(oidX25519) {
+		if len(spki.BitString.Bytes) != curve25519.PointSize {
+			return nil, "", errors.New("invalid X25519 public key length")
+		}
+		return X25519PublicKey(spki.BitString.Bytes), X25519, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not parse public key")
+	}
+	alg, err := KeyAlgorithmOf(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, alg, nil
+}
+
+// KeyAlgorithmOf reports which KeyAlgorithm a public key belongs to.
+// Pre: Parameter pub is a public key.
+// Post: The KeyAlgorithm or an error is returned.
+func KeyAlgorithmOf(pub crypto.PublicKey) (KeyAlgorithm, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return P256, nil
+		case elliptic.P384():
+			return P384, nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	case X25519PublicKey:
+		return X25519, nil
+	case *rsa.PublicKey:
+		return RSA, nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", pub)
+}
@@ -0,0 +1,91 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGenerateKeypairAlgorithms(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{P256, P384, Ed25519, X25519, RSA} {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			xpub, xprv, err := GenerateKeypairFrom(rand.Reader, alg)
+			require.NoError(t, err)
+			require.NotEmpty(t, xpub)
+
+			pub, parsedAlg, err := ParsePublicKey(xpub)
+			require.NoError(t, err)
+			require.Equal(t, alg, parsedAlg)
+			require.Equal(t, xprv.(interface{ Public() crypto.PublicKey }).Public(), pub)
+
+			der, err := MarshalPrivateKey(xprv)
+			require.NoError(t, err)
+			parsed, parsedAlg, err := ParsePrivateKey(der)
+			require.NoError(t, err)
+			require.Equal(t, alg, parsedAlg)
+			require.Equal(t, xprv, parsed)
+
+			if alg == X25519 {
+				require.IsType(t, &X25519PrivateKey{}, xprv)
+				return
+			}
+			signer, ok := xprv.(crypto.Signer)
+			require.True(t, ok)
+			msg := []byte("attested")
+			digest := sha256.Sum256(msg)
+			var opts crypto.SignerOpts = crypto.SHA256
+			signed := digest[:]
+			if alg == Ed25519 {
+				opts = crypto.Hash(0)
+				signed = msg
+			}
+			sig, err := signer.Sign(rand.Reader, signed, opts)
+			require.NoError(t, err)
+			require.NotEmpty(t, sig)
+		})
+	}
+
+	t.Run("RSA decrypter", func(t *testing.T) {
+		xpub, xprv, err := GenerateKeypairFrom(rand.Reader, RSA)
+		require.NoError(t, err)
+		pub, _, err := ParsePublicKey(xpub)
+		require.NoError(t, err)
+		ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub.(*rsa.PublicKey), []byte("secret"), nil)
+		require.NoError(t, err)
+		plaintext, err := xprv.(crypto.Decrypter).Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+		require.NoError(t, err)
+		require.Equal(t, []byte("secret"), plaintext)
+	})
+
+	t.Run("X25519 agreement", func(t *testing.T) {
+		_, a, err := GenerateKeypairFrom(rand.Reader, X25519)
+		require.NoError(t, err)
+		_, b, err := GenerateKeypairFrom(rand.Reader, X25519)
+		require.NoError(t, err)
+		alice, bob := a.(*X25519PrivateKey), b.(*X25519PrivateKey)
+		ab, err := alice.SharedKey(bob.Public().(X25519PublicKey))
+		require.NoError(t, err)
+		ba, err := bob.SharedKey(alice.Public().(X25519PublicKey))
+		require.NoError(t, err)
+		require.Equal(t, ab, ba)
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		_, _, err := GenerateKeypairFrom(rand.Reader, "DSA")
+		require.EqualError(t, err, `unsupported key algorithm "DSA"`)
+	})
+
+	t.Run("malformed public key", func(t *testing.T) {
+		_, _, err := ParsePublicKey([]byte{4, 1, 2, 3})
+		require.Error(t, err)
+	})
+
+	t.Run("malformed private key", func(t *testing.T) {
+		_, _, err := ParsePrivateKey([]byte{4, 1, 2, 3})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,158 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lower case name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Keys of the fields this package logs.
+const (
+	FieldModuleID  = "module_id"
+	FieldNonce     = "nonce"
+	FieldCheck     = "check"
+	FieldError     = "error"
+	FieldUserData  = "user_data"
+	FieldPublicKey = "public_key"
+	FieldAlgorithm = "algorithm"
+)
+
+// redacted replaces values that must not be logged.
+const redacted = "[redacted]"
+
+// sensitiveKeys are field keys whose values are never logged as they are.
+var sensitiveKeys = map[string]bool{
+	FieldUserData: true,
+	"private_key": true,
+	"secret":      true,
+	"password":    true,
+}
+
+// Field is a key and value attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the structured log entries of this package. Package logging adapts
+// zap, logrus and the standard library's loggers.
+type Logger interface {
+	// Log writes one entry. Field values have already been redacted: the values of
+	// sensitive keys such as user_data and private keys are replaced, and other byte
+	// strings are replaced by their fingerprint and length. It must be safe for
+	// concurrent use.
+	Log(level Level, msg string, fields []Field)
+}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger
+)
+
+// SetLogger installs the logger of this package, replacing any previous one. Nil
+// discards log entries, which is the default, except that LogIfError then prints to the
+// console.
+// Pre: None.
+// Post: Entries logged afterwards go to l.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// ModuleIDField names the enclave a log entry is about.
+func ModuleIDField(id string) Field {
+	return Field{Key: FieldModuleID, Value: id}
+}
+
+// NonceField identifies a nonce by its fingerprint. An absent nonce has no value.
+func NonceField(nonce []byte) Field {
+	if len(nonce) == 0 {
+		return Field{Key: FieldNonce}
+	}
+	return Field{Key: FieldNonce, Value: Fingerprint(nonce)}
+}
+
+// CheckField names the verification check a log entry is about, using the same names
+// as the error classes.
+func CheckField(class ErrorClass) Field {
+	return Field{Key: FieldCheck, Value: string(class)}
+}
+
+// ErrorField holds the message of an error.
+func ErrorField(err error) Field {
+	return Field{Key: FieldError, Value: err.Error()}
+}
+
+// Fingerprint identifies bytes in logs without revealing them: the first eight bytes of
+// their SHA-256, in hex.
+func Fingerprint(b []byte) string {
+	digest := sha256.Sum256(b)
+	return hex.EncodeToString(digest[:8])
+}
+
+// logEntry redacts fields and passes the entry to the logger, if any. It reports whether
+// a logger took the entry.
+func logEntry(level Level, msg string, fields ...Field) bool {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	if l == nil {
+		return false
+	}
+	for i, f := range fields {
+		fields[i] = redact(f)
+	}
+	l.Log(level, msg, fields)
+	return true
+}
+
+// redact makes a field safe to log.
+func redact(f Field) Field {
+	if f.Value == nil {
+		return f
+	}
+	if sensitiveKeys[f.Key] {
+		if b, ok := f.Value.([]byte); ok {
+			return Field{Key: f.Key, Value: fmt.Sprintf("%s (%d bytes)", redacted, len(b))}
+		}
+		return Field{Key: f.Key, Value: redacted}
+	}
+	switch v := f.Value.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey, *rsa.PrivateKey, *X25519PrivateKey:
+		return Field{Key: f.Key, Value: redacted}
+	case []byte:
+		return Field{Key: f.Key, Value: fmt.Sprintf("sha256:%s (%d bytes)", Fingerprint(v), len(v))}
+	}
+	return f
+}
@@ -19,14 +19,20 @@ type Nonce struct {
 // Pre: Parameter secs is the number of seconds for which the nonce will be valid.
 // Post: A Nonce object or error is returned.
 func CreateNonce(secs time.Duration) (*Nonce, error) {
+	start := time.Now()
 	random, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
+		observe(OpCreateNonce, start, ClassEntropy)
+		logEntry(LevelError, "nonce creation failed", ErrorField(err))
 		return nil, err
 	}
-	return &Nonce{
+	observe(OpCreateNonce, start, ClassNone)
+	n := &Nonce{
 		Value:      random.Bytes(),
 		Expiration: time.Now().Add(secs),
-	}, nil
+	}
+	logEntry(LevelDebug, "nonce created", NonceField(n.Value), Field{Key: "expires", Value: n.Expiration})
+	return n, nil
 }
 
 // isExpiredNonce checks whether a nonce has expired in relation to the current time.
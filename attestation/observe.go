@@ -0,0 +1,96 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"github.com/hf/nitrite"
+	"sync"
+	"time"
+)
+
+// Operation names an instrumented attestation operation.
+type Operation string
+
+// Instrumented operations.
+const (
+	OpRetrieveAttestation Operation = "retrieve_attestation"
+	OpGenerateKeypair     Operation = "generate_keypair"
+	OpCreateNonce         Operation = "create_nonce"
+	OpVerify              Operation = "verify"
+	OpRevocationCheck     Operation = "revocation_check"
+)
+
+// ErrorClass says why an operation failed, coarsely enough to label metrics with.
+type ErrorClass string
+
+// Error classes. ClassNone means the operation succeeded.
+const (
+	ClassNone ErrorClass = ""
+	// ClassNSMUnavailable: the NSM could not be reached.
+	ClassNSMUnavailable ErrorClass = "nsm_unavailable"
+	// ClassNSMError: the NSM refused the request or answered unexpectedly.
+	ClassNSMError ErrorClass = "nsm_error"
+	// ClassEntropy: random bytes could not be read.
+	ClassEntropy ErrorClass = "entropy"
+	// ClassUnsupported: the request named something unsupported, such as a key algorithm.
+	ClassUnsupported ErrorClass = "unsupported"
+	// ClassMalformed: the document could not be decoded.
+	ClassMalformed ErrorClass = "malformed"
+	// ClassSignature: the document's signature is invalid.
+	ClassSignature ErrorClass = "signature"
+	// ClassCertificate: the certificate chain does not verify.
+	ClassCertificate ErrorClass = "certificate"
+	// ClassNonce: the nonce does not match or has expired.
+	ClassNonce ErrorClass = "nonce"
+	// ClassPolicy: the document does not satisfy the policy.
+	ClassPolicy ErrorClass = "policy"
+	// ClassRevoked: a certificate in the chain was revoked.
+	ClassRevoked ErrorClass = "revoked"
+	// ClassRevocationUnavailable: revocation status could not be determined.
+	ClassRevocationUnavailable ErrorClass = "revocation_unavailable"
+)
+
+// Observer is told the outcome and duration of every instrumented operation. Package
+// metrics provides one that exports Prometheus metrics.
+type Observer interface {
+	// Observe records one operation. Parameter class is ClassNone on success. It is
+	// called synchronously and must be quick and safe for concurrent use.
+	Observe(op Operation, d time.Duration, class ErrorClass)
+}
+
+var (
+	observerMu sync.RWMutex
+	observer   Observer
+)
+
+// SetObserver installs the observer of this package's operations, replacing any
+// previous one. Nil stops observation, which is the default.
+// Pre: None.
+// Post: Operations that start afterwards are reported to o.
+func SetObserver(o Observer) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+	observer = o
+}
+
+// observe reports an operation that began at start to the observer, if any.
+func observe(op Operation, start time.Time, class ErrorClass) {
+	observerMu.RLock()
+	o := observer
+	observerMu.RUnlock()
+	if o != nil {
+		o.Observe(op, time.Since(start), class)
+	}
+}
+
+// classifyVerifyError classifies an error returned by nitrite.Verify.
+func classifyVerifyError(err error) ErrorClass {
+	switch err.(type) {
+	case x509.CertificateInvalidError, x509.UnknownAuthorityError, x509.HostnameError,
+		x509.ConstraintViolationError, x509.UnhandledCriticalExtension:
+		return ClassCertificate
+	}
+	if err == nitrite.ErrBadSignature {
+		return ClassSignature
+	}
+	return ClassMalformed
+}
@@ -0,0 +1,47 @@
+package attestation
+
+import (
+	"crypto/sha256"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/hf/nitrite"
+	"time"
+)
+
+// coseSign1 is the outer COSE_Sign1 structure of an attestation document.
+type coseSign1 struct {
+	_ struct{} `cbor:",toarray"`
+
+	Protected   []byte
+	Unprotected cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+// ParseDocument decodes an attestation document WITHOUT verifying it. Use it to inspect
+// a document or to learn when it was produced; never trust its contents before
+// VerifyDocument succeeds.
+// Pre: Parameter doc is the COSE encoded attestation document.
+// Post: The decoded document or an error is returned.
+func ParseDocument(doc []byte) (*nitrite.Document, error) {
+	var cose coseSign1
+	if err := cbor.Unmarshal(doc, &cose); err != nil {
+		return nil, nitrite.ErrBadCOSESign1Structure
+	}
+	parsed := &nitrite.Document{}
+	if err := cbor.Unmarshal(cose.Payload, parsed); err != nil {
+		return nil, nitrite.ErrBadAttestationDocument
+	}
+	return parsed, nil
+}
+
+// DocumentTime converts the timestamp of an attestation document to a time.Time.
+func DocumentTime(doc *nitrite.Document) time.Time {
+	return time.Unix(0, int64(doc.Timestamp)*int64(time.Millisecond))
+}
+
+// DocumentHash computes the SHA-256 digest of a COSE encoded attestation document. It
+// identifies the exact document a message is bound to.
+func DocumentHash(doc []byte) []byte {
+	digest := sha256.Sum256(doc)
+	return digest[:]
+}
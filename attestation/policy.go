@@ -0,0 +1,244 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Measurement is a PCR value. It is written as hex in JSON, matching how nitro-cli
+// reports PCRs.
+type Measurement []byte
+
+// MarshalText encodes the measurement as hex.
+func (m Measurement) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(m)), nil
+}
+
+// UnmarshalText decodes a hex measurement.
+func (m *Measurement) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid PCR value: %v", err)
+	}
+	*m = b
+	return nil
+}
+
+// DebugMode is a policy's stance on enclaves running in debug mode, whose documents
+// report all-zero PCRs and whose memory the parent instance can read.
+type DebugMode string
+
+const (
+	// DebugAllow admits debug-mode enclaves.
+	DebugAllow DebugMode = "allow"
+	// DebugDeny rejects debug-mode enclaves.
+	DebugDeny DebugMode = "deny"
+)
+
+// Policy lists what an enclave must attest to for a verifier to trust it. Fields left
+// empty are not checked.
+type Policy struct {
+	// Comment explains the policy to its readers. It is not a requirement and does not
+	// contribute to the ID.
+	Comment []string `json:"comment,omitempty"`
+	// PCRs are the values every document must report.
+	PCRs map[uint]Measurement `json:"pcrs,omitempty"`
+	// Builds, if any, are the approved enclave builds; a document must report the PCRs
+	// of at least one of them.
+	Builds []Build `json:"builds,omitempty"`
+	// RootFingerprint is the hex SHA-256 of the root certificate the document must chain
+	// to, in either case. It is checked by VerifyDocument, which knows the chain.
+	RootFingerprint string `json:"root_fingerprint,omitempty"`
+	// ModuleID is a regular expression the document's module ID must match.
+	ModuleID string `json:"module_id,omitempty"`
+	// Debug says whether debug-mode enclaves are admitted.
+	Debug DebugMode `json:"debug,omitempty"`
+}
+
+// Build is the set of PCR values one approved enclave build reports.
+type Build struct {
+	Name string               `json:"name,omitempty"`
+	PCRs map[uint]Measurement `json:"pcrs"`
+}
+
+// Check confirms that an attestation document satisfies the policy.
+// Pre: Parameter doc is a verified attestation document.
+// Post: Nil is returned if the document satisfies every requirement the policy makes of
+// it, otherwise an error naming the first unmet one is returned.
+func (p *Policy) Check(doc *nitrite.Document) error {
+	switch p.Debug {
+	case "", DebugAllow:
+	case DebugDeny:
+		if IsDebug(doc) {
+			return errors.New("debug-mode enclaves are not allowed")
+		}
+	default:
+		return fmt.Errorf("invalid debug stance %q", p.Debug)
+	}
+	if p.ModuleID != "" {
+		re, err := regexp.Compile(p.ModuleID)
+		if err != nil {
+			return fmt.Errorf("invalid module ID pattern: %v", err)
+		}
+		if !re.MatchString(doc.ModuleID) {
+			return fmt.Errorf("module ID %s does not match the policy", doc.ModuleID)
+		}
+	}
+	if err := checkPCRs(p.PCRs, doc); err != nil {
+		return err
+	}
+	if len(p.Builds) == 0 {
+		return nil
+	}
+	for _, build := range p.Builds {
+		if checkPCRs(build.PCRs, doc) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("PCRs match none of the %d allowed builds", len(p.Builds))
+}
+
+// checkPCRs confirms that a document reports the given PCR values.
+func checkPCRs(pcrs map[uint]Measurement, doc *nitrite.Document) error {
+	for _, index := range sortedIndices(pcrs) {
+		actual, ok := doc.PCRs[index]
+		if !ok {
+			return fmt.Errorf("PCR%d missing from attestation document", index)
+		}
+		if !bytes.Equal(actual, pcrs[index]) {
+			return fmt.Errorf("PCR%d mismatch", index)
+		}
+	}
+	return nil
+}
+
+// IsDebug reports whether a document comes from an enclave running in debug mode, which
+// the NSM signals by reporting PCR0 as all zeroes.
+func IsDebug(doc *nitrite.Document) bool {
+	pcr0, ok := doc.PCRs[0]
+	return ok && IsZeroMeasurement(pcr0)
+}
+
+// IsZeroMeasurement reports whether a PCR value is all zeroes, as the NSM reports PCRs
+// the enclave never extended and every PCR of a debug-mode enclave.
+func IsZeroMeasurement(m []byte) bool {
+	for _, b := range m {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Indices returns the PCR indices named by the policy in ascending order, including
+// those named by its builds.
+func (p *Policy) Indices() []uint {
+	all := map[uint]Measurement{}
+	for index, value := range p.PCRs {
+		all[index] = value
+	}
+	for _, build := range p.Builds {
+		for index, value := range build.PCRs {
+			all[index] = value
+		}
+	}
+	return sortedIndices(all)
+}
+
+func sortedIndices(pcrs map[uint]Measurement) []uint {
+	indices := make([]uint, 0, len(pcrs))
+	for index := range pcrs {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+// Clone returns a deep copy of the policy, for holders that must not share its maps and
+// slices with the caller.
+func (p *Policy) Clone() *Policy {
+	c := *p
+	c.Comment = append([]string(nil), p.Comment...)
+	c.PCRs = clonePCRs(p.PCRs)
+	c.Builds = nil
+	for _, build := range p.Builds {
+		c.Builds = append(c.Builds, Build{Name: build.Name, PCRs: clonePCRs(build.PCRs)})
+	}
+	return &c
+}
+
+func clonePCRs(pcrs map[uint]Measurement) map[uint]Measurement {
+	if pcrs == nil {
+		return nil
+	}
+	c := make(map[uint]Measurement, len(pcrs))
+	for index, value := range pcrs {
+		c[index] = append(Measurement(nil), value...)
+	}
+	return c
+}
+
+// Equal reports whether two policies make exactly the same requirements. Comments and
+// build names are ignored; builds must be listed in the same order.
+func (p *Policy) Equal(other *Policy) bool {
+	if !sameFingerprint(p.RootFingerprint, other.RootFingerprint) || p.ModuleID != other.ModuleID ||
+		p.Debug != other.Debug || len(p.Builds) != len(other.Builds) {
+		return false
+	}
+	for i, build := range p.Builds {
+		if !equalPCRs(build.PCRs, other.Builds[i].PCRs) {
+			return false
+		}
+	}
+	return equalPCRs(p.PCRs, other.PCRs)
+}
+
+func equalPCRs(a, b map[uint]Measurement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index, value := range a {
+		if theirs, ok := b[index]; !ok || !bytes.Equal(value, theirs) {
+			return false
+		}
+	}
+	return true
+}
+
+// ID identifies the policy: the hex SHA-256 of its JSON encoding without the comment and
+// with the root fingerprint in lower case, which is canonical because encoding/json
+// sorts map keys. Tokens and logs cite it to say which policy a document was checked
+// against.
+func (p *Policy) ID() string {
+	stripped := *p
+	stripped.Comment = nil
+	stripped.RootFingerprint = strings.ToLower(p.RootFingerprint)
+	enc, err := json.Marshal(&stripped)
+	if err != nil {
+		// A Policy holds only strings and maps of byte slices, which always encode.
+		panic(err)
+	}
+	digest := sha256.Sum256(enc)
+	return hex.EncodeToString(digest[:])
+}
+
+// sameFingerprint reports whether two hex root fingerprints name the same certificate.
+func sameFingerprint(a, b string) bool {
+	return strings.ToLower(a) == strings.ToLower(b)
+}
+
+// RootFingerprint returns the hex SHA-256 of a certificate, as Policy.RootFingerprint
+// expects it.
+func RootFingerprint(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(digest[:])
+}
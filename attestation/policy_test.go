@@ -0,0 +1,153 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hf/nitrite"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation/attestationtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+	doc := &nitrite.Document{PCRs: map[uint][]byte{0: pcr0, 1: make([]byte, 48)}}
+
+	t.Run("satisfied", func(t *testing.T) {
+		policy := &Policy{PCRs: map[uint]Measurement{0: pcr0}}
+		require.NoError(t, policy.Check(doc))
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		policy := &Policy{PCRs: map[uint]Measurement{0: pcr0, 1: pcr0}}
+		require.EqualError(t, policy.Check(doc), "PCR1 mismatch")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		policy := &Policy{PCRs: map[uint]Measurement{8: pcr0}}
+		require.EqualError(t, policy.Check(doc), "PCR8 missing from attestation document")
+	})
+
+	t.Run("JSON uses hex", func(t *testing.T) {
+		policy := &Policy{PCRs: map[uint]Measurement{0: {0xde, 0xad}}}
+		enc, err := json.Marshal(policy)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"pcrs":{"0":"dead"}}`, string(enc))
+		var decoded Policy
+		require.NoError(t, json.Unmarshal(enc, &decoded))
+		require.Equal(t, policy, &decoded)
+	})
+
+	t.Run("builds", func(t *testing.T) {
+		other := bytes.Repeat([]byte{0xbb}, 48)
+		policy := &Policy{Builds: []Build{{PCRs: map[uint]Measurement{0: other}}, {PCRs: map[uint]Measurement{0: pcr0}}}}
+		require.NoError(t, policy.Check(doc))
+		require.Equal(t, []uint{0}, policy.Indices())
+		policy.Builds = policy.Builds[:1]
+		require.EqualError(t, policy.Check(doc), "PCRs match none of the 1 allowed builds")
+		policy.PCRs = map[uint]Measurement{1: pcr0}
+		require.EqualError(t, policy.Check(doc), "PCR1 mismatch", "common PCRs are checked first")
+	})
+
+	t.Run("module ID", func(t *testing.T) {
+		doc := &nitrite.Document{ModuleID: "i-0123456789abcdef0-enc0123456789abcdef"}
+		require.NoError(t, (&Policy{ModuleID: "^i-0123456789abcdef0-enc"}).Check(doc))
+		require.EqualError(t, (&Policy{ModuleID: "^i-fff"}).Check(doc),
+			"module ID i-0123456789abcdef0-enc0123456789abcdef does not match the policy")
+		require.Error(t, (&Policy{ModuleID: "("}).Check(doc))
+	})
+
+	t.Run("debug", func(t *testing.T) {
+		debug := &nitrite.Document{PCRs: map[uint][]byte{0: make([]byte, 48)}}
+		require.True(t, IsDebug(debug))
+		require.False(t, IsDebug(doc))
+		require.False(t, IsDebug(&nitrite.Document{PCRs: map[uint][]byte{}}))
+		require.True(t, IsZeroMeasurement(make([]byte, 48)))
+		require.False(t, IsZeroMeasurement(pcr0))
+		require.EqualError(t, (&Policy{Debug: DebugDeny}).Check(debug), "debug-mode enclaves are not allowed")
+		require.NoError(t, (&Policy{Debug: DebugDeny}).Check(doc))
+		require.NoError(t, (&Policy{Debug: DebugAllow}).Check(debug))
+		require.NoError(t, (&Policy{}).Check(debug), "older policies take no stance")
+		require.EqualError(t, (&Policy{Debug: "maybe"}).Check(doc), `invalid debug stance "maybe"`)
+	})
+
+	t.Run("ID", func(t *testing.T) {
+		a := &Policy{PCRs: map[uint]Measurement{0: pcr0, 8: {0x01}}}
+		b := &Policy{PCRs: map[uint]Measurement{8: {0x01}, 0: pcr0}}
+		require.Len(t, a.ID(), 64)
+		require.Equal(t, a.ID(), b.ID())
+		require.NotEqual(t, a.ID(), (&Policy{PCRs: map[uint]Measurement{0: pcr0}}).ID())
+		b.Comment = []string{"explained"}
+		require.Equal(t, a.ID(), b.ID(), "comments do not change the ID")
+		require.True(t, a.Equal(b))
+		b.Debug = DebugDeny
+		require.NotEqual(t, a.ID(), b.ID())
+		require.False(t, a.Equal(b))
+
+		digest := sha256.Sum256([]byte(`{"pcrs":{"0":"` + hex.EncodeToString(pcr0) + `"}}`))
+		require.Equal(t, hex.EncodeToString(digest[:]), (&Policy{PCRs: map[uint]Measurement{0: pcr0}}).ID(),
+			"IDs of PCR-only policies are stable")
+
+		fingerprint := strings.Repeat("ab", sha256.Size)
+		lower := &Policy{PCRs: a.PCRs, RootFingerprint: fingerprint}
+		upper := &Policy{PCRs: a.PCRs, RootFingerprint: strings.ToUpper(fingerprint)}
+		require.True(t, lower.Equal(upper))
+		require.Equal(t, lower.ID(), upper.ID())
+
+		none := &Policy{Debug: DebugDeny}
+		empty := &Policy{PCRs: map[uint]Measurement{}, Debug: DebugDeny}
+		require.True(t, none.Equal(empty))
+		require.Equal(t, none.ID(), empty.ID(), "nil and empty PCRs are the same requirement")
+	})
+}
+
+func TestVerifyDocument(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	nonce, err := CreateNonce(time.Minute)
+	require.NoError(t, err)
+	doc, err := RetrieveAttestationFrom(sim, nonce.Value, nil, nil)
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		res, err := VerifyDocument(doc, VerifyOptions{
+			Roots:  sim.Roots(),
+			Nonce:  nonce,
+			Policy: &Policy{PCRs: map[uint]Measurement{0: pcr0}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+	})
+
+	t.Run("policy mismatch", func(t *testing.T) {
+		_, err := VerifyDocument(doc, VerifyOptions{
+			Roots:  sim.Roots(),
+			Policy: &Policy{PCRs: map[uint]Measurement{0: make([]byte, 48)}},
+		})
+		require.EqualError(t, err, "PCR0 mismatch")
+	})
+
+	t.Run("mismatched nonce", func(t *testing.T) {
+		_, err := VerifyDocument(doc, VerifyOptions{Roots: sim.Roots(), Nonce: &Nonce{Value: []byte{1}}})
+		require.EqualError(t, err, "mismatched nonce")
+	})
+
+	t.Run("root fingerprint", func(t *testing.T) {
+		policy := &Policy{RootFingerprint: RootFingerprint(sim.CA().Certificate())}
+		_, err := VerifyDocument(doc, VerifyOptions{Roots: sim.Roots(), Policy: policy})
+		require.NoError(t, err)
+
+		other, err := attestationtest.NewCA()
+		require.NoError(t, err)
+		roots := sim.Roots()
+		roots.AddCert(other.Certificate())
+		policy.RootFingerprint = RootFingerprint(other.Certificate())
+		_, err = VerifyDocument(doc, VerifyOptions{Roots: roots, Policy: policy})
+		require.EqualError(t, err, "certificate chain does not end at the policy's root")
+	})
+}
@@ -1,32 +1,43 @@
 package attestation
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"github.com/jessicatrinh/nsm"
 	"github.com/jessicatrinh/nsm/request"
+	"github.com/jessicatrinh/nsm/response"
+	"time"
 )
 
+// Session is an open connection to a Nitro Secure Module. *nsm.Session satisfies it, and
+// package attestationtest provides a simulated one for tests.
+type Session interface {
+	// Send submits a request to the NSM and returns its response.
+	Send(req request.Request) (response.Response, error)
+	// Read fills into with entropy from the NSM.
+	Read(into []byte) (int, error)
+	// Close releases the session.
+	Close() error
+}
+
 // GenerateKeypair generates a keypair and return its public key as a byte array.
-// Pre: None.
-// Post: A public key as a byte array and the private key are returned, or an error is
-// returned.
-func GenerateKeypair() ([]byte, *ecdsa.PrivateKey, error) {
+// Pre: Parameter alg selects the key algorithm.
+// Post: The PKIX DER encoded public key and the private key are returned, or an error is
+// returned. ECDSA and Ed25519 private keys implement crypto.Signer, RSA private keys
+// implement crypto.Signer and crypto.Decrypter, and X25519 private keys are an
+// *X25519PrivateKey.
+func GenerateKeypair(alg KeyAlgorithm) ([]byte, crypto.PrivateKey, error) {
 	// Read entropy from Nitro Secure Module
 	sess, err := nsm.OpenDefaultSession()
 	defer sess.Close()
 	if nil != err {
+		observe(OpGenerateKeypair, time.Now(), ClassNSMUnavailable)
+		logEntry(LevelError, "cannot open nsm session", CheckField(ClassNSMUnavailable), ErrorField(err))
 		return nil, nil, err
 	}
-	// Generate a keypair with ECC
-	curve := elliptic.P256()
-	xprv, err := ecdsa.GenerateKey(curve, sess)
-	if err != nil {
-		return nil, nil, err
-	}
-	return elliptic.Marshal(curve, xprv.PublicKey.X, xprv.PublicKey.Y), xprv, nil
+	return GenerateKeypairFrom(sess, alg)
 }
 
 // RetrieveAttestation obtains an attestation document from Nitro Hypervisor
@@ -37,30 +48,80 @@ func RetrieveAttestation(nonce, userData, publicKey []byte) ([]byte, error) {
 	sess, err := nsm.OpenDefaultSession()
 	defer sess.Close()
 	if nil != err {
+		observe(OpRetrieveAttestation, time.Now(), ClassNSMUnavailable)
+		logEntry(LevelError, "cannot open nsm session", CheckField(ClassNSMUnavailable), ErrorField(err))
 		return nil, err
 	}
+	return RetrieveAttestationFrom(sess, nonce, userData, publicKey)
+}
+
+// RetrieveAttestationFrom obtains an attestation document over an already open session.
+// Pre: Parameter sess is an open Session. Parameters nonce, userData, and publicKey are
+// supplied to the request for the attestation document.
+// Post: An attestation document is returned as a byte array, or an error is returned.
+func RetrieveAttestationFrom(sess Session, nonce, userData, publicKey []byte) ([]byte, error) {
+	start := time.Now()
+	doc, class, err := retrieveAttestation(sess, nonce, userData, publicKey)
+	observe(OpRetrieveAttestation, start, class)
+	fields := []Field{NonceField(nonce), {Key: FieldUserData, Value: userData}, {Key: FieldPublicKey, Value: publicKey}}
+	if err != nil {
+		logEntry(LevelError, "attestation document retrieval failed", append(fields, CheckField(class), ErrorField(err))...)
+		return nil, err
+	}
+	logEntry(LevelDebug, "attestation document retrieved", fields...)
+	return doc, nil
+}
+
+// relayedUserDataLabel separates the hashes of RelayedUserData from any other user data.
+const relayedUserDataLabel = "nitro-attest parent user data\x00"
+
+// RelayedUserData returns the user data an enclave attests on behalf of a caller outside
+// it, such as the parent instance: the SHA-256 of a fixed label followed by the caller's
+// data. Bindings the enclave makes itself, such as an RA-TLS key or an authn channel
+// binding, therefore never match a document obtained from outside. Verifiers expecting
+// user data u from such a document compare it with RelayedUserData(u).
+// Pre: None.
+// Post: The digest is returned, or nil for empty user data.
+func RelayedUserData(userData []byte) []byte {
+	if len(userData) == 0 {
+		return nil
+	}
+	h := sha256.New()
+	h.Write([]byte(relayedUserDataLabel))
+	h.Write(userData)
+	return h.Sum(nil)
+}
+
+// retrieveAttestation implements RetrieveAttestationFrom, classifying its failures.
+func retrieveAttestation(sess Session, nonce, userData, publicKey []byte) ([]byte, ErrorClass, error) {
 	res, err := sess.Send(&request.Attestation{
 		Nonce:     nonce,
 		UserData:  userData,
 		PublicKey: publicKey,
 	})
 	if nil != err {
-		return nil, err
+		return nil, ClassNSMUnavailable, err
 	}
 	if "" != res.Error {
-		return nil, errors.New(string(res.Error))
+		return nil, ClassNSMError, errors.New(string(res.Error))
 	}
 	if nil == res.Attestation || nil == res.Attestation.Document {
-		return nil, errors.New("NSM device did not return an attestation")
+		return nil, ClassNSMError, errors.New("NSM device did not return an attestation")
 	}
-	return res.Attestation.Document, nil
+	return res.Attestation.Document, ClassNone, nil
 }
 
-// LogIfError logs an error to the console.
+// LogIfError logs an error through the logger installed with SetLogger or, while none is
+// installed, prints it to the console as it always has.
 // Pre: Parameter e is an error.
 // Post: None.
+//
+// Deprecated: Log through your own logger; this package logs its own errors.
 func LogIfError(e error) {
-	if e != nil {
+	if e == nil {
+		return
+	}
+	if !logEntry(LevelError, "unhandled error", ErrorField(e)) {
 		fmt.Printf("error: %v\n", e)
 	}
 }
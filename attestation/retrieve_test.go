@@ -2,13 +2,14 @@ package attestation
 
 import (
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
 func TestGenerateKeyPair(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		xpubBytes, xprv, err := GenerateKeypair()
+		xpubBytes, xprv, err := GenerateKeypair(P256)
 		require.NoError(t, err)
 		require.NotEmpty(t, xpubBytes)
 		require.NotEmpty(t, xprv)
@@ -29,7 +30,25 @@ func TestLogIfError(t *testing.T) {
 	})
 }
 
+// printLogger prints log entries on standard output.
+type printLogger struct{}
+
+func (printLogger) Log(level Level, msg string, fields []Field) {
+	fmt.Printf("%s: %s", level, msg)
+	for _, f := range fields {
+		fmt.Printf(" %s=%v", f.Key, f.Value)
+	}
+	fmt.Println()
+}
+
 func ExampleLogIfError() {
 	LogIfError(errors.New("sample error"))
 	// Output: error: sample error
 }
+
+func ExampleLogIfError_logger() {
+	SetLogger(printLogger{})
+	defer SetLogger(nil)
+	LogIfError(errors.New("sample error"))
+	// Output: error: unhandled error error=sample error
+}
@@ -12,6 +12,44 @@ import (
 	"time"
 )
 
+// VerifyOptions controls the checks performed by VerifyDocument.
+type VerifyOptions struct {
+	// Roots are the trusted root certificates. If nil, the AWS Nitro Enclaves root is used.
+	Roots *x509.CertPool
+	// CurrentTime is the time at which the certificate chain is verified.
+	CurrentTime time.Time
+	// Nonce, if set, must match the document's nonce and must not have expired.
+	Nonce *Nonce
+	// Policy, if set, must be satisfied by the document and its certificate chain.
+	Policy *Policy
+	// Revocation checks each certificate of the chain. If nil, OnlineRevocation is used.
+	Revocation RevocationCheck
+}
+
+// RevocationCheck reports whether a certificate had been revoked at a time. It returns
+// ok false if the revocation status could not be established.
+type RevocationCheck func(cert *x509.Certificate, at time.Time) (revoked, ok bool)
+
+// OnlineRevocation fetches the CRLs and OCSP responses a certificate names. These give
+// the status now, whatever the time asked about, and a certificate that has expired by
+// now counts as revoked; documents verified at a past CurrentTime need another check,
+// such as SkipRevocation.
+// Pre: None.
+// Post: Whether the certificate is revoked and whether that could be established are
+// returned.
+func OnlineRevocation(cert *x509.Certificate, at time.Time) (revoked, ok bool) {
+	return revoke.VerifyCertificate(cert)
+}
+
+// SkipRevocation accepts every certificate, for offline verification. The enclave
+// certificate lives for hours and is not reissued once it expires, so a document verified
+// long after it was made has no revocation status left to check.
+// Pre: None.
+// Post: False and true are returned.
+func SkipRevocation(cert *x509.Certificate, at time.Time) (revoked, ok bool) {
+	return false, true
+}
+
 // VerifyAttestation validates the signature and certificate.
 // Pre: Parameter doc is the attestation document as a base64 string. Parameter timeOpt is
 // the time for which the attestation document is verified.
@@ -20,39 +58,123 @@ func VerifyAttestation(doc string, timeOpt time.Time, n *Nonce) (string, error)
 	docBytes, err := base64.StdEncoding.DecodeString(doc)
 	if err != nil {
 		// provided attestation document is not encoded as a valid standard Base64 string
+		observe(OpVerify, time.Now(), ClassMalformed)
+		return "", err
+	}
+	res, err := VerifyDocument(docBytes, VerifyOptions{
+		CurrentTime: timeOpt,
+		Nonce:       n,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc, err := json.Marshal(res.Document)
+	if err != nil {
 		return "", err
 	}
+	return string(enc), nil
+}
+
+// VerifyDocument validates the signature, certificate chain, nonce and PCRs of a raw
+// attestation document.
+// Pre: Parameter doc is the COSE encoded attestation document. Parameter opts selects
+// the checks to perform.
+// Post: The verification result or an error is returned.
+func VerifyDocument(doc []byte, opts VerifyOptions) (*nitrite.Result, error) {
+	start := time.Now()
+	res, class, err := verifyDocument(doc, opts)
+	observe(OpVerify, start, class)
+	var fields []Field
+	if res != nil && res.Document != nil {
+		fields = append(fields, ModuleIDField(res.Document.ModuleID))
+	}
+	if opts.Nonce != nil {
+		fields = append(fields, NonceField(opts.Nonce.Value))
+	}
+	if err != nil {
+		logEntry(LevelWarn, "attestation document rejected", append(fields, CheckField(class), ErrorField(err))...)
+		return nil, err
+	}
+	if opts.Policy != nil {
+		fields = append(fields, Field{Key: "policy_id", Value: opts.Policy.ID()})
+	}
+	logEntry(LevelDebug, "attestation document verified", fields...)
+	return res, nil
+}
+
+// verifyDocument implements VerifyDocument, classifying its failures. The result is
+// returned along with the error whenever the document could be decoded.
+func verifyDocument(doc []byte, opts VerifyOptions) (*nitrite.Result, ErrorClass, error) {
 	res, err := nitrite.Verify(
-		docBytes,
+		doc,
 		// If the options specify `Roots` as `nil`, the `DefaultCARoot` will be used.
 		nitrite.VerifyOptions{
-			CurrentTime: timeOpt,
+			Roots:       opts.Roots,
+			CurrentTime: opts.CurrentTime,
 		})
-	resJSON := ""
-	if res != nil {
-		enc, err := json.Marshal(res.Document)
-		if err != nil {
-			return "", err
-		}
-		resJSON = string(enc)
-	}
 	if err != nil {
-		return "", err
+		return res, classifyVerifyError(err), err
 	}
 	// Check nonce's validity
-	if bytes.Compare(res.Document.Nonce, n.Value) != 0 {
-		return "", errors.New("mismatched nonce")
+	if opts.Nonce != nil {
+		if bytes.Compare(res.Document.Nonce, opts.Nonce.Value) != 0 {
+			return res, ClassNonce, errors.New("mismatched nonce")
+		}
+		if isExpiredNonce(opts.Nonce) {
+			return res, ClassNonce, errors.New("expired nonce")
+		}
 	}
-	if isExpiredNonce(n) {
-		return "", errors.New("expired nonce")
+	if opts.Policy != nil {
+		if err := opts.Policy.Check(res.Document); err != nil {
+			return res, ClassPolicy, err
+		}
+		if err := checkRoot(opts.Policy, res, opts); err != nil {
+			return res, ClassPolicy, err
+		}
 	}
 	// Check whether the certificate has been revoked
-	err = checkRevokedCert(res.Certificates)
+	class, err := checkRevokedCert(res.Certificates, opts)
 	if err != nil {
 		// certificate revocation check error
-		return "", err
+		return res, class, err
+	}
+	return res, ClassNone, nil
+}
+
+// ChainRoots returns the trusted roots a verified document's certificate chains to.
+// Pre: Parameter res was returned by VerifyDocument called with opts.
+// Post: The roots, usually one, or an error is returned.
+func ChainRoots(res *nitrite.Result, opts VerifyOptions) ([]*x509.Certificate, error) {
+	if len(res.Certificates) == 0 {
+		return nil, errors.New("attestation document has no certificate")
+	}
+	roots := opts.Roots
+	if roots == nil {
+		roots = x509.NewCertPool()
+		roots.AppendCertsFromPEM([]byte(nitrite.DefaultCARoots))
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range res.Certificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	at := opts.CurrentTime
+	if at.IsZero() {
+		at = time.Now()
 	}
-	return resJSON, nil
+	chains, err := res.Certificates[0].Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		Roots:         roots,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not rebuild certificate chain")
+	}
+	var found []*x509.Certificate
+	for _, chain := range chains {
+		found = append(found, chain[len(chain)-1])
+	}
+	return found, nil
 }
 
 // StringifyAttestation formats the JSON more legibly.
@@ -69,18 +191,46 @@ func StringifyAttestation(str string) (string, error) {
 
 // HELPERS:
 
+// checkRoot confirms that the document chains to the root the policy names, if any.
+func checkRoot(policy *Policy, res *nitrite.Result, opts VerifyOptions) error {
+	if policy.RootFingerprint == "" {
+		return nil
+	}
+	roots, err := ChainRoots(res, opts)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if sameFingerprint(RootFingerprint(root), policy.RootFingerprint) {
+			return nil
+		}
+	}
+	return errors.New("certificate chain does not end at the policy's root")
+}
+
 // checkRevokedCert performs a revocation check on a certificate, which nitrite neglects
-// to do.
+// to do, with opts.Revocation at opts.CurrentTime.
 // Pre: Parameter certs is a certificate.
-// Post: Error/nil is returned.
-func checkRevokedCert(certs []*x509.Certificate) error {
+// Post: ClassNone and nil, or the class of the failure and an error, are returned.
+func checkRevokedCert(certs []*x509.Certificate, opts VerifyOptions) (class ErrorClass, err error) {
+	defer func(start time.Time) { observe(OpRevocationCheck, start, class) }(time.Now())
+	check, at := opts.Revocation, opts.CurrentTime
+	if check == nil {
+		check = OnlineRevocation
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
 	for index, cert := range certs {
 		// VerifyCertificate ensures that the certificate passed in hasn't expired and checks the CRL for the server
-		if revoked, ok := revoke.VerifyCertificate(cert); !ok {
-			return errors.New("warning: soft fail checking revocation")
+		certificate := []Field{{Key: "certificate", Value: index}, {Key: "subject", Value: cert.Subject.String()}}
+		if revoked, ok := check(cert, at); !ok {
+			logEntry(LevelWarn, "certificate revocation status unavailable", append(certificate, CheckField(ClassRevocationUnavailable))...)
+			return ClassRevocationUnavailable, errors.New("warning: soft fail checking revocation")
 		} else if revoked {
-			return fmt.Errorf("certificate %d was revoked", index)
+			logEntry(LevelError, "certificate revoked", append(certificate, CheckField(ClassRevoked))...)
+			return ClassRevoked, fmt.Errorf("certificate %d was revoked", index)
 		}
 	}
-	return nil
+	return ClassNone, nil
 }
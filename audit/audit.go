@@ -0,0 +1,314 @@
+// Package audit keeps an append-only log of verification decisions in a bbolt database,
+// so that every attestation document a verifier accepted or rejected can be accounted
+// for later. Records can only be appended; the only way to remove them is Prune, which
+// enforces the retention period.
+//
+// Records are keyed by the time they were made, so time range queries are a cursor seek,
+// and indexed by module ID.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"nitro/attest/attestation"
+	"time"
+)
+
+// Outcomes of a verification.
+const (
+	Accepted = "accepted"
+	Rejected = "rejected"
+)
+
+var (
+	bucketRecords = []byte("records")
+	bucketModules = []byte("modules")
+)
+
+// Record is one verification decision.
+type Record struct {
+	// Seq numbers records in the order they were appended.
+	Seq uint64 `json:"seq"`
+	// Time is when the decision was made.
+	Time time.Time `json:"time"`
+	// DocumentHash is the hex SHA-256 of the COSE encoded document.
+	DocumentHash string `json:"document_hash"`
+	// ModuleID, PCRs and Nonce are taken from the document. For rejected documents
+	// they are unverified, and absent if the document could not be decoded. All-zero
+	// PCRs are left out, and the nonce is recorded as its fingerprint.
+	ModuleID string                           `json:"module_id,omitempty"`
+	PCRs     map[uint]attestation.Measurement `json:"pcrs,omitempty"`
+	Nonce    string                           `json:"nonce,omitempty"`
+	// PolicyID is the ID of the policy the document was checked against, if any.
+	PolicyID string `json:"policy_id,omitempty"`
+	// Outcome is Accepted or Rejected.
+	Outcome string `json:"outcome"`
+	// Error says why the document was rejected.
+	Error string `json:"error,omitempty"`
+}
+
+// NewRecord describes the decision attestation.VerifyDocument made about a document.
+// Pre: Parameters res and err were returned by attestation.VerifyDocument called with
+// doc and opts.
+// Post: The record, without its sequence number and time, is returned.
+func NewRecord(doc []byte, opts attestation.VerifyOptions, res *nitrite.Result, err error) Record {
+	r := Record{
+		DocumentHash: hex.EncodeToString(attestation.DocumentHash(doc)),
+		Outcome:      Accepted,
+	}
+	if opts.Policy != nil {
+		r.PolicyID = opts.Policy.ID()
+	}
+	var parsed *nitrite.Document
+	if err != nil {
+		r.Outcome, r.Error = Rejected, err.Error()
+		parsed, _ = attestation.ParseDocument(doc)
+	} else {
+		parsed = res.Document
+	}
+	if parsed != nil {
+		r.ModuleID = parsed.ModuleID
+		for index, value := range parsed.PCRs {
+			if !attestation.IsZeroMeasurement(value) {
+				if r.PCRs == nil {
+					r.PCRs = map[uint]attestation.Measurement{}
+				}
+				r.PCRs[index] = value
+			}
+		}
+		if len(parsed.Nonce) > 0 {
+			r.Nonce = attestation.Fingerprint(parsed.Nonce)
+		}
+	}
+	return r
+}
+
+// Options configure a Store.
+type Options struct {
+	// Retention is how long records are kept; Prune removes older ones. Zero keeps
+	// records forever.
+	Retention time.Duration
+	// Timeout bounds waiting for another process to release the database. Zero waits
+	// forever.
+	Timeout time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Store is an audit log. It is safe for concurrent use.
+type Store struct {
+	db   *bolt.DB
+	opts Options
+}
+
+// Open opens the audit log in the named file, creating it if necessary.
+// Pre: Parameter opts has a non-negative Retention.
+// Post: The store or an error is returned.
+func Open(name string, opts Options) (*Store, error) {
+	if opts.Retention < 0 {
+		return nil, errors.New("retention must not be negative")
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	db, err := bolt.Open(name, 0600, &bolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open audit log")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketRecords, bucketModules} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "could not initialize audit log")
+	}
+	return &Store{db: db, opts: opts}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Append adds a record, stamping it with the next sequence number and, if it has none,
+// the current time.
+// Pre: None.
+// Post: The stored record or an error is returned.
+func (s *Store) Append(r Record) (Record, error) {
+	if r.Time.IsZero() {
+		r.Time = s.opts.Now()
+	}
+	r.Time = r.Time.UTC()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bucketRecords)
+		seq, err := records.NextSequence()
+		if err != nil {
+			return err
+		}
+		r.Seq = seq
+		value, err := json.Marshal(&r)
+		if err != nil {
+			return err
+		}
+		key := recordKey(r.Time, seq)
+		if err := records.Put(key, value); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketModules).Put(moduleKey(r.ModuleID, key), nil)
+	})
+	if err != nil {
+		return Record{}, errors.Wrap(err, "could not append to audit log")
+	}
+	return r, nil
+}
+
+// Verify verifies a document with attestation.VerifyDocument and records the decision.
+// A decision that cannot be recorded is not made: the document is then rejected.
+// Pre: Parameter doc is the COSE encoded attestation document.
+// Post: The verification result or an error is returned.
+func (s *Store) Verify(doc []byte, opts attestation.VerifyOptions) (*nitrite.Result, error) {
+	res, err := attestation.VerifyDocument(doc, opts)
+	if _, aerr := s.Append(NewRecord(doc, opts, res, err)); aerr != nil {
+		return nil, aerr
+	}
+	return res, err
+}
+
+// Query selects records. Zero fields do not restrict the selection.
+type Query struct {
+	// Since and Until bound the record time: Since inclusive, Until exclusive.
+	Since, Until time.Time
+	// ModuleID selects the records of one enclave.
+	ModuleID string
+	// Limit caps the number of records.
+	Limit int
+}
+
+// Scan calls fn for every record matching q, oldest first, stopping at the first error.
+// Pre: Parameter fn does not use the store.
+// Post: Nil or the first error is returned.
+func (s *Store) Scan(q Query, fn func(Record) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bucketRecords)
+		var from, to []byte
+		if !q.Since.IsZero() {
+			from = recordKey(q.Since, 0)
+		}
+		if !q.Until.IsZero() {
+			to = recordKey(q.Until, 0)
+		}
+		// Walk the records themselves, or the module index whose keys end in record keys.
+		c, prefix := records.Cursor(), []byte(nil)
+		if q.ModuleID != "" {
+			c, prefix = tx.Bucket(bucketModules).Cursor(), moduleKey(q.ModuleID, nil)
+		}
+		n := 0
+		k, _ := c.Seek(append(append([]byte(nil), prefix...), from...))
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			if to != nil && bytes.Compare(key, to) >= 0 {
+				break
+			}
+			var r Record
+			if err := json.Unmarshal(records.Get(key), &r); err != nil {
+				return errors.Wrap(err, "corrupt audit record")
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+			if n++; q.Limit > 0 && n == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Records returns the records matching q, oldest first.
+// Pre: None.
+// Post: The records or an error is returned.
+func (s *Store) Records(q Query) ([]Record, error) {
+	var found []Record
+	err := s.Scan(q, func(r Record) error {
+		found = append(found, r)
+		return nil
+	})
+	return found, err
+}
+
+// Export writes the records matching q to w as JSON Lines, oldest first.
+// Pre: None.
+// Post: The number of records written and nil, or an error, is returned.
+func (s *Store) Export(w io.Writer, q Query) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+	err := s.Scan(q, func(r Record) error {
+		n++
+		return enc.Encode(&r)
+	})
+	return n, err
+}
+
+// Prune removes the records older than the retention period.
+// Pre: None.
+// Post: The number of records removed and nil, or an error, is returned.
+func (s *Store) Prune() (int, error) {
+	if s.opts.Retention == 0 {
+		return 0, nil
+	}
+	cutoff := recordKey(s.opts.Now().Add(-s.opts.Retention), 0)
+	n := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		records, modules := tx.Bucket(bucketRecords), tx.Bucket(bucketModules)
+		c := records.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, v = c.First() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return errors.Wrap(err, "corrupt audit record")
+			}
+			if err := modules.Delete(moduleKey(r.ModuleID, k)); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not prune audit log")
+	}
+	return n, nil
+}
+
+// recordKey orders records by time, then by sequence number. Times before 1970 sort
+// first.
+func recordKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	if nanos := t.UnixNano(); nanos > 0 {
+		binary.BigEndian.PutUint64(key, uint64(nanos))
+	}
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// moduleKey is the index key of a record of a module. The module ID of a rejected
+// document is attacker controlled, so it is hashed to a fixed length: no module's prefix
+// can then run into another's record keys.
+func moduleKey(moduleID string, record []byte) []byte {
+	digest := sha256.Sum256([]byte(moduleID))
+	return append(digest[:], record...)
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	simA, err := ca.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	simB, err := ca.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	name := filepath.Join(t.TempDir(), "audit.db")
+	open := func(t *testing.T, retention time.Duration) *Store {
+		s, err := Open(name, Options{Retention: retention, Timeout: time.Second, Now: func() time.Time { return now }})
+		require.NoError(t, err)
+		return s
+	}
+	s := open(t, 0)
+
+	// One decision a day, alternating enclaves; every third is rejected by policy.
+	nonce, err := attestation.CreateNonce(time.Hour)
+	require.NoError(t, err)
+	policy := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}}
+	wrong := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}}
+	var docs [][]byte
+	for day := 0; day < 6; day++ {
+		sim := simA
+		if day%2 == 1 {
+			sim = simB
+		}
+		doc, err := attestation.RetrieveAttestationFrom(sim, nonce.Value, nil, nil)
+		require.NoError(t, err)
+		docs = append(docs, doc)
+		opts := attestation.VerifyOptions{Roots: ca.Roots(), Policy: policy}
+		if day%3 == 2 {
+			opts.Policy = wrong
+		}
+		_, err = s.Verify(doc, opts)
+		require.Equal(t, day%3 == 2, err != nil)
+		now = now.Add(24 * time.Hour)
+	}
+
+	t.Run("records", func(t *testing.T) {
+		all, err := s.Records(Query{})
+		require.NoError(t, err)
+		require.Len(t, all, 6)
+		first := all[0]
+		require.Equal(t, uint64(1), first.Seq)
+		require.Equal(t, start, first.Time)
+		require.Equal(t, hex.EncodeToString(attestation.DocumentHash(docs[0])), first.DocumentHash)
+		require.Equal(t, simA.ModuleID, first.ModuleID)
+		require.Equal(t, map[uint]attestation.Measurement{0: pcr0}, first.PCRs)
+		require.Equal(t, attestation.Fingerprint(nonce.Value), first.Nonce)
+		require.Equal(t, policy.ID(), first.PolicyID)
+		require.Equal(t, Accepted, first.Outcome)
+		require.Empty(t, first.Error)
+
+		rejected := all[2]
+		require.Equal(t, Rejected, rejected.Outcome)
+		require.Equal(t, "PCR0 mismatch", rejected.Error)
+		require.Equal(t, wrong.ID(), rejected.PolicyID)
+		require.Equal(t, simA.ModuleID, rejected.ModuleID, "rejected documents are still described")
+	})
+
+	t.Run("queries", func(t *testing.T) {
+		found, err := s.Records(Query{Since: start.Add(24 * time.Hour), Until: start.Add(4 * 24 * time.Hour)})
+		require.NoError(t, err)
+		require.Equal(t, []uint64{2, 3, 4}, seqs(found))
+
+		found, err = s.Records(Query{ModuleID: simB.ModuleID})
+		require.NoError(t, err)
+		require.Equal(t, []uint64{2, 4, 6}, seqs(found))
+
+		found, err = s.Records(Query{ModuleID: simA.ModuleID, Since: start.Add(time.Hour), Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, []uint64{3}, seqs(found))
+
+		found, err = s.Records(Query{ModuleID: "i-unknown-enc0"})
+		require.NoError(t, err)
+		require.Empty(t, found)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := s.Export(&buf, Query{ModuleID: simA.ModuleID})
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		lines := bufio.NewScanner(&buf)
+		var got []uint64
+		for lines.Scan() {
+			var r Record
+			require.NoError(t, json.Unmarshal(lines.Bytes(), &r))
+			got = append(got, r.Seq)
+		}
+		require.Equal(t, []uint64{1, 3, 5}, got)
+	})
+
+	t.Run("undecodable documents", func(t *testing.T) {
+		_, err := s.Verify([]byte("junk"), attestation.VerifyOptions{})
+		require.Error(t, err)
+		found, err := s.Records(Query{Since: now})
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, Rejected, found[0].Outcome)
+		require.Empty(t, found[0].ModuleID)
+	})
+
+	t.Run("hostile module ids", func(t *testing.T) {
+		at := now.Add(time.Hour)
+		_, err := s.Append(Record{Time: at, ModuleID: simA.ModuleID + "\x00zz", Outcome: Rejected})
+		require.NoError(t, err)
+		found, err := s.Records(Query{ModuleID: simA.ModuleID, Since: at})
+		require.NoError(t, err)
+		require.Empty(t, found)
+		found, err = s.Records(Query{ModuleID: simA.ModuleID + "\x00zz"})
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		require.NoError(t, s.Close())
+		_, err := Open(name, Options{Retention: -time.Hour})
+		require.Error(t, err)
+
+		s = open(t, 3*24*time.Hour)
+		defer s.Close()
+		all, err := s.Records(Query{})
+		require.NoError(t, err)
+		require.Len(t, all, 8, "records survive reopening")
+
+		n, err := s.Prune()
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		all, err = s.Records(Query{})
+		require.NoError(t, err)
+		require.Equal(t, []uint64{4, 5, 6, 7, 8}, seqs(all))
+		found, err := s.Records(Query{ModuleID: simA.ModuleID})
+		require.NoError(t, err)
+		require.Equal(t, []uint64{5}, seqs(found), "the index is pruned too")
+
+		r, err := s.Append(Record{ModuleID: simA.ModuleID, Outcome: Accepted})
+		require.NoError(t, err)
+		require.Equal(t, uint64(9), r.Seq, "sequence numbers are never reused")
+	})
+}
+
+func seqs(records []Record) []uint64 {
+	found := make([]uint64, len(records))
+	for i, r := range records {
+		found[i] = r.Seq
+	}
+	return found
+}
@@ -0,0 +1,273 @@
+// Package authn authenticates callers by enclave attestation. A caller presents either a
+// base64 attestation document in the x-attestation-document header or an attestation
+// token (see package token) as a bearer token; an Authenticator verifies it against a
+// policy and yields the caller's Identity.
+//
+// A document alone proves nothing about who sends it: anyone who saw it could replay it.
+// A document is therefore accepted only over TLS, with its user data set to the
+// connection's ChannelBinding, so that it is bound to the connection it arrives on.
+// Where the connection ends at a proxy, AuthenticatePeer binds the document to the
+// client certificate instead.
+//
+// Middleware and the gRPC interceptors wire an Authenticator into net/http and gRPC
+// servers and put the Identity into the request context; package extauthz does the same
+// for Envoy.
+package authn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+	"nitro/attest/token"
+	"strings"
+	"time"
+)
+
+// Header and metadata names, in the lower case used by HTTP/2 and gRPC.
+const (
+	// HeaderDocument carries a standard base64 COSE attestation document.
+	HeaderDocument = "x-attestation-document"
+	// HeaderAuthorization carries an attestation token as "Bearer <token>".
+	HeaderAuthorization = "authorization"
+)
+
+const (
+	// DefaultMaxAge is how old a document may be when no MaxAge is configured.
+	DefaultMaxAge = 5 * time.Minute
+	// clockSkew tolerates documents stamped slightly in the future.
+	clockSkew = time.Minute
+	// ExporterLabel is the TLS exporter label (RFC 5705, RFC 8446 section 7.5) of the
+	// channel binding.
+	ExporterLabel = "EXPORTER-nitro-attest-channel-binding"
+	// bindingSize is the length of the channel binding in bytes.
+	bindingSize = 32
+)
+
+// ErrNoCredentials is returned by Authenticate for callers without a document or token.
+var ErrNoCredentials = errors.New("request carries no attestation")
+
+// Identity describes a verified enclave.
+type Identity struct {
+	// ModuleID is the enclave's module ID.
+	ModuleID string
+	// PCRs are the enclave's measurements by index.
+	PCRs map[uint][]byte
+	// PublicKeySHA256 is the hex SHA-256 of the attested public key, if any.
+	PublicKeySHA256 string
+	// UserDataSHA256 is the hex SHA-256 of the attested user data, if any.
+	UserDataSHA256 string
+	// Source is "document" or "token".
+	Source string
+	// Document is the verified document, if the caller presented one.
+	Document *nitrite.Document
+	// Claims are the verified token claims, if the caller presented a token.
+	Claims *token.Claims
+}
+
+// IdentityOf describes the enclave that produced a verified document.
+// Pre: Parameter doc has passed verification.
+// Post: The Identity is returned.
+func IdentityOf(doc *nitrite.Document) *Identity {
+	id := &Identity{ModuleID: doc.ModuleID, PCRs: map[uint][]byte{}, Source: "document", Document: doc}
+	for index, value := range doc.PCRs {
+		id.PCRs[index] = value
+	}
+	if len(doc.PublicKey) > 0 {
+		digest := sha256.Sum256(doc.PublicKey)
+		id.PublicKeySHA256 = hex.EncodeToString(digest[:])
+	}
+	if len(doc.UserData) > 0 {
+		digest := sha256.Sum256(doc.UserData)
+		id.UserDataSHA256 = hex.EncodeToString(digest[:])
+	}
+	return id
+}
+
+// IdentityOfClaims describes the enclave named by verified token claims.
+// Pre: Parameter claims have passed token verification.
+// Post: The Identity is returned.
+func IdentityOfClaims(claims *token.Claims) *Identity {
+	id := &Identity{
+		ModuleID:        claims.ModuleID,
+		PCRs:            map[uint][]byte{},
+		PublicKeySHA256: rehex(claims.PublicKeyThumbprint),
+		UserDataSHA256:  rehex(claims.UserDataHash),
+		Source:          "token",
+		Claims:          claims,
+	}
+	for index, value := range claims.PCRs {
+		id.PCRs[index] = value
+	}
+	return id
+}
+
+// rehex converts a base64url digest from token claims to hex.
+func rehex(digest string) string {
+	raw, err := base64.RawURLEncoding.DecodeString(digest)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(raw)
+}
+
+// TokenVerifier adapts a token.Verifier for Authenticator.VerifyToken.
+// Pre: Parameter v is configured for the token issuer.
+// Post: The verification function is returned.
+func TokenVerifier(v *token.Verifier) func(string) (*Identity, error) {
+	return func(tok string) (*Identity, error) {
+		claims, err := v.Verify(tok)
+		if err != nil {
+			return nil, err
+		}
+		return IdentityOfClaims(claims), nil
+	}
+}
+
+// ChannelBinding returns the keying material exported from a TLS connection under
+// ExporterLabel. An enclave authenticating with a document over the connection puts it
+// in the document's user data; both ends of the connection compute the same value.
+// Pre: None.
+// Post: The binding is returned, or nil if state is nil or keying material cannot be
+// exported from the connection (TLS 1.2 without extended master secret).
+func ChannelBinding(state *tls.ConnectionState) []byte {
+	if state == nil || !state.HandshakeComplete {
+		return nil
+	}
+	binding, err := state.ExportKeyingMaterial(ExporterLabel, nil, bindingSize)
+	if err != nil {
+		return nil
+	}
+	return binding
+}
+
+// Authenticator verifies the attestation a caller presents. The middleware and
+// interceptors read it from every request without locking; to switch policy or roots,
+// install a new Authenticator rather than changing this one.
+type Authenticator struct {
+	// Options configure document verification, in particular the roots and PCR policy.
+	// Options.Nonce is ignored: documents in headers cannot answer a per-request
+	// challenge, so they are bound to the connection instead and their freshness is
+	// bounded by MaxAge.
+	Options attestation.VerifyOptions
+	// MaxAge rejects documents older than this. Zero means DefaultMaxAge.
+	MaxAge time.Duration
+	// VerifyToken verifies attestation tokens, normally through TokenVerifier; the token
+	// issuer must apply the same policy. If nil, tokens are refused.
+	VerifyToken func(token string) (*Identity, error)
+}
+
+// Authenticate verifies the attestation in the document and authorization header
+// values. A document takes precedence over a token, and is accepted only if its user
+// data equals binding.
+// Pre: Parameters document and authorization are header values, empty if absent.
+// Parameter binding is the ChannelBinding of the caller's connection, nil if there is
+// none, in which case only tokens are accepted.
+// Post: The caller's Identity is returned, ErrNoCredentials if neither value carries an
+// attestation, or another error if the attestation was rejected.
+func (a *Authenticator) Authenticate(document, authorization string, binding []byte) (*Identity, error) {
+	var bound func(*nitrite.Document) error
+	if len(binding) != 0 {
+		bound = func(doc *nitrite.Document) error {
+			if !bytes.Equal(doc.UserData, binding) {
+				return errors.New("attestation rejected: document is not bound to this connection")
+			}
+			return nil
+		}
+	}
+	return a.authenticate(document, authorization, bound, "attestation documents are accepted only over TLS; present a token")
+}
+
+// AuthenticatePeer is Authenticate for callers whose TLS connection ends before this
+// server, such as behind Envoy, where only the client certificate the caller presented
+// is known. A document is accepted only if its public key is the certificate's key, as
+// in an RA-TLS certificate (see package ratls): the handshake proved the caller holds
+// that key, and only the enclave could have had it attested.
+// Pre: Parameters document and authorization are header values, empty if absent.
+// Parameter cert is the caller's verified client certificate, nil if there is none, in
+// which case only tokens are accepted.
+// Post: As for Authenticate.
+func (a *Authenticator) AuthenticatePeer(document, authorization string, cert *x509.Certificate) (*Identity, error) {
+	var bound func(*nitrite.Document) error
+	if cert != nil {
+		bound = func(doc *nitrite.Document) error {
+			if !bytes.Equal(doc.PublicKey, cert.RawSubjectPublicKeyInfo) {
+				return errors.New("attestation rejected: document is not bound to the client certificate")
+			}
+			return nil
+		}
+	}
+	return a.authenticate(document, authorization, bound, "attestation documents are accepted only with a client certificate; present a token")
+}
+
+// authenticate verifies a document with bound, or refuses it with unbound if bound is
+// nil, or else verifies a token.
+func (a *Authenticator) authenticate(document, authorization string, bound func(*nitrite.Document) error, unbound string) (*Identity, error) {
+	if document = strings.TrimSpace(document); document != "" {
+		if bound == nil {
+			return nil, errors.New(unbound)
+		}
+		doc, err := base64.StdEncoding.DecodeString(document)
+		if err != nil {
+			return nil, errors.New("attestation document is not valid base64")
+		}
+		return a.verifyDocument(doc, bound)
+	}
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return nil, ErrNoCredentials
+	}
+	if a.VerifyToken == nil {
+		return nil, errors.New("attestation tokens are not accepted")
+	}
+	id, err := a.VerifyToken(strings.TrimSpace(authorization[len(prefix):]))
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation token rejected")
+	}
+	id.Source = "token"
+	return id, nil
+}
+
+func (a *Authenticator) verifyDocument(doc []byte, bound func(*nitrite.Document) error) (*Identity, error) {
+	opts := a.Options
+	opts.Nonce = nil
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation rejected")
+	}
+	if err := bound(res.Document); err != nil {
+		return nil, err
+	}
+	maxAge := a.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	produced := attestation.DocumentTime(res.Document)
+	if produced.Before(now.Add(-maxAge)) || produced.After(now.Add(clockSkew)) {
+		return nil, errors.New("attestation rejected: document is stale")
+	}
+	return IdentityOf(res.Document), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the Identity put into ctx by the middleware or interceptors.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
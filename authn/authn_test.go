@@ -0,0 +1,286 @@
+package authn
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/keyring"
+	"nitro/attest/ratls"
+	"nitro/attest/token"
+	"testing"
+	"time"
+)
+
+// handshake connects a TLS client and server over a pipe and returns the server's view
+// of the connection and the channel binding the client computed.
+func handshake(t *testing.T) (*tls.ConnectionState, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	server := tls.Server(s, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	client := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+	done := make(chan error, 1)
+	go func() { done <- server.Handshake() }()
+	require.NoError(t, client.Handshake())
+	require.NoError(t, <-done)
+	state, theirs := server.ConnectionState(), client.ConnectionState()
+	binding := ChannelBinding(&theirs)
+	require.Len(t, binding, bindingSize)
+	require.Equal(t, binding, ChannelBinding(&state), "both ends compute the same binding")
+	return &state, binding
+}
+
+func TestAuthenticate(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0a}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	a := &Authenticator{Options: attestation.VerifyOptions{
+		Roots:  sim.Roots(),
+		Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr}},
+	}}
+	pub, _, err := attestation.GenerateKeypairFrom(sim, attestation.P256)
+	require.NoError(t, err)
+	conn, binding := handshake(t)
+	doc, err := attestation.RetrieveAttestationFrom(sim, nil, binding, pub)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(doc)
+
+	t.Run("document", func(t *testing.T) {
+		id, err := a.Authenticate(" "+encoded+" ", "", binding)
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, id.ModuleID)
+		require.Equal(t, pcr, id.PCRs[0])
+		require.Equal(t, "document", id.Source)
+		require.Len(t, id.PublicKeySHA256, 64)
+		require.Len(t, id.UserDataSHA256, 64)
+		require.NotNil(t, id.Document)
+		require.Nil(t, id.Claims)
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		for _, authorization := range []string{"", "Basic Zm9vOmJhcg==", "Bearer "} {
+			_, err := a.Authenticate("", authorization, binding)
+			require.Equal(t, ErrNoCredentials, err, authorization)
+		}
+	})
+
+	t.Run("unbound documents", func(t *testing.T) {
+		_, err := a.Authenticate(encoded, "", nil)
+		require.EqualError(t, err, "attestation documents are accepted only over TLS; present a token")
+
+		_, replayed := handshake(t)
+		_, err = a.Authenticate(encoded, "", replayed)
+		require.EqualError(t, err, "attestation rejected: document is not bound to this connection")
+
+		bare, err := attestation.RetrieveAttestationFrom(sim, nil, []byte("user"), nil)
+		require.NoError(t, err)
+		_, err = a.Authenticate(base64.StdEncoding.EncodeToString(bare), "", binding)
+		require.EqualError(t, err, "attestation rejected: document is not bound to this connection")
+	})
+
+	t.Run("peer certificates", func(t *testing.T) {
+		cert, err := ratls.NewCertificate(sim, ratls.CertOptions{})
+		require.NoError(t, err)
+		doc, err := ratls.ExtractDocument(cert.Leaf)
+		require.NoError(t, err)
+		encodedPeer := base64.StdEncoding.EncodeToString(doc)
+
+		id, err := a.AuthenticatePeer(encodedPeer, "", cert.Leaf)
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, id.ModuleID)
+		require.Equal(t, "document", id.Source)
+
+		_, err = a.AuthenticatePeer(encodedPeer, "", nil)
+		require.EqualError(t, err, "attestation documents are accepted only with a client certificate; present a token")
+		other, err := ratls.NewCertificate(sim, ratls.CertOptions{})
+		require.NoError(t, err)
+		_, err = a.AuthenticatePeer(encodedPeer, "", other.Leaf)
+		require.EqualError(t, err, "attestation rejected: document is not bound to the client certificate")
+		_, err = a.AuthenticatePeer(encoded, "", cert.Leaf)
+		require.EqualError(t, err, "attestation rejected: document is not bound to the client certificate", "channel bound documents do not pass for the certificate")
+	})
+
+	t.Run("rejected documents", func(t *testing.T) {
+		_, err := a.Authenticate("not base64!", "", binding)
+		require.EqualError(t, err, "attestation document is not valid base64")
+
+		other, err := attestationtest.NewSimulator(map[uint][]byte{0: make([]byte, 48)})
+		require.NoError(t, err)
+		wrongPCR, err := attestation.RetrieveAttestationFrom(other, nil, binding, nil)
+		require.NoError(t, err)
+		trusting := *a
+		trusting.Options.Roots = other.Roots()
+		_, err = trusting.Authenticate(base64.StdEncoding.EncodeToString(wrongPCR), "", binding)
+		require.EqualError(t, err, "attestation rejected: PCR0 mismatch")
+
+		_, err = a.Authenticate(base64.StdEncoding.EncodeToString(wrongPCR), "", binding)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "attestation rejected")
+	})
+
+	t.Run("stale documents", func(t *testing.T) {
+		for _, now := range []time.Time{time.Now().Add(DefaultMaxAge + time.Minute), time.Now().Add(-2 * clockSkew)} {
+			stale := *a
+			stale.Options.CurrentTime = now
+			_, err := stale.Authenticate(encoded, "", binding)
+			require.EqualError(t, err, "attestation rejected: document is stale")
+		}
+		lenient := *a
+		lenient.MaxAge = time.Hour
+		lenient.Options.CurrentTime = time.Now().Add(DefaultMaxAge + time.Minute)
+		_, err := lenient.Authenticate(encoded, "", binding)
+		require.NoError(t, err)
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		_, err := a.Authenticate("", "Bearer x.y.z", nil)
+		require.EqualError(t, err, "attestation tokens are not accepted")
+
+		keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+		require.NoError(t, err)
+		issuer, err := token.NewIssuer(keys, token.IssuerOptions{Verify: a.Options})
+		require.NoError(t, err)
+		nonce := &attestation.Nonce{Value: []byte("nonce"), Expiration: time.Now().Add(time.Minute)}
+		challenged, err := attestation.RetrieveAttestationFrom(sim, nonce.Value, binding, pub)
+		require.NoError(t, err)
+		tok, _, err := issuer.Issue(challenged, nonce)
+		require.NoError(t, err)
+
+		withTokens := *a
+		withTokens.VerifyToken = TokenVerifier(&token.Verifier{Keys: token.ManagerKeys(keys), PolicyID: a.Options.Policy.ID()})
+		fromToken, err := withTokens.Authenticate("", "bearer "+tok, nil)
+		require.NoError(t, err)
+		fromDocument, err := withTokens.Authenticate(encoded, "Bearer garbage", binding)
+		require.NoError(t, err, "a document takes precedence over a token")
+		require.Equal(t, "token", fromToken.Source)
+		require.NotNil(t, fromToken.Claims)
+		require.Equal(t, fromDocument.ModuleID, fromToken.ModuleID)
+		require.Equal(t, map[uint][]byte{0: pcr}, fromToken.PCRs, "tokens carry only extended PCRs")
+		require.Equal(t, fromDocument.PublicKeySHA256, fromToken.PublicKeySHA256)
+		require.Equal(t, fromDocument.UserDataSHA256, fromToken.UserDataSHA256)
+
+		_, err = withTokens.Authenticate("", "Bearer x.y.z", nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "attestation token rejected: invalid token")
+	})
+
+	t.Run("http middleware", func(t *testing.T) {
+		h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := FromContext(r.Context())
+			require.True(t, ok)
+			w.Write([]byte(id.ModuleID))
+		}))
+		for _, tc := range []struct {
+			name     string
+			document string
+			tls      *tls.ConnectionState
+			code     int
+		}{
+			{"attested", encoded, conn, http.StatusOK},
+			{"no attestation", "", conn, http.StatusUnauthorized},
+			{"rejected", "bm90IGEgZG9jdW1lbnQ=", conn, http.StatusForbidden},
+			{"without tls", encoded, nil, http.StatusForbidden},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.TLS = tc.tls
+				if tc.document != "" {
+					r.Header.Set("X-Attestation-Document", tc.document)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				require.Equal(t, tc.code, w.Code)
+				switch tc.code {
+				case http.StatusOK:
+					require.Equal(t, sim.ModuleID, w.Body.String())
+				case http.StatusUnauthorized:
+					require.Equal(t, `Bearer realm="attestation"`, w.Header().Get("WWW-Authenticate"))
+				default:
+					require.Empty(t, w.Header().Get("WWW-Authenticate"))
+				}
+			})
+		}
+	})
+
+	t.Run("grpc interceptors", func(t *testing.T) {
+		incoming := func(document string, state *tls.ConnectionState) context.Context {
+			ctx := context.Background()
+			if state != nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *state}})
+			}
+			if document == "" {
+				return ctx
+			}
+			return metadata.NewIncomingContext(ctx, metadata.Pairs(HeaderDocument, document))
+		}
+		for _, tc := range []struct {
+			name     string
+			document string
+			tls      *tls.ConnectionState
+			code     codes.Code
+		}{
+			{"attested", encoded, conn, codes.OK},
+			{"no attestation", "", conn, codes.Unauthenticated},
+			{"rejected", "bm90IGEgZG9jdW1lbnQ=", conn, codes.PermissionDenied},
+			{"without tls", encoded, nil, codes.PermissionDenied},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				var seen *Identity
+				_, err := a.UnaryServerInterceptor()(incoming(tc.document, tc.tls), nil, &grpc.UnaryServerInfo{},
+					func(ctx context.Context, _ interface{}) (interface{}, error) {
+						seen, _ = FromContext(ctx)
+						return nil, nil
+					})
+				require.Equal(t, tc.code, status.Code(err))
+
+				var streamed *Identity
+				err = a.StreamServerInterceptor()(nil, &fakeStream{ctx: incoming(tc.document, tc.tls)}, &grpc.StreamServerInfo{},
+					func(_ interface{}, ss grpc.ServerStream) error {
+						streamed, _ = FromContext(ss.Context())
+						return nil
+					})
+				require.Equal(t, tc.code, status.Code(err))
+
+				if tc.code == codes.OK {
+					require.Equal(t, sim.ModuleID, seen.ModuleID)
+					require.Equal(t, sim.ModuleID, streamed.ModuleID)
+				} else {
+					require.Nil(t, seen)
+					require.Nil(t, streamed)
+				}
+			})
+		}
+	})
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,27 @@
+package authn
+
+import (
+	"net/http"
+)
+
+// Middleware admits only attested callers to next. Callers without an attestation get
+// 401 Unauthorized, callers whose attestation is rejected 403 Forbidden. Handlers find
+// the caller's Identity with FromContext. Documents are accepted only on TLS connections,
+// bound as described for ChannelBinding.
+// Pre: Parameter next is the protected handler.
+// Post: The wrapping handler is returned.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r.Header.Get(HeaderDocument), r.Header.Get(HeaderAuthorization), ChannelBinding(r.TLS))
+		if err != nil {
+			code := http.StatusForbidden
+			if err == ErrNoCredentials {
+				code = http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", `Bearer realm="attestation"`)
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
@@ -0,0 +1,386 @@
+// Package channel establishes a long-lived confidential channel to an enclave.
+//
+// The client opens with an ephemeral X25519 key and a random value. The enclave generates
+// its own ephemeral X25519 key and returns an attestation document whose public_key is
+// that key and whose nonce is the hash of the client's hello. Both sides then derive
+// AES-256-GCM traffic keys from the X25519 shared secret, salted with the hash of the
+// whole transcript, and exchange length-prefixed records with implicit sequence numbers.
+// Either side may rekey its sending direction at any time; it does so automatically
+// after Config.RekeyAfter records.
+package channel
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/protocol"
+	"sync"
+	"time"
+)
+
+// Version is the handshake version spoken by this package.
+const Version = 1
+
+// Record types.
+const (
+	recordHello     byte = 0x01 // plaintext: client hello
+	recordDocument  byte = 0x02 // plaintext: attestation document
+	recordError     byte = 0x03 // plaintext: UTF-8 handshake error
+	recordData      byte = 0x04 // encrypted application data
+	recordKeyUpdate byte = 0x05 // encrypted, empty; the sender switches keys after it
+)
+
+const (
+	headerSize = 5
+	helloSize  = 1 + 32 + 32 // version, client key, client random
+	secretSize = 32
+	maxPayload = 1 << 14 // largest plaintext carried by one record
+	maxSeqNum  = 1<<64 - 1
+	label      = "nitro-attest channel v1"
+)
+
+const (
+	// DefaultTimeout bounds the handshake when no timeout is configured.
+	DefaultTimeout = 10 * time.Second
+	// DefaultRekeyAfter is the number of records sent under one key by default.
+	DefaultRekeyAfter = 1 << 20
+)
+
+// Config tunes a channel.
+type Config struct {
+	// Timeout bounds the handshake. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// RekeyAfter is the number of records sent before the sending key is updated. Zero
+	// means DefaultRekeyAfter.
+	RekeyAfter uint64
+	// UserData, on the server, is placed in the attestation document.
+	UserData []byte
+}
+
+func (c Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c Config) rekeyAfter() uint64 {
+	if c.RekeyAfter == 0 {
+		return DefaultRekeyAfter
+	}
+	return c.RekeyAfter
+}
+
+// Conn is an established channel. Reads and writes are encrypted; deadlines and
+// addresses are those of the underlying connection.
+type Conn struct {
+	net.Conn
+	rekeyAfter uint64
+	doc        *nitrite.Document
+
+	in  direction
+	out direction
+
+	pending []byte // decrypted but unread data, guarded by in.mu
+}
+
+// direction holds the traffic keys of one direction.
+type direction struct {
+	mu     sync.Mutex
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+	seq    uint64
+}
+
+// setSecret installs a traffic secret and resets the sequence number.
+func (d *direction) setSecret(secret []byte) error {
+	block, err := aes.NewCipher(expand(secret, "key", 32))
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	d.secret, d.aead, d.iv, d.seq = secret, aead, expand(secret, "iv", 12), 0
+	return nil
+}
+
+// update moves to the next traffic secret.
+func (d *direction) update() error {
+	return d.setSecret(expand(d.secret, "key update", secretSize))
+}
+
+// nonce is the per-record nonce: the IV XORed with the sequence number.
+func (d *direction) nonce() []byte {
+	nonce := append([]byte(nil), d.iv...)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], d.seq)
+	for i := range seq {
+		nonce[len(nonce)-8+i] ^= seq[i]
+	}
+	return nonce
+}
+
+// Client performs the handshake as the relying party.
+// Pre: Parameter conn is connected to a Server. Parameter opts configures verification
+// of the enclave's document; its Nonce is set by the handshake.
+// Post: The established Conn or an error is returned. Errors reported by the server
+// start with "peer error".
+func Client(conn net.Conn, opts attestation.VerifyOptions, cfg Config) (*Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(cfg.timeout())); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	_, eprv, err := attestation.GenerateKeypairFrom(rand.Reader, attestation.X25519)
+	if err != nil {
+		return nil, err
+	}
+	priv := eprv.(*attestation.X25519PrivateKey)
+	hello := make([]byte, helloSize)
+	hello[0] = Version
+	copy(hello[1:33], priv.Public().(attestation.X25519PublicKey))
+	if _, err := io.ReadFull(rand.Reader, hello[33:]); err != nil {
+		return nil, err
+	}
+	if err := writeRecord(conn, recordHello, hello); err != nil {
+		return nil, errors.Wrap(err, "could not send hello")
+	}
+
+	typ, doc, err := readRecord(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read document")
+	}
+	switch typ {
+	case recordDocument:
+	case recordError:
+		return nil, fmt.Errorf("peer error: %s", doc)
+	default:
+		return nil, fmt.Errorf("unexpected record type %#02x", typ)
+	}
+	opts.Nonce = &attestation.Nonce{Value: helloHash(hello), Expiration: time.Now().Add(cfg.timeout())}
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	pub, alg, err := attestation.ParsePublicKey(res.Document.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if alg != attestation.X25519 {
+		return nil, fmt.Errorf("enclave key is %s, not X25519", alg)
+	}
+	shared, err := priv.SharedKey(pub.(attestation.X25519PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	c, err := newConn(conn, cfg, shared, hello, doc, false)
+	if err != nil {
+		return nil, err
+	}
+	c.doc = res.Document
+	return c, nil
+}
+
+// Server performs the handshake inside the enclave.
+// Pre: Parameter conn is connected to a Client. Parameter sess is the NSM session.
+// Post: The established Conn or an error is returned. Handshake failures are also
+// reported to the client.
+func Server(conn net.Conn, sess attestation.Session, cfg Config) (*Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(cfg.timeout())); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	typ, hello, err := readRecord(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read hello")
+	}
+	if typ != recordHello || len(hello) != helloSize {
+		return nil, fail(conn, errors.New("malformed hello"))
+	}
+	if hello[0] != Version {
+		return nil, fail(conn, fmt.Errorf("unsupported channel version %d", hello[0]))
+	}
+	xpub, xprv, err := attestation.GenerateKeypairFrom(sess, attestation.X25519)
+	if err != nil {
+		return nil, fail(conn, errors.Wrap(err, "could not generate key"))
+	}
+	doc, err := attestation.RetrieveAttestationFrom(sess, helloHash(hello), cfg.UserData, xpub)
+	if err != nil {
+		return nil, fail(conn, errors.Wrap(err, "attestation failed"))
+	}
+	shared, err := xprv.(*attestation.X25519PrivateKey).SharedKey(attestation.X25519PublicKey(hello[1:33]))
+	if err != nil {
+		return nil, fail(conn, errors.New("invalid client key"))
+	}
+	if err := writeRecord(conn, recordDocument, doc); err != nil {
+		return nil, errors.Wrap(err, "could not send document")
+	}
+	return newConn(conn, cfg, shared, hello, doc, true)
+}
+
+// fail reports a handshake error to the client and returns it.
+func fail(conn net.Conn, err error) error {
+	writeRecord(conn, recordError, []byte(err.Error()))
+	return err
+}
+
+// helloHash is the document nonce: it binds the document to this handshake.
+func helloHash(hello []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(hello)
+	return h.Sum(nil)
+}
+
+// newConn derives the traffic secrets from the shared secret and the transcript.
+func newConn(conn net.Conn, cfg Config, shared, hello, doc []byte, server bool) (*Conn, error) {
+	transcript := sha256.New()
+	transcript.Write(helloHash(hello))
+	transcript.Write(doc)
+	prk := hkdf.Extract(sha256.New, shared, transcript.Sum(nil))
+	c := &Conn{Conn: conn, rekeyAfter: cfg.rekeyAfter()}
+	in, out := &c.in, &c.out
+	if server {
+		in, out = out, in
+	}
+	if err := out.setSecret(expand(prk, "client to server", secretSize)); err != nil {
+		return nil, err
+	}
+	if err := in.setSecret(expand(prk, "server to client", secretSize)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// PeerDocument returns the enclave's verified attestation document on the client side
+// and nil on the server side.
+func (c *Conn) PeerDocument() *nitrite.Document {
+	return c.doc
+}
+
+// Read reads decrypted application data.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	for len(c.pending) == 0 {
+		typ, ciphertext, err := readRecord(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if typ != recordData && typ != recordKeyUpdate {
+			return 0, fmt.Errorf("unexpected record type %#02x", typ)
+		}
+		if c.in.seq == maxSeqNum {
+			return 0, errors.New("sequence number exhausted")
+		}
+		plaintext, err := c.in.aead.Open(nil, c.in.nonce(), ciphertext, header(typ, len(ciphertext)))
+		if err != nil {
+			return 0, errors.New("record authentication failed")
+		}
+		c.in.seq++
+		if typ == recordKeyUpdate {
+			if err := c.in.update(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.pending = plaintext
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts and sends application data, split into records as needed.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > maxPayload {
+			chunk = chunk[:maxPayload]
+		}
+		if c.out.seq >= c.rekeyAfter || c.out.seq == maxSeqNum-1 {
+			if err := c.rekey(); err != nil {
+				return written, err
+			}
+		}
+		if err := c.seal(recordData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// Rekey updates the sending key now. The peer follows when it reads the update.
+func (c *Conn) Rekey() error {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	return c.rekey()
+}
+
+func (c *Conn) rekey() error {
+	if err := c.seal(recordKeyUpdate, nil); err != nil {
+		return err
+	}
+	return c.out.update()
+}
+
+// seal encrypts and writes one record. The caller holds c.out.mu.
+func (c *Conn) seal(typ byte, plaintext []byte) error {
+	size := len(plaintext) + c.out.aead.Overhead()
+	record := make([]byte, headerSize, headerSize+size)
+	copy(record, header(typ, size))
+	record = c.out.aead.Seal(record, c.out.nonce(), plaintext, record[:headerSize])
+	c.out.seq++
+	_, err := c.Conn.Write(record)
+	return err
+}
+
+// header encodes a record header, which also authenticates encrypted records.
+func header(typ byte, size int) []byte {
+	return protocol.FrameHeader([]byte{typ}, size)
+}
+
+// writeRecord writes a plaintext record in the framing of package protocol.
+func writeRecord(w io.Writer, typ byte, payload []byte) error {
+	return protocol.WriteFrame(w, []byte{typ}, payload)
+}
+
+// readRecord reads a record in the framing of package protocol.
+func readRecord(r io.Reader) (byte, []byte, error) {
+	prefix, payload, err := protocol.ReadFrame(r, 1)
+	if err != nil {
+		return 0, nil, err
+	}
+	return prefix[0], payload, nil
+}
+
+// expand derives length bytes from a secret for the given purpose.
+func expand(secret []byte, purpose string, length int) []byte {
+	info := bytes.NewBufferString(label)
+	info.WriteByte(0)
+	info.WriteString(purpose)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, secret, info.Bytes()), out); err != nil {
+		panic(err) // length is far below the HKDF limit
+	}
+	return out
+}
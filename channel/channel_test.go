@@ -0,0 +1,147 @@
+package channel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+)
+
+// handshake connects a client and a server channel over a pipe.
+func handshake(t *testing.T, sim *attestationtest.Simulator, opts attestation.VerifyOptions, cfg Config) (*Conn, *Conn, error) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := Server(b, sim, cfg)
+		done <- result{conn, err}
+	}()
+	client, err := Client(a, opts, cfg)
+	if err != nil {
+		a.Close()
+		<-done
+		return nil, nil, err
+	}
+	r := <-done
+	return client, r.conn, r.err
+}
+
+// tamper flips a bit of the first encrypted record written through it.
+type tamper struct {
+	net.Conn
+	done bool
+}
+
+func (t *tamper) Write(b []byte) (int, error) {
+	if !t.done && len(b) > headerSize && b[0] == recordData {
+		b = append([]byte(nil), b...)
+		b[headerSize] ^= 1
+		t.done = true
+	}
+	return t.Conn.Write(b)
+}
+
+func TestChannel(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0x02}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	opts := attestation.VerifyOptions{Roots: sim.Roots()}
+
+	t.Run("round trip", func(t *testing.T) {
+		client, server, err := handshake(t, sim, opts, Config{UserData: []byte("svc")})
+		require.NoError(t, err)
+		require.Equal(t, []byte("svc"), client.PeerDocument().UserData)
+		require.Nil(t, server.PeerDocument())
+
+		go client.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(server, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+
+		go server.Write([]byte("pong"))
+		_, err = io.ReadFull(client, buf)
+		require.NoError(t, err)
+		require.Equal(t, "pong", string(buf))
+	})
+
+	t.Run("large writes and rekeying", func(t *testing.T) {
+		client, server, err := handshake(t, sim, opts, Config{RekeyAfter: 3})
+		require.NoError(t, err)
+		msg := make([]byte, 10*maxPayload+7)
+		_, err = rand.Read(msg)
+		require.NoError(t, err)
+
+		go func() {
+			client.Write(msg)
+			client.Rekey()
+			client.Write([]byte("end"))
+		}()
+		got := make([]byte, len(msg)+3)
+		_, err = io.ReadFull(server, got)
+		require.NoError(t, err)
+		require.Equal(t, append(msg, "end"...), got)
+		// Every key covered at most three records.
+		require.LessOrEqual(t, client.out.seq, uint64(3))
+		require.Equal(t, client.out.secret, server.in.secret)
+	})
+
+	t.Run("tampered record", func(t *testing.T) {
+		client, server, err := handshake(t, sim, opts, Config{})
+		require.NoError(t, err)
+		client.Conn = &tamper{Conn: client.Conn}
+		go client.Write([]byte("ping"))
+		_, err = server.Read(make([]byte, 4))
+		require.EqualError(t, err, "record authentication failed")
+	})
+
+	t.Run("directions use different keys", func(t *testing.T) {
+		client, server, err := handshake(t, sim, opts, Config{})
+		require.NoError(t, err)
+		require.Equal(t, client.out.secret, server.in.secret)
+		require.Equal(t, client.in.secret, server.out.secret)
+		require.NotEqual(t, client.out.secret, client.in.secret)
+	})
+
+	t.Run("policy mismatch", func(t *testing.T) {
+		_, _, err := handshake(t, sim, attestation.VerifyOptions{
+			Roots:  sim.Roots(),
+			Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}},
+		}, Config{})
+		require.EqualError(t, err, "PCR0 mismatch")
+	})
+
+	t.Run("untrusted enclave", func(t *testing.T) {
+		ca, err := attestationtest.NewCA()
+		require.NoError(t, err)
+		_, _, err = handshake(t, sim, attestation.VerifyOptions{Roots: ca.Roots()}, Config{})
+		require.Error(t, err)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		done := make(chan error, 1)
+		go func() {
+			_, err := Server(b, sim, Config{})
+			done <- err
+		}()
+		hello := make([]byte, helloSize)
+		hello[0] = Version + 1
+		require.NoError(t, writeRecord(a, recordHello, hello))
+		typ, msg, err := readRecord(a)
+		require.NoError(t, err)
+		require.Equal(t, recordError, typ)
+		require.Equal(t, "unsupported channel version 2", string(msg))
+		require.EqualError(t, <-done, "unsupported channel version 2")
+	})
+}
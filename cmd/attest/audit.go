@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"nitro/attest/audit"
+	"os"
+	"time"
+)
+
+// auditTimeout bounds waiting for a verifier that holds the audit log open.
+const auditTimeout = 5 * time.Second
+
+func (c *cli) auditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Export and prune the verification audit log",
+	}
+	cmd.AddCommand(c.auditExportCommand(), c.auditPruneCommand())
+	return cmd
+}
+
+func (c *cli) auditExportCommand() *cobra.Command {
+	var since, until, moduleID, out string
+	cmd := &cobra.Command{
+		Use:   "export DB",
+		Short: "Export verification decisions as JSON Lines",
+		Long:  "Write the verification decisions recorded in an audit log as JSON Lines, oldest first.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := audit.Query{ModuleID: moduleID}
+			var err error
+			if q.Since, err = parseTimeFlag("since", since); err != nil {
+				return err
+			}
+			if q.Until, err = parseTimeFlag("until", until); err != nil {
+				return err
+			}
+			store, err := openAuditLog(args[0], audit.Options{})
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			export := func(w io.Writer) error {
+				_, err := store.Export(w, q)
+				return withCode(exitFailure, err)
+			}
+			if out == "" {
+				return export(c.stdout)
+			}
+			return replaceFile(out, 0600, export)
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "RFC 3339 time of the first decision to export")
+	cmd.Flags().StringVar(&until, "until", "", "RFC 3339 time before which to stop")
+	cmd.Flags().StringVar(&moduleID, "module-id", "", "export only the decisions about this enclave")
+	cmd.Flags().StringVar(&out, "out", "", "file to write to (default: standard output)")
+	return cmd
+}
+
+func (c *cli) auditPruneCommand() *cobra.Command {
+	var retention time.Duration
+	cmd := &cobra.Command{
+		Use:   "prune DB",
+		Short: "Remove decisions older than the retention period",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if retention <= 0 {
+				return fmt.Errorf("invalid --retention %v", retention)
+			}
+			store, err := openAuditLog(args[0], audit.Options{Retention: retention})
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			n, err := store.Prune()
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			if c.output == "json" {
+				return c.printJSON(struct {
+					Pruned int `json:"pruned"`
+				}{n})
+			}
+			fmt.Fprintf(c.stdout, "pruned %d records\n", n)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&retention, "retention", 366*24*time.Hour, "how long to keep decisions")
+	return cmd
+}
+
+// record appends a verification decision to the named audit log.
+func record(name string, r audit.Record) error {
+	store, err := audit.Open(name, audit.Options{Timeout: auditTimeout})
+	if err != nil {
+		return withCode(exitFailure, err)
+	}
+	defer store.Close()
+	_, err = store.Append(r)
+	return withCode(exitFailure, err)
+}
+
+// openAuditLog opens an existing audit log.
+func openAuditLog(name string, opts audit.Options) (*audit.Store, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	opts.Timeout = auditTimeout
+	store, err := audit.Open(name, opts)
+	return store, withCode(exitInput, err)
+}
+
+// parseTimeFlag parses an optional RFC 3339 time flag.
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s: %v", name, err)
+	}
+	return t, nil
+}
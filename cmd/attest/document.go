@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"nitro/attest/attestation"
+	"nitro/attest/audit"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// nonceLifetime bounds how long the nonce passed to verify counts as fresh. The CLI does
+// not remember the nonces it created, so it only checks that the document answers it.
+const nonceLifetime = time.Minute
+
+func (c *cli) nonceCommand() *cobra.Command {
+	var lifetime time.Duration
+	cmd := &cobra.Command{
+		Use:   "nonce",
+		Short: "Create a random nonce for an attestation request",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := attestation.CreateNonce(lifetime)
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			value := base64.StdEncoding.EncodeToString(n.Value)
+			if c.output == "json" {
+				return c.printJSON(struct {
+					Nonce   string    `json:"nonce"`
+					Expires time.Time `json:"expires"`
+				}{value, n.Expiration})
+			}
+			fmt.Fprintln(c.stdout, value)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&lifetime, "lifetime", 5*time.Minute, "how long the nonce stays valid")
+	return cmd
+}
+
+func (c *cli) attestCommand() *cobra.Command {
+	var nonce, userData, userDataFile, publicKeyFile string
+	cmd := &cobra.Command{
+		Use:   "attest",
+		Short: "Request an attestation document from the NSM",
+		Long:  "Request an attestation document from the NSM. Must run inside an enclave.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := decodeBase64Flag("nonce", nonce)
+			if err != nil {
+				return err
+			}
+			data := []byte(userData)
+			if userDataFile != "" {
+				if data, err = c.readInput([]string{userDataFile}); err != nil {
+					return err
+				}
+			}
+			var pub []byte
+			if publicKeyFile != "" {
+				if pub, err = readPublicKey(publicKeyFile); err != nil {
+					return err
+				}
+			}
+			sess, err := c.session()
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+			doc, err := attestation.RetrieveAttestationFrom(sess, n, data, pub)
+			if err != nil {
+				return withCode(exitNSM, errors.Wrap(err, "cannot retrieve attestation"))
+			}
+			encoded := base64.StdEncoding.EncodeToString(doc)
+			if c.output == "json" {
+				return c.printJSON(struct {
+					Document string `json:"document"`
+				}{encoded})
+			}
+			fmt.Fprintln(c.stdout, encoded)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&nonce, "nonce", "", "base64 nonce to include, as printed by the nonce command")
+	cmd.Flags().StringVar(&userData, "user-data", "", "user data to include")
+	cmd.Flags().StringVar(&userDataFile, "user-data-file", "", `file holding the user data to include, "-" for standard input`)
+	cmd.Flags().StringVar(&publicKeyFile, "public-key", "", "PEM or DER public key file to include")
+	return cmd
+}
+
+func (c *cli) verifyCommand() *cobra.Command {
+	var rootsFile, policyFile, nonce, at, auditFile, tlogFile string
+	var maxAge time.Duration
+	cmd := &cobra.Command{
+		Use:   "verify [FILE]",
+		Short: "Verify an attestation document",
+		Long: "Verify the signature, certificate chain and, if requested, the nonce, age and PCR " +
+			"policy of an attestation document.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts := attestation.VerifyOptions{CurrentTime: time.Now()}
+			if at != "" {
+				t, err := time.Parse(time.RFC3339, at)
+				if err != nil {
+					return fmt.Errorf("invalid --time: %v", err)
+				}
+				opts.CurrentTime = t
+			}
+			n, err := decodeBase64Flag("nonce", nonce)
+			if err != nil {
+				return err
+			}
+			if n != nil {
+				opts.Nonce = &attestation.Nonce{Value: n, Expiration: time.Now().Add(nonceLifetime)}
+			}
+			if rootsFile != "" {
+				if opts.Roots, err = readRoots(rootsFile); err != nil {
+					return err
+				}
+			}
+			if policyFile != "" {
+				if opts.Policy, err = readPolicy(policyFile); err != nil {
+					return err
+				}
+			}
+			doc, err := c.readDocument(args)
+			if err != nil {
+				return err
+			}
+			res, err := attestation.VerifyDocument(doc, opts)
+			if err == nil && maxAge > 0 && attestation.DocumentTime(res.Document).Before(opts.CurrentTime.Add(-maxAge)) {
+				err = errors.New("document is stale")
+			}
+			if auditFile != "" {
+				if err := record(auditFile, audit.NewRecord(doc, opts, res, err)); err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				if _, perr := attestation.ParseDocument(doc); perr != nil {
+					return withCode(exitInput, perr)
+				}
+				return withCode(exitRejected, errors.Wrap(err, "attestation rejected"))
+			}
+			if tlogFile != "" {
+				if err := appendTlog(tlogFile, doc, opts); err != nil {
+					return err
+				}
+			}
+			return c.printDocument(res.Document, true)
+		},
+	}
+	cmd.Flags().StringVar(&rootsFile, "roots", "", "PEM file of trusted root certificates (default: the AWS Nitro Enclaves root)")
+	cmd.Flags().StringVar(&policyFile, "policy", "", "JSON policy file the document must satisfy")
+	cmd.Flags().StringVar(&nonce, "nonce", "", "base64 nonce the document must carry")
+	cmd.Flags().StringVar(&at, "time", "", "RFC 3339 time at which to verify the certificates (default: now)")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "reject documents older than this (default: no limit)")
+	cmd.Flags().StringVar(&auditFile, "audit", "", "audit log to record the decision in")
+	cmd.Flags().StringVar(&tlogFile, "tlog", "", "transparency log to append the document to if it is accepted")
+	return cmd
+}
+
+func (c *cli) inspectCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "inspect [FILE]",
+		Short: "Show an attestation document without verifying it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, err := c.readDocument(args)
+			if err != nil {
+				return err
+			}
+			parsed, err := attestation.ParseDocument(doc)
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			return c.printDocument(parsed, false)
+		},
+	}
+}
+
+// documentView is the printed form of a document. PCRs that were never extended are left
+// out.
+type documentView struct {
+	Verified           bool                             `json:"verified"`
+	ModuleID           string                           `json:"module_id"`
+	Timestamp          time.Time                        `json:"timestamp"`
+	Digest             string                           `json:"digest"`
+	PCRs               map[uint]attestation.Measurement `json:"pcrs"`
+	Certificate        string                           `json:"certificate,omitempty"`
+	CertificateExpires *time.Time                       `json:"certificate_expires,omitempty"`
+	PublicKey          []byte                           `json:"public_key,omitempty"`
+	PublicKeyAlgorithm attestation.KeyAlgorithm         `json:"public_key_algorithm,omitempty"`
+	UserData           []byte                           `json:"user_data,omitempty"`
+	Nonce              []byte                           `json:"nonce,omitempty"`
+}
+
+func (c *cli) printDocument(doc *nitrite.Document, verified bool) error {
+	view := documentView{
+		Verified:  verified,
+		ModuleID:  doc.ModuleID,
+		Timestamp: attestation.DocumentTime(doc).UTC(),
+		Digest:    doc.Digest,
+		PCRs:      map[uint]attestation.Measurement{},
+		PublicKey: doc.PublicKey,
+		UserData:  doc.UserData,
+		Nonce:     doc.Nonce,
+	}
+	for index, value := range doc.PCRs {
+		if !attestation.IsZeroMeasurement(value) {
+			view.PCRs[index] = value
+		}
+	}
+	if cert, err := x509.ParseCertificate(doc.Certificate); err == nil {
+		expires := cert.NotAfter.UTC()
+		view.Certificate, view.CertificateExpires = cert.Subject.String(), &expires
+	}
+	if len(doc.PublicKey) > 0 {
+		_, view.PublicKeyAlgorithm, _ = attestation.ParsePublicKey(doc.PublicKey)
+	}
+	if c.output == "json" {
+		return c.printJSON(view)
+	}
+
+	w := c.stdout
+	if verified {
+		fmt.Fprintln(w, "verified")
+	} else {
+		fmt.Fprintln(w, "NOT verified")
+	}
+	fmt.Fprintf(w, "module id:    %s\n", view.ModuleID)
+	fmt.Fprintf(w, "timestamp:    %s\n", view.Timestamp.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "digest:       %s\n", view.Digest)
+	if view.Certificate != "" {
+		fmt.Fprintf(w, "certificate:  %s, expires %s\n", view.Certificate, view.CertificateExpires.Format(time.RFC3339))
+	}
+	indices := make([]int, 0, len(view.PCRs))
+	for index := range view.PCRs {
+		indices = append(indices, int(index))
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		fmt.Fprintf(w, "%-13s %s\n", fmt.Sprintf("pcr%d:", index), hex.EncodeToString(view.PCRs[uint(index)]))
+	}
+	if len(view.PublicKey) > 0 {
+		digest := sha256.Sum256(view.PublicKey)
+		fmt.Fprintf(w, "public key:   %s, sha256 %s\n", orUnknown(string(view.PublicKeyAlgorithm)), hex.EncodeToString(digest[:]))
+	}
+	if len(view.UserData) > 0 {
+		fmt.Fprintf(w, "user data:    %s\n", base64.StdEncoding.EncodeToString(view.UserData))
+	}
+	if len(view.Nonce) > 0 {
+		fmt.Fprintf(w, "nonce:        %s\n", base64.StdEncoding.EncodeToString(view.Nonce))
+	}
+	return nil
+}
+
+// readDocument reads a document, as base64 or raw COSE bytes, from the file named by
+// args or from standard input.
+func (c *cli) readDocument(args []string) ([]byte, error) {
+	raw, err := c.readInput(args)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(raw)), "")); err == nil {
+		raw = doc
+	}
+	if len(raw) == 0 {
+		return nil, withCode(exitInput, errors.New("no attestation document in input"))
+	}
+	return raw, nil
+}
+
+// readPublicKey reads a PEM or DER public key and returns it as PKIX DER.
+func readPublicKey(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	if _, _, err := attestation.ParsePublicKey(b); err != nil {
+		return nil, withCode(exitInput, errors.Wrap(err, name))
+	}
+	return b, nil
+}
+
+// readRoots reads a PEM file of root certificates.
+func readRoots(name string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, withCode(exitInput, fmt.Errorf("%s: no PEM certificates", name))
+	}
+	return pool, nil
+}
+
+// readPolicy reads a JSON policy file.
+func readPolicy(name string) (*attestation.Policy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	policy := &attestation.Policy{}
+	if err := json.Unmarshal(b, policy); err != nil {
+		return nil, withCode(exitInput, errors.Wrapf(err, "%s: invalid policy", name))
+	}
+	return policy, nil
+}
+
+// decodeBase64Flag decodes the value of a base64 flag. An empty value decodes to nil.
+func decodeBase64Flag(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: not base64", name)
+	}
+	return b, nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"nitro/attest/attestation"
+	"nitro/attest/eif"
+	"os"
+	"sort"
+	"strings"
+)
+
+func (c *cli) eifCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "eif",
+		Short: "Measure and sign enclave image files offline",
+	}
+	cmd.AddCommand(c.eifDescribeCommand(), c.eifPolicyCommand(), c.eifSignCommand())
+	return cmd
+}
+
+func (c *cli) eifDescribeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe [FILE]",
+		Short: "Show the sections and PCRs of an enclave image file",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := c.readEIF(args)
+			if err != nil {
+				return err
+			}
+			type section struct {
+				Type string `json:"type"`
+				Size uint64 `json:"size"`
+			}
+			view := struct {
+				Version            uint16                           `json:"version"`
+				DefaultCPUs        uint64                           `json:"default_cpus"`
+				DefaultMemory      uint64                           `json:"default_memory"`
+				Sections           []section                        `json:"sections"`
+				PCRs               map[uint]attestation.Measurement `json:"pcrs"`
+				SigningCertificate string                           `json:"signing_certificate,omitempty"`
+				SignatureValid     *bool                            `json:"signature_valid,omitempty"`
+				Metadata           json.RawMessage                  `json:"metadata,omitempty"`
+			}{
+				Version:       img.Header.Version,
+				DefaultCPUs:   img.Header.DefaultCPUs,
+				DefaultMemory: img.Header.DefaultMemory,
+				PCRs:          img.PCRs,
+				Metadata:      img.Metadata,
+			}
+			for _, s := range img.Sections {
+				view.Sections = append(view.Sections, section{s.Type.String(), s.Size})
+			}
+			if img.SigningCertificate != nil {
+				valid := img.VerifySignature() == nil
+				view.SigningCertificate, view.SignatureValid = img.SigningCertificate.Subject.String(), &valid
+			}
+			if c.output == "json" {
+				return c.printJSON(view)
+			}
+
+			w := c.stdout
+			fmt.Fprintf(w, "version:      %d\n", view.Version)
+			fmt.Fprintf(w, "cpus:         %d\n", view.DefaultCPUs)
+			fmt.Fprintf(w, "memory:       %d MiB\n", view.DefaultMemory>>20)
+			sections := make([]string, len(view.Sections))
+			for i, s := range view.Sections {
+				sections[i] = fmt.Sprintf("%s (%d bytes)", s.Type, s.Size)
+			}
+			fmt.Fprintf(w, "sections:     %s\n", strings.Join(sections, ", "))
+			indices := make([]int, 0, len(view.PCRs))
+			for index := range view.PCRs {
+				indices = append(indices, int(index))
+			}
+			sort.Ints(indices)
+			for _, index := range indices {
+				fmt.Fprintf(w, "%-13s %s\n", fmt.Sprintf("pcr%d:", index), hex.EncodeToString(view.PCRs[uint(index)]))
+			}
+			if view.SigningCertificate != "" {
+				state := "valid"
+				if !*view.SignatureValid {
+					state = "INVALID"
+				}
+				fmt.Fprintf(w, "signed by:    %s, signature %s\n", view.SigningCertificate, state)
+			}
+			return nil
+		},
+	}
+}
+
+func (c *cli) eifPolicyCommand() *cobra.Command {
+	var pcrs []uint
+	cmd := &cobra.Command{
+		Use:   "policy [FILE]",
+		Short: "Print a PCR policy admitting enclaves booted from an enclave image file",
+		Long: "Print a PCR policy, for verify --policy, admitting enclaves booted from an enclave " +
+			"image file. By default it requires PCR0, PCR1 and PCR2, and PCR8 if the image is signed.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := c.readEIF(args)
+			if err != nil {
+				return err
+			}
+			policy, err := img.Policy(pcrs...)
+			if err != nil {
+				return err
+			}
+			return c.printJSON(policy)
+		},
+	}
+	cmd.Flags().UintSliceVar(&pcrs, "pcrs", nil, "comma-separated PCR indices to require (default: all the image determines)")
+	return cmd
+}
+
+func (c *cli) eifSignCommand() *cobra.Command {
+	var keyFile, certFile, out string
+	cmd := &cobra.Command{
+		Use:   "sign FILE",
+		Short: "Sign an enclave image file",
+		Long: "Sign an enclave image file the way nitro-cli build-enclave --private-key does, " +
+			"replacing any existing signature, and print the PCR8 enclaves booted from it report. " +
+			"The key must be an ECDSA key in PEM, matching the PEM certificate.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := readSigningKey(keyFile)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(certFile)
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			cert, err := eif.ParseCertificate(b)
+			if err != nil {
+				return withCode(exitInput, errors.Wrap(err, certFile))
+			}
+			src, err := os.Open(args[0])
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			defer src.Close()
+
+			var pcr8 attestation.Measurement
+			err = replaceFile(out, 0644, func(w io.Writer) error {
+				pcr8, err = eif.Sign(w, src, key, cert)
+				return withCode(exitInput, err)
+			})
+			if err != nil {
+				return err
+			}
+			if c.output == "json" {
+				return c.printJSON(struct {
+					PCR8 attestation.Measurement `json:"pcr8"`
+				}{pcr8})
+			}
+			fmt.Fprintf(c.stdout, "pcr8: %s\n", hex.EncodeToString(pcr8))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM ECDSA private key")
+	cmd.Flags().StringVar(&certFile, "cert", "", "PEM signing certificate")
+	cmd.Flags().StringVar(&out, "out", "", "file to write the signed image to; may be FILE itself")
+	for _, name := range []string{"key", "cert", "out"} {
+		cmd.MarkFlagRequired(name)
+	}
+	return cmd
+}
+
+// readSigningKey reads a PEM ECDSA private key, either PKCS #8 or the SEC 1 form openssl
+// ecparam writes.
+func readSigningKey(name string) (crypto.Signer, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, withCode(exitInput, fmt.Errorf("%s: no PEM private key", name))
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, withCode(exitInput, errors.Wrap(err, name))
+		}
+		return key, nil
+	}
+	key, _, err := attestation.ParsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, withCode(exitInput, errors.Wrap(err, name))
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, withCode(exitInput, fmt.Errorf("%s: key cannot sign", name))
+	}
+	return signer, nil
+}
+
+// readEIF parses the image in the file named by args or on standard input.
+func (c *cli) readEIF(args []string) (*eif.Image, error) {
+	var r io.Reader = c.stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, withCode(exitInput, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	img, err := eif.Read(bufio.NewReaderSize(r, 1<<20))
+	return img, withCode(exitInput, err)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"nitro/attest/attestation"
+	"os"
+)
+
+func (c *cli) keygenCommand() *cobra.Command {
+	var alg, out string
+	var fromNSM bool
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a keypair",
+		Long: "Generate a keypair and write it as PEM: the private key as PKCS #8 and the public " +
+			"key as PKIX, the form placed in attestation documents. With --out the keys go to " +
+			"PREFIX.key and PREFIX.pub, otherwise to standard output.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var entropy io.Reader = rand.Reader
+			if fromNSM {
+				sess, err := c.session()
+				if err != nil {
+					return err
+				}
+				defer sess.Close()
+				entropy = sess
+			}
+			pub, priv, err := attestation.GenerateKeypairFrom(entropy, attestation.KeyAlgorithm(alg))
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			der, err := attestation.MarshalPrivateKey(priv)
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+			pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+
+			if out != "" {
+				if err := os.WriteFile(out+".key", privPEM, 0600); err != nil {
+					return withCode(exitFailure, err)
+				}
+				if err := os.WriteFile(out+".pub", pubPEM, 0644); err != nil {
+					return withCode(exitFailure, err)
+				}
+			}
+			if c.output == "json" {
+				v := struct {
+					Algorithm  string `json:"algorithm"`
+					PublicKey  string `json:"public_key"`
+					PrivateKey string `json:"private_key,omitempty"`
+				}{alg, string(pubPEM), string(privPEM)}
+				if out != "" {
+					v.PrivateKey = ""
+				}
+				return c.printJSON(v)
+			}
+			if out != "" {
+				fmt.Fprintf(c.stdout, "wrote %s.key and %s.pub\n", out, out)
+				return nil
+			}
+			_, err = c.stdout.Write(append(privPEM, pubPEM...))
+			return withCode(exitFailure, err)
+		},
+	}
+	cmd.Flags().StringVar(&alg, "algorithm", string(attestation.P256), "key algorithm: P-256, P-384, Ed25519, X25519 or RSA")
+	cmd.Flags().StringVar(&out, "out", "", "write the keys to PREFIX.key and PREFIX.pub")
+	cmd.Flags().BoolVar(&fromNSM, "nsm", false, "draw entropy from the NSM instead of the operating system")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		switch attestation.KeyAlgorithm(alg) {
+		case attestation.P256, attestation.P384, attestation.Ed25519, attestation.X25519, attestation.RSA:
+			return nil
+		}
+		return errors.Errorf("unsupported key algorithm %q", alg)
+	}
+	return cmd
+}
@@ -0,0 +1,186 @@
+// Command attest works with Nitro Enclaves attestation: it creates nonces, requests and
+// verifies attestation documents, inspects them, manages PCRs, computes the PCRs of
+// enclave image files, bootstraps verification policies from reference documents,
+// keeps an audit log of verification decisions, generates keys, and runs the enclave
+// server and its parent-side proxy.
+//
+// Documents are read from a file argument or, if it is absent or "-", from standard
+// input, as base64 or raw COSE bytes. Output is text, or JSON with --output json.
+//
+// The exit status tells scripts what went wrong:
+//
+//	0  success
+//	1  unexpected failure
+//	2  usage error: unknown command, bad flag or argument
+//	3  input error: the input could not be read or decoded
+//	4  rejected: the document failed verification
+//	5  NSM error: the Nitro Secure Module is unavailable or refused the request
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/jessicatrinh/nsm"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"nitro/attest/attestation"
+	"os"
+	"path/filepath"
+)
+
+// Exit codes.
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitInput    = 3
+	exitRejected = 4
+	exitNSM      = 5
+)
+
+// exitError carries the exit code of a failed command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// withCode classifies err under an exit code. A nil err stays nil.
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// cli is the environment commands run in.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// openSession opens the NSM. Tests substitute a simulator.
+	openSession func() (attestation.Session, error)
+	// output is "text" or "json".
+	output string
+}
+
+func main() {
+	c := &cli{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		openSession: func() (attestation.Session, error) {
+			return nsm.OpenDefaultSession()
+		},
+	}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+// run executes the command line args and returns the exit code. Errors returned by
+// commands carry their code; any other error comes from cobra's own argument parsing
+// and is a usage error.
+func (c *cli) run(args []string) int {
+	root := c.rootCommand()
+	root.SetArgs(args)
+	err := root.Execute()
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(c.stderr, "attest: %v\n", err)
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return exitUsage
+}
+
+func (c *cli) rootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "attest",
+		Short:         "Create, verify and inspect Nitro Enclaves attestations",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if c.output != "text" && c.output != "json" {
+				return fmt.Errorf("unknown output format %q", c.output)
+			}
+			return nil
+		},
+	}
+	root.SetIn(c.stdin)
+	root.SetOut(c.stdout)
+	root.SetErr(c.stderr)
+	root.PersistentFlags().StringVarP(&c.output, "output", "o", "text", `output format, "text" or "json"`)
+	root.AddCommand(
+		c.nonceCommand(),
+		c.attestCommand(),
+		c.verifyCommand(),
+		c.inspectCommand(),
+		c.pcrCommand(),
+		c.keygenCommand(),
+		c.eifCommand(),
+		c.policyCommand(),
+		c.auditCommand(),
+		c.tlogCommand(),
+		c.serveCommand(),
+		c.proxyCommand(),
+	)
+	return root
+}
+
+// session opens the NSM, classifying failures as NSM errors.
+func (c *cli) session() (attestation.Session, error) {
+	sess, err := c.openSession()
+	if err != nil {
+		return nil, withCode(exitNSM, fmt.Errorf("cannot open nsm session: %v", err))
+	}
+	return sess, nil
+}
+
+// printJSON writes v as indented JSON.
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return withCode(exitFailure, enc.Encode(v))
+}
+
+// readInput reads the file named by the first argument, or standard input if there is
+// none or it is "-".
+func (c *cli) readInput(args []string) ([]byte, error) {
+	if len(args) == 0 || args[0] == "-" {
+		b, err := io.ReadAll(c.stdin)
+		return b, withCode(exitInput, err)
+	}
+	b, err := os.ReadFile(args[0])
+	return b, withCode(exitInput, err)
+}
+
+// replaceFile writes a file through write, first to a temporary file beside it which is
+// then renamed over it, so the file is never left half written and may be one of the
+// command's inputs. Errors returned by write are passed through unclassified.
+func replaceFile(name string, mode os.FileMode, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return withCode(exitFailure, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w := bufio.NewWriterSize(f, 1<<20)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return withCode(exitFailure, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		return withCode(exitFailure, err)
+	}
+	if err := f.Close(); err != nil {
+		return withCode(exitFailure, err)
+	}
+	return withCode(exitFailure, os.Rename(f.Name(), name))
+}
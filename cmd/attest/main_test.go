@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"math/big"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/audit"
+	"nitro/attest/eif"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// execute runs the command line args with stdin as input and returns the exit code and
+// outputs.
+func execute(open func() (attestation.Session, error), stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr, openSession: open}
+	code := c.run(args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0a}, 48)
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	sim, err := ca.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	open := func() (attestation.Session, error) { return sim, nil }
+	noNSM := func() (attestation.Session, error) { return nil, errors.New("no such device") }
+
+	dir := t.TempDir()
+	roots := filepath.Join(dir, "roots.pem")
+	require.NoError(t, os.WriteFile(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate().Raw}), 0644))
+	writePolicy := func(name string, pcrs map[uint]attestation.Measurement) string {
+		b, err := json.Marshal(&attestation.Policy{PCRs: pcrs})
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, b, 0644))
+		return path
+	}
+
+	code, nonce, _ := execute(noNSM, "", "nonce")
+	require.Equal(t, exitOK, code)
+	nonce = strings.TrimSpace(nonce)
+	code, encoded, stderr := execute(open, "", "attest", "--nonce", nonce, "--user-data", "hello")
+	require.Equal(t, exitOK, code, stderr)
+	doc, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	require.NoError(t, err)
+
+	t.Run("nonce", func(t *testing.T) {
+		code, out, _ := execute(noNSM, "", "nonce", "-o", "json", "--lifetime", "1m")
+		require.Equal(t, exitOK, code)
+		var v struct {
+			Nonce   string `json:"nonce"`
+			Expires string `json:"expires"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(out), &v))
+		_, err := base64.StdEncoding.DecodeString(v.Nonce)
+		require.NoError(t, err)
+		require.NotEmpty(t, v.Expires)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		code, out, stderr := execute(noNSM, encoded, "verify", "--roots", roots, "--nonce", nonce,
+			"--policy", writePolicy("policy.json", map[uint]attestation.Measurement{0: pcr}), "--max-age", "1m")
+		require.Equal(t, exitOK, code, stderr)
+		require.True(t, strings.HasPrefix(out, "verified\n"))
+		require.Contains(t, out, "module id:    "+sim.ModuleID+"\n")
+		require.Contains(t, out, "pcr0:         "+strings.Repeat("0a", 48)+"\n")
+		require.NotContains(t, out, "pcr1:")
+		require.Contains(t, out, "user data:    "+base64.StdEncoding.EncodeToString([]byte("hello"))+"\n")
+
+		raw := filepath.Join(dir, "doc.cbor")
+		require.NoError(t, os.WriteFile(raw, doc, 0644))
+		code, out, stderr = execute(noNSM, "", "verify", "--roots", roots, "-o", "json", raw)
+		require.Equal(t, exitOK, code, stderr)
+		var view documentView
+		require.NoError(t, json.Unmarshal([]byte(out), &view))
+		require.True(t, view.Verified)
+		require.Equal(t, sim.ModuleID, view.ModuleID)
+		require.Equal(t, map[uint]attestation.Measurement{0: pcr}, view.PCRs)
+		require.Equal(t, []byte("hello"), view.UserData)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			args []string
+			err  string
+		}{
+			{"untrusted root", nil, "attestation rejected"},
+			{"policy", []string{"--roots", roots, "--policy", writePolicy("other.json", map[uint]attestation.Measurement{0: make([]byte, 48)})}, "attestation rejected: PCR0 mismatch"},
+			{"nonce", []string{"--roots", roots, "--nonce", "b3RoZXI="}, "attestation rejected: mismatched nonce"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				code, out, stderr := execute(noNSM, encoded, append([]string{"verify"}, tc.args...)...)
+				require.Equal(t, exitRejected, code)
+				require.Empty(t, out)
+				require.Contains(t, stderr, tc.err)
+			})
+		}
+	})
+
+	t.Run("policy bootstrap", func(t *testing.T) {
+		policyFile := filepath.Join(dir, "bootstrapped.json")
+		code, _, stderr := execute(noNSM, encoded, "policy", "bootstrap", "--roots", roots, "--pcrs", "0",
+			"--name", "v1", "--module-id", "instance", "--out", policyFile)
+		require.Equal(t, exitOK, code, stderr)
+		policy, err := readPolicy(policyFile)
+		require.NoError(t, err)
+		require.Equal(t, []attestation.Build{{Name: "v1", PCRs: map[uint]attestation.Measurement{0: pcr}}}, policy.Builds)
+		require.Equal(t, attestation.RootFingerprint(ca.Certificate()), policy.RootFingerprint)
+		require.Equal(t, attestation.DebugDeny, policy.Debug)
+		require.NotEmpty(t, policy.Comment)
+
+		// A new build from the same instance is merged in place.
+		next, err := ca.NewSimulator(map[uint][]byte{0: bytes.Repeat([]byte{0x0b}, 48)})
+		require.NoError(t, err)
+		next.ModuleID = sim.ModuleID[:strings.LastIndex(sim.ModuleID, "-enc")] + "-enc0000000000000001"
+		code, nextDoc, stderr := execute(func() (attestation.Session, error) { return next, nil }, "", "attest")
+		require.Equal(t, exitOK, code, stderr)
+		code, out, stderr := execute(noNSM, nextDoc, "verify", "--roots", roots, "--policy", policyFile)
+		require.Equal(t, exitRejected, code)
+		require.Contains(t, stderr, "PCRs match none of the 1 allowed builds")
+		code, out, stderr = execute(noNSM, nextDoc, "policy", "bootstrap", "--roots", roots, "--pcrs", "0",
+			"--name", "v2", "--module-id", "instance", "--merge", policyFile, "--out", policyFile)
+		require.Equal(t, exitOK, code, stderr)
+		require.Empty(t, out)
+		for _, d := range []string{encoded, nextDoc} {
+			code, _, stderr = execute(noNSM, d, "verify", "--roots", roots, "--policy", policyFile)
+			require.Equal(t, exitOK, code, stderr)
+		}
+
+		code, out, stderr = execute(noNSM, nextDoc, "policy", "bootstrap", "--roots", roots, "--pcrs", "0",
+			"--module-id", "instance", "--merge", policyFile)
+		require.Equal(t, exitOK, code, stderr)
+		require.Contains(t, stderr, "already admits this build")
+		require.Contains(t, out, `"name": "v2"`)
+
+		code, _, stderr = execute(noNSM, nextDoc, "policy", "bootstrap", "--roots", roots, "--merge", policyFile)
+		require.Equal(t, exitInput, code)
+		require.Contains(t, stderr, "cannot merge policies with different module ID patterns")
+		code, _, _ = execute(noNSM, encoded, "policy", "bootstrap", "--module-id", "rack")
+		require.Equal(t, exitUsage, code)
+		code, _, _ = execute(noNSM, encoded, "policy", "bootstrap")
+		require.Equal(t, exitRejected, code, "the reference must chain to a trusted root")
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		db := filepath.Join(dir, "audit.db")
+		code, _, stderr := execute(noNSM, encoded, "verify", "--roots", roots, "--audit", db)
+		require.Equal(t, exitOK, code, stderr)
+		code, _, _ = execute(noNSM, encoded, "verify", "--roots", roots, "--audit", db,
+			"--policy", writePolicy("audited.json", map[uint]attestation.Measurement{0: make([]byte, 48)}))
+		require.Equal(t, exitRejected, code)
+
+		code, out, stderr := execute(noNSM, "", "audit", "export", "--module-id", sim.ModuleID, db)
+		require.Equal(t, exitOK, code, stderr)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		require.Len(t, lines, 2)
+		var accepted, rejected audit.Record
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &accepted))
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &rejected))
+		require.Equal(t, audit.Accepted, accepted.Outcome)
+		require.Equal(t, hex.EncodeToString(attestation.DocumentHash(doc)), accepted.DocumentHash)
+		require.Equal(t, audit.Rejected, rejected.Outcome)
+		require.Equal(t, "PCR0 mismatch", rejected.Error)
+
+		code, out, _ = execute(noNSM, "", "audit", "export", "--since", time.Now().Add(time.Hour).Format(time.RFC3339), db)
+		require.Equal(t, exitOK, code)
+		require.Empty(t, out)
+		code, _, _ = execute(noNSM, "", "audit", "export", "--module-id", "i-other-enc0", filepath.Join(dir, "missing.db"))
+		require.Equal(t, exitInput, code)
+
+		code, out, stderr = execute(noNSM, "", "audit", "prune", "--retention", "1ns", db)
+		require.Equal(t, exitOK, code, stderr)
+		require.Equal(t, "pruned 2 records\n", out)
+	})
+
+	t.Run("tlog", func(t *testing.T) {
+		db, key := filepath.Join(dir, "tlog.db"), filepath.Join(dir, "tlog")
+		code, _, stderr := execute(noNSM, "", "keygen", "--out", key)
+		require.Equal(t, exitOK, code, stderr)
+		file := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			return path
+		}
+
+		code, _, stderr = execute(noNSM, encoded, "verify", "--roots", roots, "--tlog", db)
+		require.Equal(t, exitOK, code, stderr)
+		code, out, stderr := execute(noNSM, "", "tlog", "sth", "--key", key+".key", db)
+		require.Equal(t, exitOK, code, stderr)
+		first := file("sth1.json", out)
+		code, _, _ = execute(noNSM, encoded, "verify", "--roots", roots, "--tlog", db,
+			"--policy", writePolicy("logged.json", map[uint]attestation.Measurement{0: make([]byte, 48)}))
+		require.Equal(t, exitRejected, code)
+		code, _, stderr = execute(noNSM, encoded, "verify", "--roots", roots, "--tlog", db)
+		require.Equal(t, exitOK, code, stderr)
+		code, out, stderr = execute(noNSM, "", "tlog", "sth", "--key", key+".key", db)
+		require.Equal(t, exitOK, code, stderr)
+		second := file("sth2.json", out)
+		var sth struct {
+			TreeSize uint64 `json:"tree_size"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(out), &sth))
+		require.Equal(t, uint64(2), sth.TreeSize, "rejected documents are not logged")
+
+		code, out, _ = execute(noNSM, "", "tlog", "get", db, "1")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, encoded, out)
+
+		code, out, stderr = execute(noNSM, "", "tlog", "prove", "--index", "0", db)
+		require.Equal(t, exitOK, code, stderr)
+		inclusion := file("inclusion.json", out)
+		code, out, stderr = execute(noNSM, "", "tlog", "prove", "--from", "1", db)
+		require.Equal(t, exitOK, code, stderr)
+		consistency := file("consistency.json", out)
+
+		code, out, stderr = execute(noNSM, "", "tlog", "check", "--public-key", key+".pub",
+			"--inclusion", inclusion, "--consistency", consistency, "--from", first, second)
+		require.Equal(t, exitOK, code, stderr)
+		require.Contains(t, out, "verified tree head of size 2 ")
+		require.Contains(t, out, "entry 0 is included: document "+hex.EncodeToString(attestation.DocumentHash(doc))+" from "+sim.ModuleID+"\n")
+		require.Contains(t, out, "tree extends the tree of size 1\n")
+
+		code, _, _ = execute(noNSM, "", "tlog", "check", "--public-key", key+".pub", "--inclusion", inclusion, first)
+		require.Equal(t, exitRejected, code, "the proof is for a larger tree")
+		code, _, _ = execute(noNSM, "", "tlog", "check", "--public-key", roots, second)
+		require.Equal(t, exitInput, code)
+		code, _, _ = execute(noNSM, "", "tlog", "prove", "--index", "0", "--from", "1", db)
+		require.Equal(t, exitUsage, code)
+		code, _, _ = execute(noNSM, "", "tlog", "get", filepath.Join(dir, "missing.db"), "0")
+		require.Equal(t, exitInput, code)
+	})
+
+	t.Run("inspect", func(t *testing.T) {
+		code, out, _ := execute(noNSM, encoded, "inspect")
+		require.Equal(t, exitOK, code)
+		require.True(t, strings.HasPrefix(out, "NOT verified\n"))
+		require.Contains(t, out, sim.ModuleID)
+		require.Contains(t, out, "nonce:        "+nonce+"\n")
+	})
+
+	t.Run("input errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			stdin string
+			args  []string
+		}{
+			{"", []string{"inspect"}},
+			{"not a document", []string{"inspect"}},
+			{"bm90IGEgZG9jdW1lbnQ=", []string{"inspect"}},
+			{"bm90IGEgZG9jdW1lbnQ=", []string{"verify", "--roots", roots}},
+			{"", []string{"verify", filepath.Join(dir, "missing")}},
+			{encoded, []string{"verify", "--roots", filepath.Join(dir, "missing")}},
+			{encoded, []string{"verify", "--policy", roots}},
+		} {
+			code, _, stderr := execute(noNSM, tc.stdin, tc.args...)
+			require.Equal(t, exitInput, code, "%v: %s", tc.args, stderr)
+		}
+	})
+
+	t.Run("usage errors", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"frobnicate"},
+			{"verify", "--frobnicate"},
+			{"verify", "a", "b"},
+			{"verify", "--nonce", "!"},
+			{"nonce", "-o", "yaml"},
+			{"pcr", "describe", "x"},
+			{"pcr", "lock", "--below", "0"},
+			{"keygen", "--algorithm", "DSA"},
+			{"serve", "--log-level", "loud"},
+		} {
+			code, _, stderr := execute(open, "", args...)
+			require.Equal(t, exitUsage, code, "%v: %s", args, stderr)
+			require.True(t, strings.HasPrefix(stderr, "attest: "), stderr)
+		}
+	})
+
+	t.Run("pcr", func(t *testing.T) {
+		code, out, _ := execute(open, "", "pcr", "describe", "0")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "pcr0: "+strings.Repeat("0a", 48)+" locked\n", out)
+
+		code, out, _ = execute(open, "measured", "pcr", "extend", "16", "-o", "json")
+		require.Equal(t, exitOK, code)
+		var extended struct {
+			Index  uint16
+			Value  attestation.Measurement
+			Locked bool
+		}
+		require.NoError(t, json.Unmarshal([]byte(out), &extended))
+		require.Equal(t, uint16(16), extended.Index)
+		require.Len(t, extended.Value, 48)
+		require.False(t, extended.Locked)
+
+		code, out, _ = execute(open, "", "pcr", "lock", "16")
+		require.Equal(t, exitOK, code)
+		require.Equal(t, "locked pcr16\n", out)
+		code, _, stderr := execute(open, "more", "pcr", "extend", "16")
+		require.Equal(t, exitNSM, code)
+		require.Contains(t, stderr, "ReadOnlyIndex")
+
+		code, _, _ = execute(open, "", "pcr", "describe", "99")
+		require.Equal(t, exitNSM, code)
+		code, _, stderr = execute(noNSM, "", "pcr", "describe", "0")
+		require.Equal(t, exitNSM, code)
+		require.Equal(t, "attest: cannot open nsm session: no such device\n", stderr)
+		code, _, _ = execute(noNSM, "", "attest")
+		require.Equal(t, exitNSM, code)
+	})
+
+	t.Run("keygen", func(t *testing.T) {
+		for _, alg := range []attestation.KeyAlgorithm{attestation.P256, attestation.P384, attestation.Ed25519, attestation.X25519, attestation.RSA} {
+			code, out, stderr := execute(open, "", "keygen", "--nsm", "--algorithm", string(alg))
+			require.Equal(t, exitOK, code, stderr)
+			privBlock, rest := pem.Decode([]byte(out))
+			require.Equal(t, "PRIVATE KEY", privBlock.Type)
+			pubBlock, _ := pem.Decode(rest)
+			require.Equal(t, "PUBLIC KEY", pubBlock.Type)
+			_, privAlg, err := attestation.ParsePrivateKey(privBlock.Bytes)
+			require.NoError(t, err)
+			_, pubAlg, err := attestation.ParsePublicKey(pubBlock.Bytes)
+			require.NoError(t, err)
+			require.Equal(t, alg, privAlg)
+			require.Equal(t, alg, pubAlg)
+		}
+
+		prefix := filepath.Join(dir, "enclave")
+		code, _, _ := execute(noNSM, "", "keygen", "--out", prefix)
+		require.Equal(t, exitOK, code)
+		info, err := os.Stat(prefix + ".key")
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		code, encoded, stderr := execute(open, "", "attest", "--public-key", prefix+".pub")
+		require.Equal(t, exitOK, code, stderr)
+		code, out, _ := execute(noNSM, encoded, "inspect")
+		require.Equal(t, exitOK, code)
+		require.Contains(t, out, "public key:   P-256, sha256 ")
+	})
+}
+
+// buildEIF lays out an unsigned image with one section of each content type.
+func buildEIF(t *testing.T, kernel, cmdline, bootstrap, app []byte) []byte {
+	h := eif.Header{Magic: eif.Magic, Version: 4, DefaultCPUs: 2, DefaultMemory: 512 << 20, SectionCount: 4}
+	var body bytes.Buffer
+	types := []eif.SectionType{eif.SectionKernel, eif.SectionCmdline, eif.SectionRamdisk, eif.SectionRamdisk}
+	for i, data := range [][]byte{kernel, cmdline, bootstrap, app} {
+		h.SectionOffsets[i] = uint64(eif.HeaderSize + body.Len())
+		h.SectionSizes[i] = uint64(len(data))
+		require.NoError(t, binary.Write(&body, binary.BigEndian, eif.SectionHeader{Type: types[i], Size: uint64(len(data))}))
+		body.Write(data)
+	}
+	var header bytes.Buffer
+	require.NoError(t, binary.Write(&header, binary.BigEndian, h))
+	h.CRC32 = crc32.Update(crc32.ChecksumIEEE(header.Bytes()[:eif.HeaderSize-4]), crc32.IEEETable, body.Bytes())
+	header.Reset()
+	require.NoError(t, binary.Write(&header, binary.BigEndian, h))
+	return append(header.Bytes(), body.Bytes()...)
+}
+
+func TestEIF(t *testing.T) {
+	image := buildEIF(t, []byte("kernel"), []byte("console=ttyS0"), []byte("init"), []byte("app"))
+	noNSM := func() (attestation.Session, error) { return nil, errors.New("no such device") }
+	name := filepath.Join(t.TempDir(), "enclave.eif")
+	require.NoError(t, os.WriteFile(name, image, 0644))
+
+	code, out, stderr := execute(noNSM, "", "eif", "describe", name)
+	require.Equal(t, exitOK, code, stderr)
+	require.Contains(t, out, "sections:     kernel (6 bytes), cmdline (13 bytes), ramdisk (4 bytes), ramdisk (3 bytes)\n")
+	require.Contains(t, out, "pcr2:         "+hex.EncodeToString(eif.Measure([]byte("app"))))
+	require.NotContains(t, out, "pcr8")
+
+	code, out, _ = execute(noNSM, string(image), "eif", "policy", "--pcrs", "0,2")
+	require.Equal(t, exitOK, code)
+	var policy attestation.Policy
+	require.NoError(t, json.Unmarshal([]byte(out), &policy))
+	require.Equal(t, []uint{0, 2}, policy.Indices())
+	require.Equal(t, eif.Measure([]byte("app")), policy.PCRs[2])
+
+	code, _, _ = execute(noNSM, string(image), "eif", "policy", "--pcrs", "8")
+	require.Equal(t, exitUsage, code)
+	code, _, _ = execute(noNSM, "not an image", "eif", "describe")
+	require.Equal(t, exitInput, code)
+
+	t.Run("sign", func(t *testing.T) {
+		dir := t.TempDir()
+		prefix := filepath.Join(dir, "signer")
+		code, _, stderr := execute(noNSM, "", "keygen", "--algorithm", "P-384", "--out", prefix)
+		require.Equal(t, exitOK, code, stderr)
+		keyPEM, err := os.ReadFile(prefix + ".key")
+		require.NoError(t, err)
+		block, _ := pem.Decode(keyPEM)
+		key, _, err := attestation.ParsePrivateKey(block.Bytes)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "release signer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		signer := key.(*ecdsa.PrivateKey)
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &signer.PublicKey, signer)
+		require.NoError(t, err)
+		certFile := filepath.Join(dir, "signer.crt")
+		require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+		sec1, err := x509.MarshalECPrivateKey(signer)
+		require.NoError(t, err)
+		sec1File := filepath.Join(dir, "signer.sec1")
+		require.NoError(t, os.WriteFile(sec1File, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), 0600))
+
+		signed := filepath.Join(dir, "signed.eif")
+		for _, keyFile := range []string{prefix + ".key", sec1File} {
+			require.NoError(t, os.WriteFile(signed, image, 0644))
+			code, out, stderr := execute(noNSM, "", "eif", "sign", "--key", keyFile, "--cert", certFile, "--out", signed, signed)
+			require.Equal(t, exitOK, code, stderr)
+			require.Equal(t, "pcr8: "+hex.EncodeToString(eif.Measure(der))+"\n", out)
+		}
+		info, err := os.Stat(signed)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+		code, out, _ := execute(noNSM, "", "eif", "describe", signed)
+		require.Equal(t, exitOK, code)
+		require.Contains(t, out, "pcr8:         "+hex.EncodeToString(eif.Measure(der)))
+		require.Contains(t, out, "signed by:    CN=release signer, signature valid\n")
+		code, out, _ = execute(noNSM, "", "eif", "policy", signed)
+		require.Equal(t, exitOK, code)
+		require.NoError(t, json.Unmarshal([]byte(out), &policy))
+		require.Equal(t, []uint{0, 1, 2, 8}, policy.Indices())
+
+		code, _, _ = execute(noNSM, "", "eif", "sign", "--key", certFile, "--cert", certFile, "--out", signed, signed)
+		require.Equal(t, exitInput, code)
+		code, _, _ = execute(noNSM, "", "eif", "sign", "--cert", certFile, "--out", signed, signed)
+		require.Equal(t, exitUsage, code)
+	})
+}
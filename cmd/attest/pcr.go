@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/jessicatrinh/nsm/request"
+	"github.com/jessicatrinh/nsm/response"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"nitro/attest/attestation"
+	"strconv"
+)
+
+func (c *cli) pcrCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pcr",
+		Short: "Describe, extend and lock PCRs of the NSM",
+		Long:  "Describe, extend and lock PCRs of the NSM. Must run inside an enclave.",
+	}
+	cmd.AddCommand(c.pcrDescribeCommand(), c.pcrExtendCommand(), c.pcrLockCommand())
+	return cmd
+}
+
+func (c *cli) pcrDescribeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "describe INDEX",
+		Short: "Show the value of a PCR and whether it is locked",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			index, err := parseIndex(args[0])
+			if err != nil {
+				return err
+			}
+			res, err := c.send(&request.DescribePCR{Index: index})
+			if err != nil {
+				return err
+			}
+			return c.printPCR(index, res.DescribePCR.Data, res.DescribePCR.Lock)
+		},
+	}
+}
+
+func (c *cli) pcrExtendCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "extend INDEX [FILE]",
+		Short: "Extend a PCR with the contents of a file or standard input",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			index, err := parseIndex(args[0])
+			if err != nil {
+				return err
+			}
+			data, err := c.readInput(args[1:])
+			if err != nil {
+				return err
+			}
+			res, err := c.send(&request.ExtendPCR{Index: index, Data: data})
+			if err != nil {
+				return err
+			}
+			return c.printPCR(index, res.ExtendPCR.Data, false)
+		},
+	}
+}
+
+func (c *cli) pcrLockCommand() *cobra.Command {
+	var below bool
+	cmd := &cobra.Command{
+		Use:   "lock INDEX",
+		Short: "Lock a PCR against further extension",
+		Long:  "Lock a PCR against further extension, or with --below every PCR below INDEX.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			index, err := parseIndex(args[0])
+			if err != nil {
+				return err
+			}
+			if below && index == 0 {
+				return errors.New("--below needs an index above 0")
+			}
+			var req request.Request = &request.LockPCR{Index: index}
+			if below {
+				req = &request.LockPCRs{Range: index}
+			}
+			if _, err := c.send(req); err != nil {
+				return err
+			}
+			if c.output == "json" {
+				return c.printJSON(struct {
+					Locked uint16 `json:"locked"`
+					Below  bool   `json:"below,omitempty"`
+				}{index, below})
+			}
+			if below {
+				fmt.Fprintf(c.stdout, "locked pcr0 to pcr%d\n", index-1)
+			} else {
+				fmt.Fprintf(c.stdout, "locked pcr%d\n", index)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&below, "below", false, "lock every PCR below INDEX instead of INDEX itself")
+	return cmd
+}
+
+// send submits one request to the NSM. Failures to reach the NSM and errors it reports
+// are NSM errors.
+func (c *cli) send(req request.Request) (response.Response, error) {
+	sess, err := c.session()
+	if err != nil {
+		return response.Response{}, err
+	}
+	defer sess.Close()
+	res, err := sess.Send(req)
+	if err != nil {
+		return response.Response{}, withCode(exitNSM, err)
+	}
+	if res.Error != "" {
+		return response.Response{}, withCode(exitNSM, fmt.Errorf("nsm refused the request: %s", res.Error))
+	}
+	return res, nil
+}
+
+func (c *cli) printPCR(index uint16, value []byte, locked bool) error {
+	if c.output == "json" {
+		return c.printJSON(struct {
+			Index  uint16                  `json:"index"`
+			Value  attestation.Measurement `json:"value"`
+			Locked bool                    `json:"locked"`
+		}{index, value, locked})
+	}
+	state := "unlocked"
+	if locked {
+		state = "locked"
+	}
+	fmt.Fprintf(c.stdout, "pcr%d: %s %s\n", index, hex.EncodeToString(value), state)
+	return nil
+}
+
+// parseIndex parses a PCR index argument. Malformed indices are usage errors; the NSM
+// judges whether the index exists.
+func parseIndex(arg string) (uint16, error) {
+	index, err := strconv.ParseUint(arg, 10, 16)
+	if err != nil {
+		return 0, errors.New("PCR index must be a non-negative integer")
+	}
+	return uint16(index), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"nitro/attest/attestation"
+	"time"
+)
+
+func (c *cli) policyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Create and maintain verification policies",
+	}
+	cmd.AddCommand(c.policyBootstrapCommand())
+	return cmd
+}
+
+func (c *cli) policyBootstrapCommand() *cobra.Command {
+	var rootsFile, at, name, scope, mergeFile, out string
+	var pcrs []uint
+	var allowDebug bool
+	cmd := &cobra.Command{
+		Use:   "bootstrap [FILE]",
+		Short: "Derive a policy from a trusted reference attestation document",
+		Long: "Verify a reference attestation document, made in a controlled environment by the " +
+			"build to approve, and print a policy admitting that build: its PCR values, the root " +
+			"its certificate chains to, a module ID pattern and a stance on debug mode. With " +
+			"--merge, the build is added to the allowlist of an existing policy instead.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch attestation.ModuleIDScope(scope) {
+			case attestation.ModuleIDAny, attestation.ModuleIDInstance, attestation.ModuleIDExact:
+			default:
+				return fmt.Errorf("invalid --module-id %q", scope)
+			}
+			opts := attestation.VerifyOptions{CurrentTime: time.Now()}
+			if at != "" {
+				t, err := time.Parse(time.RFC3339, at)
+				if err != nil {
+					return fmt.Errorf("invalid --time: %v", err)
+				}
+				opts.CurrentTime = t
+			}
+			var err error
+			if rootsFile != "" {
+				if opts.Roots, err = readRoots(rootsFile); err != nil {
+					return err
+				}
+			}
+			var existing *attestation.Policy
+			if mergeFile != "" {
+				if existing, err = readPolicy(mergeFile); err != nil {
+					return err
+				}
+			}
+			doc, err := c.readDocument(args)
+			if err != nil {
+				return err
+			}
+			res, err := attestation.VerifyDocument(doc, opts)
+			if err != nil {
+				return withCode(exitRejected, errors.Wrap(err, "reference document rejected"))
+			}
+			roots, err := attestation.ChainRoots(res, opts)
+			if err != nil {
+				return withCode(exitRejected, errors.Wrap(err, "reference document rejected"))
+			}
+			policy, err := attestation.BootstrapPolicy(res, roots[0], attestation.BootstrapOptions{
+				PCRs:       pcrs,
+				Name:       name,
+				ModuleID:   attestation.ModuleIDScope(scope),
+				AllowDebug: allowDebug,
+			})
+			if err != nil {
+				return withCode(exitRejected, err)
+			}
+			if existing != nil {
+				added, err := existing.Merge(policy)
+				if err != nil {
+					return withCode(exitInput, errors.Wrap(err, mergeFile))
+				}
+				if added == 0 {
+					fmt.Fprintf(c.stderr, "%s already admits this build\n", mergeFile)
+				}
+				policy = existing
+			}
+			write := func(w io.Writer) error {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return withCode(exitFailure, enc.Encode(policy))
+			}
+			if out == "" {
+				return write(c.stdout)
+			}
+			return replaceFile(out, 0644, write)
+		},
+	}
+	cmd.Flags().StringVar(&rootsFile, "roots", "", "PEM file of trusted root certificates (default: the AWS Nitro Enclaves root)")
+	cmd.Flags().StringVar(&at, "time", "", "RFC 3339 time at which to verify the certificates (default: now)")
+	cmd.Flags().UintSliceVar(&pcrs, "pcrs", nil, "comma-separated PCR indices to pin (default: 0,1,2)")
+	cmd.Flags().StringVar(&name, "name", "", "name of the build, e.g. its release tag (default: module ID and time)")
+	cmd.Flags().StringVar(&scope, "module-id", "any", `module IDs to admit: "any", "instance" (the reference's parent instance) or "exact"`)
+	cmd.Flags().BoolVar(&allowDebug, "allow-debug", false, "admit debug-mode enclaves")
+	cmd.Flags().StringVar(&mergeFile, "merge", "", "existing policy file to add the build to")
+	cmd.Flags().StringVar(&out, "out", "", "file to write the policy to, which may be the --merge file (default: standard output)")
+	return cmd
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"nitro/attest/attestation"
+	"nitro/attest/keyring"
+	"nitro/attest/logging"
+	"nitro/attest/proxy"
+	"nitro/attest/server"
+	"nitro/attest/vsock"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (c *cli) serveCommand() *cobra.Command {
+	var port uint32
+	var alg, level string
+	var rotate time.Duration
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve attestation and key requests over vsock",
+		Long:  "Serve attestation and key requests from the parent instance over vsock. Must run inside an enclave.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			min, err := parseLevel(level)
+			if err != nil {
+				return err
+			}
+			logger := log.New(c.stderr, "", log.LstdFlags)
+			attestation.SetLogger(logging.Std(logger, min))
+			defer attestation.SetLogger(nil)
+			sess, err := c.session()
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+
+			keys, err := keyring.NewManager(sess, keyring.Options{
+				Algorithm: attestation.KeyAlgorithm(alg),
+				Interval:  rotate,
+				Overlap:   rotate / 6,
+			})
+			if err != nil {
+				return withCode(exitFailure, fmt.Errorf("cannot create enclave key: %v", err))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go keys.Run(ctx, func(err error) {
+				logger.Printf("key rotation failed: %v", err)
+			})
+
+			l, err := vsock.Listen(port)
+			if err != nil {
+				return withCode(exitFailure, fmt.Errorf("cannot listen on vsock port %d: %v", port, err))
+			}
+			logger.Printf("serving on %s", l.Addr())
+			srv := &server.Server{Session: sess, Keys: keys}
+			defer closeOnSignal(srv)()
+			if err := srv.Serve(l); err != server.ErrServerClosed {
+				return withCode(exitFailure, err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Uint32Var(&port, "port", 5005, "vsock port to listen on")
+	cmd.Flags().StringVar(&alg, "key-algorithm", string(attestation.P256), "algorithm of the enclave key")
+	cmd.Flags().DurationVar(&rotate, "rotate", time.Hour, "key rotation interval")
+	cmd.Flags().StringVar(&level, "log-level", "info", `least severe attestation events to log: "debug", "info", "warn" or "error"`)
+	return cmd
+}
+
+// parseLevel parses the value of a --log-level flag.
+func parseLevel(s string) (attestation.Level, error) {
+	for _, level := range []attestation.Level{attestation.LevelDebug, attestation.LevelInfo, attestation.LevelWarn, attestation.LevelError} {
+		if s == level.String() {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid --log-level %q", s)
+}
+
+func (c *cli) proxyCommand() *cobra.Command {
+	var listen string
+	var cid, port uint32
+	var maxConns int
+	var idle time.Duration
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Forward TCP connections on the parent instance to the enclave",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := log.New(c.stderr, "", log.LstdFlags)
+			p := &proxy.Proxy{
+				Dial:        proxy.VsockDialer(cid, port),
+				MaxConns:    maxConns,
+				IdleTimeout: idle,
+				AccessLog:   logger,
+			}
+			logger.Printf("forwarding %s to vsock %d:%d", listen, cid, port)
+			defer closeOnSignal(p)()
+			if err := p.ListenAndServe(listen); err != proxy.ErrProxyClosed {
+				return withCode(exitFailure, err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&listen, "listen", ":8443", "TCP address to listen on")
+	cmd.Flags().Uint32Var(&cid, "cid", 16, "context identifier of the enclave")
+	cmd.Flags().Uint32Var(&port, "port", 5005, "vsock port in the enclave")
+	cmd.Flags().IntVar(&maxConns, "max-conns", 0, "maximum concurrent connections (default: no limit)")
+	cmd.Flags().DurationVar(&idle, "idle-timeout", proxy.DefaultIdleTimeout, "close connections idle for this long")
+	return cmd
+}
+
+// closeOnSignal closes c on SIGINT or SIGTERM, so serving stops cleanly. The returned
+// function stops listening for the signals.
+func closeOnSignal(c io.Closer) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"nitro/attest/attestation"
+	"nitro/attest/transparency"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (c *cli) tlogCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tlog",
+		Short: "Sign, prove and check the transparency log of accepted documents",
+		Long: "Work with a transparency log of accepted attestation documents, which verify " +
+			"--tlog appends to. The log signs tree heads committing to its entries and proves " +
+			"that entries are included in, and earlier tree heads consistent with, a tree head.",
+	}
+	cmd.AddCommand(c.tlogSTHCommand(), c.tlogGetCommand(), c.tlogProveCommand(), c.tlogCheckCommand())
+	return cmd
+}
+
+func (c *cli) tlogSTHCommand() *cobra.Command {
+	var keyFile string
+	cmd := &cobra.Command{
+		Use:   "sth DB",
+		Short: "Sign the current tree head",
+		Long: "Sign the tree of all entries in the log and print the signed tree head as JSON. " +
+			"The log is bound to the first key that signs for it.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := readSigningKey(keyFile)
+			if err != nil {
+				return err
+			}
+			l, err := openTlog(args[0], key)
+			if err != nil {
+				return err
+			}
+			defer l.Close()
+			sth, err := l.SignedTreeHead()
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			return c.printJSON(sth)
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "key", "", "PEM ECDSA or Ed25519 private key of the log")
+	cmd.MarkFlagRequired("key")
+	return cmd
+}
+
+func (c *cli) tlogGetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get DB INDEX",
+		Short: "Print a log entry",
+		Long:  "Print the document of a log entry in base64, or with -o json the whole entry.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			index, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid index %q", args[1])
+			}
+			l, err := openTlog(args[0], nil)
+			if err != nil {
+				return err
+			}
+			defer l.Close()
+			leaf, err := l.Leaf(index)
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			e, err := transparency.ParseEntry(leaf)
+			if err != nil {
+				return withCode(exitFailure, err)
+			}
+			if c.output == "json" {
+				return c.printJSON(struct {
+					Index    uint64 `json:"index"`
+					LeafHash string `json:"leaf_hash"`
+					*transparency.Entry
+				}{index, hex.EncodeToString(transparency.LeafHash(leaf)), e})
+			}
+			fmt.Fprintln(c.stdout, base64.StdEncoding.EncodeToString(e.Document))
+			return nil
+		},
+	}
+}
+
+func (c *cli) tlogProveCommand() *cobra.Command {
+	var index, from, size int64
+	cmd := &cobra.Command{
+		Use:   "prove DB",
+		Short: "Prove an entry is included in, or an earlier tree extended by, a tree",
+		Long: "Print as JSON a proof that the entry at --index is included in the tree of the " +
+			"first --size entries, or that this tree extends the tree of the first --from entries.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (index < 0) == (from < 0) {
+				return errors.New("exactly one of --index and --from is required")
+			}
+			l, err := openTlog(args[0], nil)
+			if err != nil {
+				return err
+			}
+			defer l.Close()
+			n := uint64(size)
+			if size < 0 {
+				if n, err = l.Size(); err != nil {
+					return withCode(exitFailure, err)
+				}
+			}
+			var proof interface{}
+			if index >= 0 {
+				proof, err = l.InclusionProof(uint64(index), n)
+			} else {
+				proof, err = l.ConsistencyProof(uint64(from), n)
+			}
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			return c.printJSON(proof)
+		},
+	}
+	cmd.Flags().Int64Var(&index, "index", -1, "index of the entry to prove included")
+	cmd.Flags().Int64Var(&from, "from", -1, "size of the earlier tree to prove extended")
+	cmd.Flags().Int64Var(&size, "size", -1, "size of the tree (default: all entries)")
+	return cmd
+}
+
+func (c *cli) tlogCheckCommand() *cobra.Command {
+	var keyFile, inclusionFile, consistencyFile, fromFile string
+	cmd := &cobra.Command{
+		Use:   "check STH",
+		Short: "Check a signed tree head and proofs against it",
+		Long: "Check the signature of a signed tree head and, if given, that an inclusion proof " +
+			"shows its entry in the tree, or that a consistency proof shows the tree extends " +
+			"the one of the tree head in --from. Needs only the log's public key, not the log.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (consistencyFile == "") != (fromFile == "") {
+				return errors.New("--consistency and --from go together")
+			}
+			der, err := readPublicKey(keyFile)
+			if err != nil {
+				return err
+			}
+			pub, _, err := attestation.ParsePublicKey(der)
+			if err != nil {
+				return withCode(exitInput, err)
+			}
+			var sth transparency.SignedTreeHead
+			if err := readJSON(args[0], &sth); err != nil {
+				return err
+			}
+			if err := transparency.VerifyTreeHead(pub, &sth); err != nil {
+				return withCode(exitRejected, errors.Wrap(err, args[0]))
+			}
+			var checked []string
+			if inclusionFile != "" {
+				entry, err := checkInclusion(inclusionFile, &sth)
+				if err != nil {
+					return err
+				}
+				checked = append(checked, entry)
+			}
+			if consistencyFile != "" {
+				tree, err := checkConsistency(consistencyFile, fromFile, pub, &sth)
+				if err != nil {
+					return err
+				}
+				checked = append(checked, tree)
+			}
+			if c.output == "json" {
+				return c.printJSON(struct {
+					TreeSize  uint64    `json:"tree_size"`
+					Timestamp time.Time `json:"timestamp"`
+					Checked   []string  `json:"checked"`
+				}{sth.TreeSize, sth.Timestamp, append([]string{"signature"}, checked...)})
+			}
+			fmt.Fprintf(c.stdout, "verified tree head of size %d signed at %s\n", sth.TreeSize, sth.Timestamp.Format(time.RFC3339))
+			for _, line := range checked {
+				fmt.Fprintln(c.stdout, line)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&keyFile, "public-key", "", "PEM or DER public key of the log")
+	cmd.Flags().StringVar(&inclusionFile, "inclusion", "", "inclusion proof file, as printed by prove --index")
+	cmd.Flags().StringVar(&consistencyFile, "consistency", "", "consistency proof file, as printed by prove --from")
+	cmd.Flags().StringVar(&fromFile, "from", "", "earlier signed tree head the consistency proof starts from")
+	cmd.MarkFlagRequired("public-key")
+	return cmd
+}
+
+// checkInclusion checks the inclusion proof in the named file against sth and describes
+// the included entry.
+func checkInclusion(name string, sth *transparency.SignedTreeHead) (string, error) {
+	var p transparency.InclusionProof
+	if err := readJSON(name, &p); err != nil {
+		return "", err
+	}
+	if err := p.Verify(sth); err != nil {
+		return "", withCode(exitRejected, errors.Wrap(err, name))
+	}
+	e, err := transparency.ParseEntry(p.Leaf)
+	if err != nil {
+		return "", withCode(exitInput, errors.Wrap(err, name))
+	}
+	line := fmt.Sprintf("entry %d is included: document %s", p.LeafIndex, hex.EncodeToString(attestation.DocumentHash(e.Document)))
+	if doc, err := attestation.ParseDocument(e.Document); err == nil {
+		line += " from " + doc.ModuleID
+	}
+	return line, nil
+}
+
+// checkConsistency checks that sth extends the tree head in the file named from, using
+// the consistency proof in the named file.
+func checkConsistency(name, from string, pub crypto.PublicKey, sth *transparency.SignedTreeHead) (string, error) {
+	var first transparency.SignedTreeHead
+	if err := readJSON(from, &first); err != nil {
+		return "", err
+	}
+	if err := transparency.VerifyTreeHead(pub, &first); err != nil {
+		return "", withCode(exitRejected, errors.Wrap(err, from))
+	}
+	var p transparency.ConsistencyProof
+	if err := readJSON(name, &p); err != nil {
+		return "", err
+	}
+	if err := p.Verify(&first, sth); err != nil {
+		return "", withCode(exitRejected, errors.Wrap(err, name))
+	}
+	return fmt.Sprintf("tree extends the tree of size %d", first.TreeSize), nil
+}
+
+// openTlog opens an existing transparency log.
+func openTlog(name string, signer crypto.Signer) (*transparency.Log, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, withCode(exitInput, err)
+	}
+	l, err := transparency.Open(name, signer, transparency.Options{Timeout: auditTimeout})
+	return l, withCode(exitInput, err)
+}
+
+// appendTlog logs an accepted document in the named transparency log.
+func appendTlog(name string, doc []byte, opts attestation.VerifyOptions) error {
+	l, err := transparency.Open(name, nil, transparency.Options{Timeout: auditTimeout})
+	if err != nil {
+		return withCode(exitFailure, err)
+	}
+	defer l.Close()
+	e := transparency.Entry{Document: doc}
+	if opts.Policy != nil {
+		e.PolicyID = opts.Policy.ID()
+	}
+	_, err = l.Append(e)
+	return withCode(exitFailure, err)
+}
+
+// readJSON decodes the named JSON file into v.
+func readJSON(name string, v interface{}) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return withCode(exitInput, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return withCode(exitInput, errors.Wrap(err, name))
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+// Package eif reads Enclave Image Files, the images nitro-cli builds and boots, and
+// computes the PCRs an enclave booted from one reports, the same values nitro-cli
+// build-enclave and describe-eif print:
+//
+//	PCR0  the kernel, command line and every ramdisk
+//	PCR1  the kernel, command line and the first ramdisk, which holds the bootstrap
+//	PCR2  the remaining ramdisks, which hold the application
+//	PCR8  the signing certificate, for signed images only
+//
+// Each is the PCR a fresh NSM would hold after one extension with the SHA-384 digest of
+// its content. Images are read as a stream, so large ramdisks never sit in memory.
+//
+// Sign adds the signature nitro-cli makes with --private-key and --signing-certificate,
+// so a policy can pin PCR8, and with it the signer, instead of every image's PCR0.
+package eif
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"nitro/attest/attestation"
+	"os"
+)
+
+// MaxSections is the number of sections an EIF header has room for.
+const MaxSections = 32
+
+// Sizes of the encoded headers.
+const (
+	HeaderSize        = 548
+	SectionHeaderSize = 12
+)
+
+// maxSmallSection bounds the signature and metadata sections, which are read into memory.
+const maxSmallSection = 1 << 20
+
+// Magic starts every EIF.
+var Magic = [4]byte{'.', 'e', 'i', 'f'}
+
+// SectionType identifies the content of a section.
+type SectionType uint16
+
+// Section types.
+const (
+	SectionInvalid SectionType = iota
+	SectionKernel
+	SectionCmdline
+	SectionRamdisk
+	SectionSignature
+	SectionMetadata
+)
+
+var sectionNames = map[SectionType]string{
+	SectionInvalid:   "invalid",
+	SectionKernel:    "kernel",
+	SectionCmdline:   "cmdline",
+	SectionRamdisk:   "ramdisk",
+	SectionSignature: "signature",
+	SectionMetadata:  "metadata",
+}
+
+func (t SectionType) String() string {
+	if name, ok := sectionNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("section type %d", uint16(t))
+}
+
+// Header is the big-endian header at the start of an EIF.
+type Header struct {
+	Magic          [4]byte
+	Version        uint16
+	Flags          uint16
+	DefaultMemory  uint64
+	DefaultCPUs    uint64
+	_              uint16
+	SectionCount   uint16
+	SectionOffsets [MaxSections]uint64
+	SectionSizes   [MaxSections]uint64
+	_              uint32
+	// CRC32 is the IEEE CRC-32 of the header up to this field and of every section,
+	// header and data, that follows.
+	CRC32 uint32
+}
+
+// SectionHeader precedes the data of each section.
+type SectionHeader struct {
+	Type  SectionType
+	Flags uint16
+	Size  uint64
+}
+
+// PCRSignature is an entry of the signature section: a COSE_Sign1 signature over a PCR,
+// made with the key of a PEM signing certificate.
+type PCRSignature struct {
+	SigningCertificate Bytes `cbor:"signing_certificate" json:"signing_certificate"`
+	Signature          Bytes `cbor:"signature" json:"signature"`
+}
+
+// Bytes is a byte string that nitro-cli encodes as a CBOR array of integers, the way
+// serde encodes a Vec<u8>. Byte strings decode too.
+type Bytes []byte
+
+// MarshalCBOR encodes b as an array of integers.
+func (b Bytes) MarshalCBOR() ([]byte, error) {
+	ints := make([]uint, len(b))
+	for i, c := range b {
+		ints[i] = uint(c)
+	}
+	return cbor.Marshal(ints)
+}
+
+// UnmarshalCBOR decodes an array of integers or a byte string.
+func (b *Bytes) UnmarshalCBOR(data []byte) error {
+	var s []byte
+	if err := cbor.Unmarshal(data, &s); err == nil {
+		*b = s
+		return nil
+	}
+	var ints []uint
+	if err := cbor.Unmarshal(data, &ints); err != nil {
+		return err
+	}
+	s = make([]byte, len(ints))
+	for i, c := range ints {
+		if c > 0xff {
+			return errors.New("byte array element out of range")
+		}
+		s[i] = byte(c)
+	}
+	*b = s
+	return nil
+}
+
+// Image describes a parsed EIF.
+type Image struct {
+	Header   Header
+	Sections []SectionHeader
+	// PCRs holds PCR0, PCR1 and PCR2, and PCR8 if the image is signed.
+	PCRs map[uint]attestation.Measurement
+	// Signatures is the decoded signature section, nil if the image is unsigned.
+	Signatures []PCRSignature
+	// SigningCertificate is the certificate of the first signature, nil if the image is
+	// unsigned.
+	SigningCertificate *x509.Certificate
+	// Metadata is the JSON metadata section, nil if the image has none.
+	Metadata json.RawMessage
+}
+
+// Policy returns a policy requiring the image's PCRs.
+// Pre: Parameter indices selects PCRs of img.PCRs; none selects all of them.
+// Post: The policy or an error naming a PCR the image does not determine is returned.
+func (img *Image) Policy(indices ...uint) (*attestation.Policy, error) {
+	policy := &attestation.Policy{PCRs: map[uint]attestation.Measurement{}}
+	if len(indices) == 0 {
+		for index, value := range img.PCRs {
+			policy.PCRs[index] = value
+		}
+		return policy, nil
+	}
+	for _, index := range indices {
+		value, ok := img.PCRs[index]
+		if !ok {
+			return nil, fmt.Errorf("the image does not determine PCR%d", index)
+		}
+		policy.PCRs[index] = value
+	}
+	return policy, nil
+}
+
+// Open reads the EIF in the named file.
+// Pre: Parameter name is the path of an EIF.
+// Post: The parsed image or an error is returned.
+func Open(name string) (*Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(bufio.NewReaderSize(f, 1<<20))
+}
+
+// Read parses an EIF and measures it.
+// Pre: Parameter r yields the EIF from its first byte.
+// Post: The parsed image or an error is returned. The checksum of the whole image is
+// verified.
+func Read(r io.Reader) (*Image, error) {
+	crc := crc32.NewIEEE()
+	raw := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, errors.Wrap(err, "could not read eif header")
+	}
+	img := &Image{}
+	binary.Read(bytes.NewReader(raw), binary.BigEndian, &img.Header)
+	if img.Header.Magic != Magic {
+		return nil, errors.New("not an eif: bad magic")
+	}
+	if img.Header.SectionCount > MaxSections {
+		return nil, fmt.Errorf("eif has %d sections, at most %d are allowed", img.Header.SectionCount, MaxSections)
+	}
+	crc.Write(raw[:HeaderSize-4])
+
+	image, bootstrap, app, cert := newMeasurer(), newMeasurer(), newMeasurer(), newMeasurer()
+	pos := uint64(HeaderSize)
+	ramdisks := 0
+	for i := 0; i < int(img.Header.SectionCount); i++ {
+		offset := img.Header.SectionOffsets[i]
+		if offset < pos {
+			return nil, fmt.Errorf("eif section %d overlaps the previous one", i)
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(offset-pos)); err != nil {
+			return nil, errors.Wrapf(err, "could not read eif section %d", i)
+		}
+		raw := make([]byte, SectionHeaderSize)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, errors.Wrapf(err, "could not read eif section %d", i)
+		}
+		crc.Write(raw)
+		var section SectionHeader
+		binary.Read(bytes.NewReader(raw), binary.BigEndian, &section)
+		img.Sections = append(img.Sections, section)
+		if section.Size > 1<<40 {
+			return nil, fmt.Errorf("eif section %d is implausibly large", i)
+		}
+
+		var sinks []io.Writer
+		var small bytes.Buffer
+		switch section.Type {
+		case SectionKernel, SectionCmdline:
+			sinks = []io.Writer{image, bootstrap}
+		case SectionRamdisk:
+			if ramdisks == 0 {
+				sinks = []io.Writer{image, bootstrap}
+			} else {
+				sinks = []io.Writer{image, app}
+			}
+			ramdisks++
+		case SectionSignature, SectionMetadata:
+			if section.Size > maxSmallSection {
+				return nil, fmt.Errorf("eif %s section is too large", section.Type)
+			}
+			sinks = []io.Writer{&small}
+		}
+		if _, err := io.CopyN(io.MultiWriter(append(sinks, crc)...), r, int64(section.Size)); err != nil {
+			return nil, errors.Wrapf(err, "could not read eif %s section", section.Type)
+		}
+		pos = offset + SectionHeaderSize + section.Size
+
+		switch section.Type {
+		case SectionSignature:
+			if img.Signatures != nil {
+				return nil, errors.New("eif has more than one signature section")
+			}
+			if err := img.readSignatures(small.Bytes()); err != nil {
+				return nil, err
+			}
+			cert.Write(img.SigningCertificate.Raw)
+		case SectionMetadata:
+			img.Metadata = small.Bytes()
+		}
+	}
+	if crc.Sum32() != img.Header.CRC32 {
+		return nil, errors.New("eif checksum mismatch")
+	}
+	img.PCRs = map[uint]attestation.Measurement{
+		0: image.Sum(),
+		1: bootstrap.Sum(),
+		2: app.Sum(),
+	}
+	if img.SigningCertificate != nil {
+		img.PCRs[8] = cert.Sum()
+	}
+	return img, nil
+}
+
+// readSignatures decodes the signature section and its first signing certificate.
+func (img *Image) readSignatures(section []byte) error {
+	if err := cbor.Unmarshal(section, &img.Signatures); err != nil {
+		return errors.Wrap(err, "malformed eif signature section")
+	}
+	if len(img.Signatures) == 0 {
+		return errors.New("eif signature section is empty")
+	}
+	cert, err := ParseCertificate(img.Signatures[0].SigningCertificate)
+	if err != nil {
+		return err
+	}
+	img.SigningCertificate = cert
+	return nil
+}
+
+// ParseCertificate decodes a signing certificate, which EIFs store as PEM.
+// Pre: Parameter b is a PEM or DER certificate.
+// Post: The certificate or an error is returned.
+func ParseCertificate(b []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed eif signing certificate")
+	}
+	return cert, nil
+}
+
+// Measure returns the PCR a fresh NSM holds after it is extended with the SHA-384 digest
+// of content, which is how PCRs are derived from an image.
+func Measure(content []byte) attestation.Measurement {
+	m := newMeasurer()
+	m.Write(content)
+	return m.Sum()
+}
+
+// measurer accumulates the content of one PCR.
+type measurer struct {
+	hash.Hash
+}
+
+func newMeasurer() *measurer {
+	return &measurer{sha512.New384()}
+}
+
+// Sum extends an all-zero PCR with the digest of the content written so far.
+func (m *measurer) Sum() attestation.Measurement {
+	pcr := sha512.New384()
+	pcr.Write(make([]byte, sha512.Size384))
+	pcr.Write(m.Hash.Sum(nil))
+	return pcr.Sum(nil)
+}
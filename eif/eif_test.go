@@ -0,0 +1,260 @@
+package eif
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io/ioutil"
+	"math/big"
+	"nitro/attest/attestation"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSection struct {
+	typ  SectionType
+	data []byte
+}
+
+// buildEIF lays out sections the way nitro-cli does.
+func buildEIF(t *testing.T, sections ...testSection) []byte {
+	h := Header{Magic: Magic, Version: 4, DefaultMemory: 512 << 20, DefaultCPUs: 2, SectionCount: uint16(len(sections))}
+	var body bytes.Buffer
+	offset := uint64(HeaderSize)
+	for i, s := range sections {
+		h.SectionOffsets[i] = offset
+		h.SectionSizes[i] = uint64(len(s.data))
+		require.NoError(t, binary.Write(&body, binary.BigEndian, SectionHeader{Type: s.typ, Size: uint64(len(s.data))}))
+		body.Write(s.data)
+		offset += SectionHeaderSize + uint64(len(s.data))
+	}
+	var header bytes.Buffer
+	require.NoError(t, binary.Write(&header, binary.BigEndian, h))
+	crc := crc32.NewIEEE()
+	crc.Write(header.Bytes()[:HeaderSize-4])
+	crc.Write(body.Bytes())
+	h.CRC32 = crc.Sum32()
+	header.Reset()
+	require.NoError(t, binary.Write(&header, binary.BigEndian, h))
+	return append(header.Bytes(), body.Bytes()...)
+}
+
+// pcr computes a PCR independently of the package: the SHA-384 of 48 zero bytes followed
+// by the SHA-384 of the content.
+func pcr(content ...[]byte) attestation.Measurement {
+	digest := sha512.Sum384(bytes.Join(content, nil))
+	extended := sha512.Sum384(append(make([]byte, sha512.Size384), digest[:]...))
+	return extended[:]
+}
+
+func selfSigned(t *testing.T, curve elliptic.Curve) (*ecdsa.PrivateKey, []byte, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "enclave signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert
+}
+
+func TestRead(t *testing.T) {
+	kernel := bytes.Repeat([]byte("kernel"), 10000)
+	cmdline := []byte("reboot=k panic=30 pci=off nomodules console=ttyS0")
+	bootstrap := bytes.Repeat([]byte("init"), 5000)
+	app := bytes.Repeat([]byte("app"), 7000)
+	customer := []byte("more app")
+	metadata := []byte(`{"ImageName":"hello","ImageVersion":"1.0"}`)
+	unsigned := []testSection{
+		{SectionKernel, kernel},
+		{SectionCmdline, cmdline},
+		{SectionMetadata, metadata},
+		{SectionRamdisk, bootstrap},
+		{SectionRamdisk, app},
+		{SectionRamdisk, customer},
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		img, err := Read(bytes.NewReader(buildEIF(t, unsigned...)))
+		require.NoError(t, err)
+		require.Equal(t, uint16(4), img.Header.Version)
+		require.Equal(t, uint64(2), img.Header.DefaultCPUs)
+		require.Len(t, img.Sections, 6)
+		require.Equal(t, SectionRamdisk, img.Sections[5].Type)
+		require.Equal(t, map[uint]attestation.Measurement{
+			0: pcr(kernel, cmdline, bootstrap, app, customer),
+			1: pcr(kernel, cmdline, bootstrap),
+			2: pcr(app, customer),
+		}, img.PCRs)
+		require.JSONEq(t, string(metadata), string(img.Metadata))
+		require.Nil(t, img.SigningCertificate)
+		require.Equal(t, pcr(kernel), Measure(kernel))
+
+		policy, err := img.Policy()
+		require.NoError(t, err)
+		require.Equal(t, img.PCRs, policy.PCRs)
+		policy, err = img.Policy(0)
+		require.NoError(t, err)
+		require.Equal(t, []uint{0}, policy.Indices())
+		_, err = img.Policy(8)
+		require.EqualError(t, err, "the image does not determine PCR8")
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		_, certPEM, cert := selfSigned(t, elliptic.P384())
+		// nitro-cli writes byte strings as arrays of integers; both forms must parse.
+		for _, section := range []interface{}{
+			[]PCRSignature{{SigningCertificate: certPEM, Signature: []byte{1, 2, 3}}},
+			[]map[string][]byte{{"signing_certificate": certPEM, "signature": {1, 2, 3}}},
+		} {
+			encoded, err := cbor.Marshal(section)
+			require.NoError(t, err)
+			img, err := Read(bytes.NewReader(buildEIF(t, append(unsigned, testSection{SectionSignature, encoded})...)))
+			require.NoError(t, err)
+			require.Equal(t, cert.Raw, img.SigningCertificate.Raw)
+			require.Equal(t, pcr(cert.Raw), img.PCRs[8])
+			require.Equal(t, Bytes{1, 2, 3}, img.Signatures[0].Signature)
+			require.Equal(t, pcr(kernel, cmdline, bootstrap, app, customer), img.PCRs[0], "the signature is not measured")
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "enclave.eif")
+		require.NoError(t, os.WriteFile(name, buildEIF(t, unsigned...), 0644))
+		img, err := Open(name)
+		require.NoError(t, err)
+		require.Len(t, img.PCRs, 3)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		good := buildEIF(t, unsigned...)
+
+		_, err := Read(bytes.NewReader(good[:100]))
+		require.EqualError(t, err, "could not read eif header: unexpected EOF")
+
+		bad := append([]byte(nil), good...)
+		copy(bad, "ELF\x7f")
+		_, err = Read(bytes.NewReader(bad))
+		require.EqualError(t, err, "not an eif: bad magic")
+
+		bad = append([]byte(nil), good...)
+		bad[len(bad)-1] ^= 1
+		_, err = Read(bytes.NewReader(bad))
+		require.EqualError(t, err, "eif checksum mismatch")
+
+		_, err = Read(bytes.NewReader(good[:len(good)-1]))
+		require.EqualError(t, err, "could not read eif ramdisk section: EOF")
+
+		_, err = Read(bytes.NewReader(buildEIF(t, testSection{SectionSignature, []byte("junk")})))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "malformed eif signature section")
+	})
+}
+
+func TestSign(t *testing.T) {
+	kernel, cmdline, bootstrap, app := []byte("kernel"), []byte("console=ttyS0"), []byte("init"), []byte("app")
+	unsigned := buildEIF(t,
+		testSection{SectionKernel, kernel},
+		testSection{SectionCmdline, cmdline},
+		testSection{SectionRamdisk, bootstrap},
+		testSection{SectionRamdisk, app},
+	)
+	plain, err := Read(bytes.NewReader(unsigned))
+	require.NoError(t, err)
+
+	sign := func(t *testing.T, image []byte, key *ecdsa.PrivateKey, cert *x509.Certificate) (*Image, []byte) {
+		var signed bytes.Buffer
+		pcr8, err := Sign(&signed, bytes.NewReader(image), key, cert)
+		require.NoError(t, err)
+		require.Equal(t, pcr(cert.Raw), pcr8)
+		img, err := Read(bytes.NewReader(signed.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, pcr8, img.PCRs[8])
+		return img, signed.Bytes()
+	}
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			key, _, cert := selfSigned(t, curve)
+			img, _ := sign(t, unsigned, key, cert)
+			require.NoError(t, img.VerifySignature())
+			for _, index := range []uint{0, 1, 2} {
+				require.Equal(t, plain.PCRs[index], img.PCRs[index])
+			}
+			require.Equal(t, SectionSignature, img.Sections[len(img.Sections)-1].Type)
+			require.Equal(t, cert.Raw, img.SigningCertificate.Raw)
+
+			policy, err := img.Policy(8)
+			require.NoError(t, err)
+			require.Equal(t, PCR8(cert), policy.PCRs[8])
+		})
+	}
+
+	t.Run("re-signing replaces the signature", func(t *testing.T) {
+		key, _, cert := selfSigned(t, elliptic.P384())
+		_, signed := sign(t, unsigned, key, cert)
+		otherKey, _, other := selfSigned(t, elliptic.P384())
+		img, _ := sign(t, signed, otherKey, other)
+		require.Len(t, img.Sections, 5)
+		require.Len(t, img.Signatures, 1)
+		require.Equal(t, PCR8(other), img.PCRs[8])
+		require.NoError(t, img.VerifySignature())
+	})
+
+	t.Run("tampering", func(t *testing.T) {
+		key, _, cert := selfSigned(t, elliptic.P384())
+		img, _ := sign(t, unsigned, key, cert)
+		section, err := cbor.Marshal(img.Signatures)
+		require.NoError(t, err)
+		tampered, err := Read(bytes.NewReader(buildEIF(t,
+			testSection{SectionKernel, []byte("evil kernel")},
+			testSection{SectionCmdline, cmdline},
+			testSection{SectionRamdisk, bootstrap},
+			testSection{SectionRamdisk, app},
+			testSection{SectionSignature, section},
+		)))
+		require.NoError(t, err)
+		require.Equal(t, img.PCRs[8], tampered.PCRs[8], "PCR8 alone does not vouch for the image")
+		require.EqualError(t, tampered.VerifySignature(), "eif signature does not cover this image's PCR0")
+
+		var msg coseSign1
+		require.NoError(t, cbor.Unmarshal(img.Signatures[0].Signature, &msg))
+		msg.Signature[0] ^= 1
+		img.Signatures[0].Signature, err = cbor.Marshal(msg)
+		require.NoError(t, err)
+		require.EqualError(t, img.VerifySignature(), "eif signature is invalid")
+		require.EqualError(t, plain.VerifySignature(), "eif is not signed")
+	})
+
+	t.Run("unsuitable keys", func(t *testing.T) {
+		key, _, cert := selfSigned(t, elliptic.P384())
+		otherKey, _, _ := selfSigned(t, elliptic.P384())
+		_, err := Sign(ioutil.Discard, bytes.NewReader(unsigned), otherKey, cert)
+		require.EqualError(t, err, "eif signing key does not match the certificate")
+
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		_, err = Sign(ioutil.Discard, bytes.NewReader(unsigned), edKey, cert)
+		require.EqualError(t, err, "eif signing key must be an ECDSA key")
+
+		_, err = Sign(ioutil.Discard, bytes.NewReader([]byte("not an image")), key, cert)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,250 @@
+package eif
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"nitro/attest/attestation"
+)
+
+// COSE algorithm identifiers of the ECDSA curves nitro-cli signs with.
+const (
+	algES256 = -7
+	algES384 = -35
+	algES512 = -36
+)
+
+// pcrInfo is the payload of a PCR signature.
+type pcrInfo struct {
+	RegisterIndex int   `cbor:"register_index"`
+	RegisterValue Bytes `cbor:"register_value"`
+}
+
+// coseSign1 is an untagged COSE_Sign1 structure.
+type coseSign1 struct {
+	_ struct{} `cbor:",toarray"`
+
+	Protected   []byte
+	Unprotected cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+// PCR8 returns the PCR8 of images signed with cert.
+func PCR8(cert *x509.Certificate) attestation.Measurement {
+	return Measure(cert.Raw)
+}
+
+// Sign signs the image read from src and writes the signed image to dst. The signature
+// covers PCR0 and is made the way nitro-cli build-enclave --private-key does, so the
+// signed image boots with PCR8 set to PCR8(cert). An existing signature is replaced.
+// Pre: Parameter src is positioned anywhere in an EIF. Parameter key is an ECDSA P-256,
+// P-384 or P-521 key matching cert.
+// Post: The image's PCR8 is returned, or an error.
+func Sign(dst io.Writer, src io.ReadSeeker, key crypto.Signer, cert *x509.Certificate) (attestation.Measurement, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, err := Read(src)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signPCR(0, img.PCRs[0], key, cert)
+	if err != nil {
+		return nil, err
+	}
+	section, err := cbor.Marshal([]PCRSignature{{
+		SigningCertificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		Signature:          signature,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Lay out the unsigned sections again, followed by the new signature.
+	header := img.Header
+	header.SectionOffsets, header.SectionSizes = [MaxSections]uint64{}, [MaxSections]uint64{}
+	var kept []int
+	for i, s := range img.Sections {
+		if s.Type != SectionSignature {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) == MaxSections {
+		return nil, errors.New("eif has no room for a signature section")
+	}
+	header.SectionCount = uint16(len(kept) + 1)
+	offset := uint64(HeaderSize)
+	for n, i := range append(kept, -1) {
+		size := uint64(len(section))
+		if i >= 0 {
+			size = img.Sections[i].Size
+		}
+		header.SectionOffsets[n], header.SectionSizes[n] = offset, size
+		offset += SectionHeaderSize + size
+	}
+	writeSections := func(w io.Writer) error {
+		for _, i := range kept {
+			if err := binary.Write(w, binary.BigEndian, img.Sections[i]); err != nil {
+				return err
+			}
+			if _, err := src.Seek(int64(img.Header.SectionOffsets[i]+SectionHeaderSize), io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, src, int64(img.Sections[i].Size)); err != nil {
+				return errors.Wrapf(err, "could not copy eif %s section", img.Sections[i].Type)
+			}
+		}
+		if err := binary.Write(w, binary.BigEndian, SectionHeader{Type: SectionSignature, Size: uint64(len(section))}); err != nil {
+			return err
+		}
+		_, err := w.Write(section)
+		return err
+	}
+
+	var encoded bytes.Buffer
+	binary.Write(&encoded, binary.BigEndian, header)
+	crc := crc32.NewIEEE()
+	crc.Write(encoded.Bytes()[:HeaderSize-4])
+	if err := writeSections(crc); err != nil {
+		return nil, err
+	}
+	header.CRC32 = crc.Sum32()
+	encoded.Reset()
+	binary.Write(&encoded, binary.BigEndian, header)
+	if _, err := dst.Write(encoded.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writeSections(dst); err != nil {
+		return nil, err
+	}
+	return PCR8(cert), nil
+}
+
+// VerifySignature checks that the image's first signature is a valid signature of its
+// PCR0 by the key of its signing certificate. It does not judge whether the certificate
+// is trusted; pin PCR8 for that.
+// Pre: None.
+// Post: Nil is returned if the signature is valid, otherwise an error.
+func (img *Image) VerifySignature() error {
+	if img.SigningCertificate == nil {
+		return errors.New("eif is not signed")
+	}
+	var msg coseSign1
+	if err := cbor.Unmarshal(img.Signatures[0].Signature, &msg); err != nil {
+		return errors.Wrap(err, "malformed eif signature")
+	}
+	var info pcrInfo
+	if err := cbor.Unmarshal(msg.Payload, &info); err != nil {
+		return errors.Wrap(err, "malformed eif signature payload")
+	}
+	if info.RegisterIndex != 0 || !bytes.Equal(info.RegisterValue, img.PCRs[0]) {
+		return errors.New("eif signature does not cover this image's PCR0")
+	}
+	pub, ok := img.SigningCertificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("eif signing certificate does not hold an ECDSA key")
+	}
+	alg, hash, err := coseAlgorithm(pub)
+	if err != nil {
+		return err
+	}
+	var protected map[int]int
+	if err := cbor.Unmarshal(msg.Protected, &protected); err != nil || protected[1] != alg {
+		return errors.New("eif signature algorithm does not match the signing certificate")
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(msg.Signature) != 2*size {
+		return errors.New("eif signature is invalid")
+	}
+	digest, err := sigDigest(hash, msg.Protected, msg.Payload)
+	if err != nil {
+		return err
+	}
+	r, s := new(big.Int).SetBytes(msg.Signature[:size]), new(big.Int).SetBytes(msg.Signature[size:])
+	if !ecdsa.Verify(pub, digest, r, s) {
+		return errors.New("eif signature is invalid")
+	}
+	return nil
+}
+
+// signPCR makes the COSE_Sign1 signature of a PCR value.
+func signPCR(index int, value []byte, key crypto.Signer, cert *x509.Certificate) ([]byte, error) {
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("eif signing key must be an ECDSA key")
+	}
+	if certPub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !certPub.Equal(pub) {
+		return nil, errors.New("eif signing key does not match the certificate")
+	}
+	alg, hash, err := coseAlgorithm(pub)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := cbor.Marshal(map[int]int{1: alg})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := cbor.Marshal(pcrInfo{RegisterIndex: index, RegisterValue: value})
+	if err != nil {
+		return nil, err
+	}
+	digest, err := sigDigest(hash, protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	der, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign eif")
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, errors.Wrap(err, "could not sign eif")
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return cbor.Marshal(coseSign1{
+		Protected:   protected,
+		Unprotected: cbor.RawMessage{0xa0},
+		Payload:     payload,
+		Signature:   raw,
+	})
+}
+
+// coseAlgorithm returns the COSE algorithm and hash for an ECDSA key.
+func coseAlgorithm(pub *ecdsa.PublicKey) (int, crypto.Hash, error) {
+	switch pub.Curve {
+	case elliptic.P256():
+		return algES256, crypto.SHA256, nil
+	case elliptic.P384():
+		return algES384, crypto.SHA384, nil
+	case elliptic.P521():
+		return algES512, crypto.SHA512, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported eif signing curve %s", pub.Curve.Params().Name)
+}
+
+// sigDigest hashes the COSE Sig_structure of a COSE_Sign1 message without external data.
+func sigDigest(hash crypto.Hash, protected, payload []byte) ([]byte, error) {
+	structure, err := cbor.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(structure)
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,155 @@
+// Package extauthz is an Envoy external authorization server that admits only requests
+// backed by a valid enclave attestation, as verified by an authn.Authenticator. Allowed
+// requests are forwarded with x-enclave-* headers describing the verified enclave; any
+// x-enclave-* headers sent by the client are removed.
+//
+// Envoy does not pass the downstream TLS session to the authorization server, so
+// attestation documents cannot be bound to the caller's connection (see
+// authn.ChannelBinding). Instead, a document is accepted if it attests the key of the
+// client certificate the caller presented, as RA-TLS certificates do (see package
+// ratls); see authn.Authenticator.AuthenticatePeer. For this, the listener must request
+// client certificates, for example with trust_chain_verification ACCEPT_UNTRUSTED since
+// the document rather than a CA vouches for them, and the ext_authz filter must set
+// include_peer_certificate. Otherwise callers present attestation tokens.
+//
+// Register a Server with authv3.RegisterAuthorizationServer and point Envoy's ext_authz
+// filter at it with transport_api_version V3.
+package extauthz
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net/url"
+	"nitro/attest/attestation"
+	"nitro/attest/authn"
+	"sort"
+	"strings"
+)
+
+// HeaderPrefix starts every header added to allowed requests.
+const HeaderPrefix = "x-enclave-"
+
+// Server implements authv3.AuthorizationServer by delegating each check to an
+// authn.Authenticator, which Envoy's concurrent checks share.
+type Server struct {
+	// Authenticator verifies the attestation carried by each request.
+	Authenticator *authn.Authenticator
+}
+
+var _ authv3.AuthorizationServer = (*Server)(nil)
+
+// Check decides whether Envoy may forward a request.
+// Pre: Parameter req is Envoy's check request.
+// Post: An OK response with identity headers is returned for attested requests, a denied
+// response otherwise. The error is always nil.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+	cert, err := peerCertificate(req.GetAttributes().GetSource().GetCertificate())
+	if err != nil {
+		return denied(codes.PermissionDenied, typev3.StatusCode_Forbidden, err.Error()), nil
+	}
+	id, err := s.Authenticator.AuthenticatePeer(headers[authn.HeaderDocument], headers[authn.HeaderAuthorization], cert)
+	if err != nil {
+		code, httpCode := codes.PermissionDenied, typev3.StatusCode_Forbidden
+		if err == authn.ErrNoCredentials {
+			code, httpCode = codes.Unauthenticated, typev3.StatusCode_Unauthorized
+		}
+		return denied(code, httpCode, err.Error()), nil
+	}
+	set := identityHeaders(id)
+	ok := &authv3.OkHttpResponse{}
+	for _, name := range sortedKeys(set) {
+		ok.Headers = append(ok.Headers, header(name, set[name]))
+	}
+	for name := range headers {
+		name = strings.ToLower(name)
+		if _, ours := set[name]; strings.HasPrefix(name, HeaderPrefix) && !ours {
+			ok.HeadersToRemove = append(ok.HeadersToRemove, name)
+		}
+	}
+	sort.Strings(ok.HeadersToRemove)
+	if _, present := headers[authn.HeaderDocument]; present {
+		ok.HeadersToRemove = append(ok.HeadersToRemove, authn.HeaderDocument)
+	}
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}, nil
+}
+
+// peerCertificate parses the client certificate Envoy reports with
+// include_peer_certificate: a URL encoded PEM certificate, empty if there is none.
+func peerCertificate(encoded string) (*x509.Certificate, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	// PathUnescape, unlike QueryUnescape, keeps the '+' of the base64 body.
+	text, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil, errors.New("client certificate is not URL encoded")
+	}
+	block, _ := pem.Decode([]byte(text))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("client certificate is not a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "client certificate")
+	}
+	return cert, nil
+}
+
+// identityHeaders returns the x-enclave-* headers describing id. All-zero PCRs, which
+// the enclave never extended, are left out.
+func identityHeaders(id *authn.Identity) map[string]string {
+	h := map[string]string{
+		HeaderPrefix + "module-id": id.ModuleID,
+		HeaderPrefix + "source":    id.Source,
+	}
+	for index, value := range id.PCRs {
+		if !attestation.IsZeroMeasurement(value) {
+			h[fmt.Sprintf("%spcr%d", HeaderPrefix, index)] = hex.EncodeToString(value)
+		}
+	}
+	if id.PublicKeySHA256 != "" {
+		h[HeaderPrefix+"public-key-sha256"] = id.PublicKeySHA256
+	}
+	if id.UserDataSHA256 != "" {
+		h[HeaderPrefix+"user-data-sha256"] = id.UserDataSHA256
+	}
+	return h
+}
+
+func denied(code codes.Code, httpCode typev3.StatusCode, msg string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(code), Message: msg},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: httpCode},
+			Headers: []*corev3.HeaderValueOption{header("content-type", "text/plain")},
+			Body:    msg + "\n",
+		}},
+	}
+}
+
+func header(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: name, Value: value}, Append: wrapperspb.Bool(false)}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,186 @@
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/authn"
+	"nitro/attest/keyring"
+	"nitro/attest/ratls"
+	"nitro/attest/token"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Method: "GET", Path: "/", Headers: headers},
+		},
+	}}
+}
+
+// envoyEncoding URL encodes a PEM certificate the way Envoy does for
+// include_peer_certificate.
+var envoyEncoding = strings.NewReplacer("\n", "%0A", " ", "%20", "+", "%2B", "/", "%2F", "=", "%3D")
+
+// peerRequest is checkRequest for a caller that presented cert.
+func peerRequest(headers map[string]string, cert *x509.Certificate) *authv3.CheckRequest {
+	req := checkRequest(headers)
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	req.Attributes.Source = &authv3.AttributeContext_Peer{Certificate: envoyEncoding.Replace(string(pemCert))}
+	return req
+}
+
+func requireDenied(t *testing.T, res *authv3.CheckResponse, code codes.Code, httpCode typev3.StatusCode, msg string) {
+	require.Equal(t, int32(code), res.Status.Code)
+	require.Contains(t, res.Status.Message, msg)
+	deny := res.GetDeniedResponse()
+	require.NotNil(t, deny)
+	require.Equal(t, httpCode, deny.Status.Code)
+}
+
+func okHeaders(t *testing.T, res *authv3.CheckResponse) map[string]string {
+	require.Equal(t, int32(codes.OK), res.Status.Code, res.Status.Message)
+	ok := res.GetOkResponse()
+	require.NotNil(t, ok)
+	h := map[string]string{}
+	for _, opt := range ok.Headers {
+		require.False(t, opt.Append.GetValue())
+		h[opt.Header.Key] = opt.Header.Value
+	}
+	return h
+}
+
+func TestCheck(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0a}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	opts := attestation.VerifyOptions{
+		Roots:  sim.Roots(),
+		Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr}},
+	}
+	keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+	require.NoError(t, err)
+	issuer, err := token.NewIssuer(keys, token.IssuerOptions{Verify: opts})
+	require.NoError(t, err)
+	auth := &authn.Authenticator{
+		Options:     opts,
+		VerifyToken: authn.TokenVerifier(&token.Verifier{Keys: token.ManagerKeys(keys), PolicyID: opts.Policy.ID()}),
+	}
+	s := &Server{Authenticator: auth}
+	nonce := &attestation.Nonce{Value: []byte("nonce"), Expiration: time.Now().Add(time.Hour)}
+	doc, err := attestation.RetrieveAttestationFrom(sim, nonce.Value, []byte("user"), nil)
+	require.NoError(t, err)
+	tok, _, err := issuer.Issue(doc, nonce)
+	require.NoError(t, err)
+	bearer := "Bearer " + tok
+	peer, err := ratls.NewCertificate(sim, ratls.CertOptions{})
+	require.NoError(t, err)
+	peerDoc, err := ratls.ExtractDocument(peer.Leaf)
+	require.NoError(t, err)
+	encodedPeer := base64.StdEncoding.EncodeToString(peerDoc)
+	ctx := context.Background()
+
+	t.Run("attested token", func(t *testing.T) {
+		res, err := s.Check(ctx, checkRequest(map[string]string{
+			"authorization":       bearer,
+			"x-enclave-pcr9":      "spoofed",
+			"x-enclave-module-id": "spoofed",
+		}))
+		require.NoError(t, err)
+		h := okHeaders(t, res)
+		require.Equal(t, sim.ModuleID, h["x-enclave-module-id"])
+		require.Equal(t, hex.EncodeToString(pcr), h["x-enclave-pcr0"])
+		require.Equal(t, "token", h["x-enclave-source"])
+		require.Len(t, h["x-enclave-user-data-sha256"], 64)
+		require.NotContains(t, h, "x-enclave-public-key-sha256")
+		require.Equal(t, []string{"x-enclave-pcr9"}, res.GetOkResponse().HeadersToRemove)
+	})
+
+	t.Run("no attestation", func(t *testing.T) {
+		res, err := s.Check(ctx, checkRequest(map[string]string{"authorization": "Basic Zm9vOmJhcg=="}))
+		require.NoError(t, err)
+		requireDenied(t, res, codes.Unauthenticated, typev3.StatusCode_Unauthorized, "request carries no attestation")
+	})
+
+	t.Run("attested document", func(t *testing.T) {
+		res, err := s.Check(ctx, peerRequest(map[string]string{
+			authn.HeaderDocument: encodedPeer,
+			"x-enclave-pcr9":     "spoofed",
+		}, peer.Leaf))
+		require.NoError(t, err)
+		h := okHeaders(t, res)
+		require.Equal(t, sim.ModuleID, h["x-enclave-module-id"])
+		require.Equal(t, "document", h["x-enclave-source"])
+		require.Len(t, h["x-enclave-public-key-sha256"], 64)
+		require.Equal(t, []string{"x-enclave-pcr9", authn.HeaderDocument}, res.GetOkResponse().HeadersToRemove)
+	})
+
+	t.Run("unbound documents", func(t *testing.T) {
+		res, err := s.Check(ctx, checkRequest(map[string]string{
+			authn.HeaderDocument: encodedPeer,
+			"authorization":      bearer,
+		}))
+		require.NoError(t, err)
+		requireDenied(t, res, codes.PermissionDenied, typev3.StatusCode_Forbidden, "accepted only with a client certificate")
+
+		other, err := ratls.NewCertificate(sim, ratls.CertOptions{})
+		require.NoError(t, err)
+		res, err = s.Check(ctx, peerRequest(map[string]string{authn.HeaderDocument: encodedPeer}, other.Leaf))
+		require.NoError(t, err)
+		requireDenied(t, res, codes.PermissionDenied, typev3.StatusCode_Forbidden, "not bound to the client certificate")
+
+		req := peerRequest(map[string]string{authn.HeaderDocument: encodedPeer}, peer.Leaf)
+		req.Attributes.Source.Certificate = "garbage"
+		res, err = s.Check(ctx, req)
+		require.NoError(t, err)
+		requireDenied(t, res, codes.PermissionDenied, typev3.StatusCode_Forbidden, "client certificate is not a PEM certificate")
+	})
+
+	t.Run("rejected attestation", func(t *testing.T) {
+		other, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+		require.NoError(t, err)
+		otherKeys, err := keyring.NewManager(other, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+		require.NoError(t, err)
+		forger, err := token.NewIssuer(otherKeys, token.IssuerOptions{Verify: attestation.VerifyOptions{Roots: other.Roots(), Policy: opts.Policy}})
+		require.NoError(t, err)
+		forged, err := attestation.RetrieveAttestationFrom(other, nonce.Value, nil, nil)
+		require.NoError(t, err)
+		forgedToken, _, err := forger.Issue(forged, nonce)
+		require.NoError(t, err)
+		res, err := s.Check(ctx, checkRequest(map[string]string{"authorization": "Bearer " + forgedToken}))
+		require.NoError(t, err)
+		requireDenied(t, res, codes.PermissionDenied, typev3.StatusCode_Forbidden, "attestation token rejected")
+	})
+
+	t.Run("over grpc", func(t *testing.T) {
+		l := bufconn.Listen(1 << 20)
+		srv := grpc.NewServer()
+		authv3.RegisterAuthorizationServer(srv, s)
+		go srv.Serve(l)
+		defer srv.Stop()
+		conn, err := grpc.DialContext(ctx, "bufconn",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+			grpc.WithInsecure())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		res, err := authv3.NewAuthorizationClient(conn).Check(ctx, checkRequest(map[string]string{"authorization": bearer}))
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, okHeaders(t, res)["x-enclave-module-id"])
+	})
+}
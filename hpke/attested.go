@@ -0,0 +1,59 @@
+package hpke
+
+import (
+	"bytes"
+	"crypto"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+)
+
+// attestedInfo is the HPKE info of messages encrypted to attested keys.
+const attestedInfo = "nitro-attest hpke to attested key v1"
+
+// Message is a secret encrypted to the public key of an attestation document.
+type Message struct {
+	// DocumentHash is the SHA-256 digest of the attestation document encrypted to. It is
+	// the associated data of the ciphertext.
+	DocumentHash []byte `json:"document_hash"`
+	// Enc is the HPKE encapsulated key.
+	Enc []byte `json:"enc"`
+	// Ciphertext is the encrypted secret.
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptToDocument verifies an attestation document and encrypts plaintext to its
+// public_key, so that only the attested enclave can read it.
+// Pre: Parameter doc is a COSE encoded attestation document whose public_key is a P-256
+// or X25519 key. Parameter opts configures document verification.
+// Post: The Message or an error is returned.
+func EncryptToDocument(doc []byte, opts attestation.VerifyOptions, plaintext []byte) (*Message, error) {
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Document.PublicKey) == 0 {
+		return nil, errors.New("attestation document carries no public key")
+	}
+	pub, _, err := attestation.ParsePublicKey(res.Document.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash := attestation.DocumentHash(doc)
+	enc, ct, err := Seal(pub, []byte(attestedInfo), hash, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{DocumentHash: hash, Enc: enc, Ciphertext: ct}, nil
+}
+
+// DecryptFromDocument decrypts a Message inside the enclave.
+// Pre: Parameter priv is the private key from GenerateKeypair whose public key is in
+// doc. Parameter doc is the attestation document the sender verified.
+// Post: The plaintext or an error is returned.
+func DecryptFromDocument(priv crypto.PrivateKey, doc []byte, msg *Message) ([]byte, error) {
+	hash := attestation.DocumentHash(doc)
+	if !bytes.Equal(hash, msg.DocumentHash) {
+		return nil, errors.New("message is for a different attestation document")
+	}
+	return Open(priv, msg.Enc, []byte(attestedInfo), hash, msg.Ciphertext)
+}
@@ -0,0 +1,337 @@
+// Package hpke implements the base mode of RFC 9180 Hybrid Public Key Encryption for the
+// key types an enclave can attest: DHKEM(P-256, HKDF-SHA256) for P-256 keys and
+// DHKEM(X25519, HKDF-SHA256) for X25519 keys, both with HKDF-SHA256 and AES-128-GCM.
+package hpke
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"math/big"
+	"nitro/attest/attestation"
+)
+
+// Algorithm identifiers from the RFC 9180 registries.
+const (
+	KEMP256HKDFSHA256   uint16 = 0x0010
+	KEMX25519HKDFSHA256 uint16 = 0x0020
+	KDFHKDFSHA256       uint16 = 0x0001
+	AEADAES128GCM       uint16 = 0x0001
+)
+
+const (
+	modeBase  byte = 0x00
+	nSecret        = 32 // shared secret length of both KEMs
+	nKey           = 16 // AES-128-GCM key length
+	nNonce         = 12 // AES-128-GCM nonce length
+	version        = "HPKE-v1"
+	maxSeqNum      = 1<<64 - 1
+)
+
+// publicKeyer is implemented by every private key type.
+type publicKeyer interface {
+	Public() crypto.PublicKey
+}
+
+// kem is a DH based KEM over one of the supported key types.
+type kem struct {
+	id uint16
+}
+
+// kemFor picks the KEM matching a key algorithm.
+func kemFor(alg attestation.KeyAlgorithm) (kem, error) {
+	switch alg {
+	case attestation.P256:
+		return kem{id: KEMP256HKDFSHA256}, nil
+	case attestation.X25519:
+		return kem{id: KEMX25519HKDFSHA256}, nil
+	}
+	return kem{}, fmt.Errorf("hpke does not support %s keys", alg)
+}
+
+func (k kem) suiteID() []byte {
+	return append([]byte("KEM"), i2osp(uint64(k.id), 2)...)
+}
+
+// serialize encodes a public key as SerializePublicKey does.
+func (k kem) serialize(pub crypto.PublicKey) ([]byte, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k.id == KEMP256HKDFSHA256 && key.Curve == elliptic.P256() {
+			return elliptic.Marshal(key.Curve, key.X, key.Y), nil
+		}
+	case attestation.X25519PublicKey:
+		if k.id == KEMX25519HKDFSHA256 {
+			return []byte(key), nil
+		}
+	}
+	return nil, fmt.Errorf("public key %T does not match kem %#04x", pub, k.id)
+}
+
+// dh performs Diffie-Hellman between a private key and a serialized public key.
+func (k kem) dh(priv crypto.PrivateKey, peer []byte) ([]byte, error) {
+	switch key := priv.(type) {
+	case *ecdsa.PrivateKey:
+		if k.id != KEMP256HKDFSHA256 || key.Curve != elliptic.P256() {
+			break
+		}
+		x, y := elliptic.Unmarshal(key.Curve, peer)
+		if x == nil {
+			return nil, errors.New("invalid P-256 public key")
+		}
+		sx, _ := key.Curve.ScalarMult(x, y, key.D.Bytes())
+		return sx.FillBytes(make([]byte, 32)), nil
+	case *attestation.X25519PrivateKey:
+		if k.id != KEMX25519HKDFSHA256 {
+			break
+		}
+		return key.SharedKey(peer)
+	}
+	return nil, fmt.Errorf("private key %T does not match kem %#04x", priv, k.id)
+}
+
+func (k kem) extractAndExpand(dh, kemContext []byte) []byte {
+	prk := labeledExtract(k.suiteID(), nil, "eae_prk", dh)
+	return labeledExpand(k.suiteID(), prk, "shared_secret", kemContext, nSecret)
+}
+
+// encap runs Encap with the given ephemeral key.
+func (k kem) encap(ephemeral crypto.PrivateKey, pkR crypto.PublicKey) (sharedSecret, enc []byte, err error) {
+	pkRm, err := k.serialize(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, err = k.serialize(ephemeral.(publicKeyer).Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := k.dh(ephemeral, pkRm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.extractAndExpand(dh, append(append([]byte{}, enc...), pkRm...)), enc, nil
+}
+
+// decap runs Decap.
+func (k kem) decap(enc []byte, skR crypto.PrivateKey) ([]byte, error) {
+	dh, err := k.dh(skR, enc)
+	if err != nil {
+		return nil, err
+	}
+	pkRm, err := k.serialize(skR.(publicKeyer).Public())
+	if err != nil {
+		return nil, err
+	}
+	return k.extractAndExpand(dh, append(append([]byte{}, enc...), pkRm...)), nil
+}
+
+// deriveKeyPair runs DeriveKeyPair, deterministically deriving a private key from ikm.
+func (k kem) deriveKeyPair(ikm []byte) (crypto.PrivateKey, error) {
+	prk := labeledExtract(k.suiteID(), nil, "dkp_prk", ikm)
+	if k.id == KEMX25519HKDFSHA256 {
+		return attestation.NewX25519PrivateKey(labeledExpand(k.suiteID(), prk, "sk", nil, 32))
+	}
+	curve := elliptic.P256()
+	for counter := 0; counter < 256; counter++ {
+		bytes := labeledExpand(k.suiteID(), prk, "candidate", []byte{byte(counter)}, 32)
+		d := new(big.Int).SetBytes(bytes)
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			continue
+		}
+		key := &ecdsa.PrivateKey{D: d}
+		key.Curve = curve
+		key.X, key.Y = curve.ScalarBaseMult(bytes)
+		return key, nil
+	}
+	return nil, errors.New("could not derive key pair")
+}
+
+// Context is an HPKE encryption context. A sender context only seals and a recipient
+// context only opens; messages must be opened in the order they were sealed.
+type Context struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	seq       uint64
+}
+
+// SetupBaseS sets up a sender context for the recipient public key pkR.
+// Pre: Parameter rand is a source of entropy. Parameter pkR is a P-256 or X25519 public
+// key. Parameter info binds the context to the application.
+// Post: The encapsulated key and the context are returned, or an error is returned.
+func SetupBaseS(rand io.Reader, pkR crypto.PublicKey, info []byte) ([]byte, *Context, error) {
+	alg, err := attestation.KeyAlgorithmOf(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := kemFor(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, ephemeral, err := attestation.GenerateKeypairFrom(rand, alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return setupBaseS(k, ephemeral, pkR, info)
+}
+
+func setupBaseS(k kem, ephemeral crypto.PrivateKey, pkR crypto.PublicKey, info []byte) ([]byte, *Context, error) {
+	sharedSecret, enc, err := k.encap(ephemeral, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err := keySchedule(k, sharedSecret, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, ctx, nil
+}
+
+// SetupBaseR sets up a recipient context.
+// Pre: Parameter skR is the P-256 or X25519 private key the sender encrypted to.
+// Parameter enc is the encapsulated key from SetupBaseS. Parameter info is the sender's.
+// Post: The context or an error is returned.
+func SetupBaseR(enc []byte, skR crypto.PrivateKey, info []byte) (*Context, error) {
+	priv, ok := skR.(publicKeyer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", skR)
+	}
+	alg, err := attestation.KeyAlgorithmOf(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	k, err := kemFor(alg)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := k.decap(enc, skR)
+	if err != nil {
+		return nil, err
+	}
+	return keySchedule(k, sharedSecret, info)
+}
+
+// keySchedule derives the AEAD key and base nonce of a base mode context.
+func keySchedule(k kem, sharedSecret, info []byte) (*Context, error) {
+	suiteID := []byte("HPKE")
+	suiteID = append(suiteID, i2osp(uint64(k.id), 2)...)
+	suiteID = append(suiteID, i2osp(uint64(KDFHKDFSHA256), 2)...)
+	suiteID = append(suiteID, i2osp(uint64(AEADAES128GCM), 2)...)
+
+	pskIDHash := labeledExtract(suiteID, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(suiteID, nil, "info_hash", info)
+	ksContext := append(append([]byte{modeBase}, pskIDHash...), infoHash...)
+	secret := labeledExtract(suiteID, sharedSecret, "secret", nil)
+
+	block, err := aes.NewCipher(labeledExpand(suiteID, secret, "key", ksContext, nKey))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Context{aead: aead, baseNonce: labeledExpand(suiteID, secret, "base_nonce", ksContext, nNonce)}, nil
+}
+
+// Seal encrypts the next message.
+// Pre: Parameter aad is authenticated but not encrypted.
+// Post: The ciphertext or an error is returned.
+func (c *Context) Seal(aad, plaintext []byte) ([]byte, error) {
+	if c.seq == maxSeqNum {
+		return nil, errors.New("message limit reached")
+	}
+	ct := c.aead.Seal(nil, c.nonce(), plaintext, aad)
+	c.seq++
+	return ct, nil
+}
+
+// Open decrypts the next message.
+// Pre: Parameter aad is the sender's associated data.
+// Post: The plaintext or an error is returned.
+func (c *Context) Open(aad, ciphertext []byte) ([]byte, error) {
+	if c.seq == maxSeqNum {
+		return nil, errors.New("message limit reached")
+	}
+	pt, err := c.aead.Open(nil, c.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("message authentication failed")
+	}
+	c.seq++
+	return pt, nil
+}
+
+func (c *Context) nonce() []byte {
+	nonce := i2osp(c.seq, nNonce)
+	for i := range nonce {
+		nonce[i] ^= c.baseNonce[i]
+	}
+	return nonce
+}
+
+// Seal is single-shot encryption to pkR.
+// Pre: See SetupBaseS and Context.Seal.
+// Post: The encapsulated key and the ciphertext are returned, or an error is returned.
+func Seal(pkR crypto.PublicKey, info, aad, plaintext []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupBaseS(rand.Reader, pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	ct, err := ctx.Seal(aad, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, ct, nil
+}
+
+// Open is single-shot decryption.
+// Pre: See SetupBaseR and Context.Open.
+// Post: The plaintext or an error is returned.
+func Open(skR crypto.PrivateKey, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	ctx, err := SetupBaseR(enc, skR, info)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Open(aad, ciphertext)
+}
+
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeled := append([]byte(version), suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeled := i2osp(uint64(length), 2)
+	labeled = append(labeled, version...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out); err != nil {
+		panic(err) // length is far below the HKDF limit
+	}
+	return out
+}
+
+// i2osp encodes n as a big-endian integer of the given width.
+func i2osp(n uint64, width int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	out := make([]byte, width)
+	if width >= 8 {
+		copy(out[width-8:], buf[:])
+	} else {
+		copy(out, buf[8-width:])
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package hpke
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+)
+
+// vector is an RFC 9180 appendix A base mode test vector with HKDF-SHA256 and
+// AES-128-GCM, truncated to its first two encryptions.
+type vector struct {
+	kem        uint16
+	info       string
+	ikmE       string
+	ikmR       string
+	skRm       string
+	pkRm       string
+	enc        string
+	plaintext  string
+	ciphertext [2]string
+}
+
+var vectors = []vector{
+	{
+		kem:       KEMX25519HKDFSHA256,
+		info:      "4f6465206f6e2061204772656369616e2055726e",
+		ikmE:      "7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234",
+		ikmR:      "6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037",
+		skRm:      "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8",
+		pkRm:      "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d",
+		enc:       "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431",
+		plaintext: "4265617574792069732074727574682c20747275746820626561757479",
+		ciphertext: [2]string{
+			"f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a",
+			"af2d7e9ac9ae7e270f46ba1f975be53c09f8d875bdc8535458c2494e8a6eab251c03d0c22a56b8ca42c2063b84",
+		},
+	},
+	{
+		kem:       KEMP256HKDFSHA256,
+		info:      "4f6465206f6e2061204772656369616e2055726e",
+		ikmE:      "4270e54ffd08d79d5928020af4686d8f6b7d35dbe470265f1f5aa22816ce860e",
+		ikmR:      "668b37171f1072f3cf12ea8a236a45df23fc13b82af3609ad1e354f6ef817550",
+		skRm:      "f3ce7fdae57e1a310d87f1ebbde6f328be0a99cdbcadf4d6589cf29de4b8ffd2",
+		pkRm:      "04fe8c19ce0905191ebc298a9245792531f26f0cece2460639e8bc39cb7f706a826a779b4cf969b8a0e539c7f62fb3d30ad6aa8f80e30f1d128aafd68a2ce72ea0",
+		enc:       "04a92719c6195d5085104f469a8b9814d5838ff72b60501e2c4466e5e67b325ac98536d7b61a1af4b78e5b7f951c0900be863c403ce65c9bfcb9382657222d18c4",
+		plaintext: "4265617574792069732074727574682c20747275746820626561757479",
+		ciphertext: [2]string{
+			"5ad590bb8baa577f8619db35a36311226a896e7342a6d836d8b7bcd2f20b6c7f9076ac232e3ab2523f39513434",
+			"fa6f037b47fc21826b610172ca9637e82d6e5801eb31cbd3748271affd4ecb06646e0329cbdf3c3cd655b28e82",
+		},
+	},
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		v := v
+		t.Run(hex.EncodeToString(i2osp(uint64(v.kem), 2)), func(t *testing.T) {
+			k := kem{id: v.kem}
+			skE, err := k.deriveKeyPair(unhex(t, v.ikmE))
+			require.NoError(t, err)
+			skR, err := k.deriveKeyPair(unhex(t, v.ikmR))
+			require.NoError(t, err)
+			pkR := skR.(publicKeyer).Public()
+			pkRm, err := k.serialize(pkR)
+			require.NoError(t, err)
+			require.Equal(t, v.pkRm, hex.EncodeToString(pkRm))
+
+			enc, sender, err := setupBaseS(k, skE, pkR, unhex(t, v.info))
+			require.NoError(t, err)
+			require.Equal(t, v.enc, hex.EncodeToString(enc))
+			recipient, err := SetupBaseR(enc, skR, unhex(t, v.info))
+			require.NoError(t, err)
+
+			for i, want := range v.ciphertext {
+				aad := []byte("Count-" + string(rune('0'+i)))
+				ct, err := sender.Seal(aad, unhex(t, v.plaintext))
+				require.NoError(t, err)
+				require.Equal(t, want, hex.EncodeToString(ct))
+				pt, err := recipient.Open(aad, ct)
+				require.NoError(t, err)
+				require.Equal(t, v.plaintext, hex.EncodeToString(pt))
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	for _, alg := range []attestation.KeyAlgorithm{attestation.P256, attestation.X25519} {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			_, priv, err := attestation.GenerateKeypairFrom(rand.Reader, alg)
+			require.NoError(t, err)
+			pub := priv.(publicKeyer).Public()
+
+			enc, ct, err := Seal(pub, []byte("info"), []byte("aad"), []byte("secret"))
+			require.NoError(t, err)
+			pt, err := Open(priv, enc, []byte("info"), []byte("aad"), ct)
+			require.NoError(t, err)
+			require.Equal(t, []byte("secret"), pt)
+
+			_, err = Open(priv, enc, []byte("info"), []byte("other"), ct)
+			require.EqualError(t, err, "message authentication failed")
+			_, err = Open(priv, enc, []byte("other"), []byte("aad"), ct)
+			require.EqualError(t, err, "message authentication failed")
+		})
+	}
+
+	t.Run("unsupported key", func(t *testing.T) {
+		_, priv, err := attestation.GenerateKeypairFrom(rand.Reader, attestation.Ed25519)
+		require.NoError(t, err)
+		_, _, err = Seal(priv.(publicKeyer).Public(), nil, nil, nil)
+		require.EqualError(t, err, "hpke does not support Ed25519 keys")
+	})
+}
+
+func TestAttested(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	opts := attestation.VerifyOptions{Roots: sim.Roots()}
+
+	xpub, xprv, err := attestation.GenerateKeypairFrom(sim, attestation.X25519)
+	require.NoError(t, err)
+	doc, err := attestation.RetrieveAttestationFrom(sim, nil, nil, xpub)
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		msg, err := EncryptToDocument(doc, opts, []byte("secret"))
+		require.NoError(t, err)
+		pt, err := DecryptFromDocument(xprv, doc, msg)
+		require.NoError(t, err)
+		require.Equal(t, []byte("secret"), pt)
+	})
+
+	t.Run("bound to the document", func(t *testing.T) {
+		msg, err := EncryptToDocument(doc, opts, []byte("secret"))
+		require.NoError(t, err)
+		other, err := attestation.RetrieveAttestationFrom(sim, nil, nil, xpub)
+		require.NoError(t, err)
+		_, err = DecryptFromDocument(xprv, other, msg)
+		require.EqualError(t, err, "message is for a different attestation document")
+
+		msg.DocumentHash = attestation.DocumentHash(other)
+		_, err = DecryptFromDocument(xprv, other, msg)
+		require.EqualError(t, err, "message authentication failed")
+	})
+
+	t.Run("untrusted document", func(t *testing.T) {
+		ca, err := attestationtest.NewCA()
+		require.NoError(t, err)
+		_, err = EncryptToDocument(doc, attestation.VerifyOptions{Roots: ca.Roots()}, []byte("secret"))
+		require.Error(t, err)
+	})
+
+	t.Run("no public key", func(t *testing.T) {
+		bare, err := attestation.RetrieveAttestationFrom(sim, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = EncryptToDocument(bare, opts, []byte("secret"))
+		require.EqualError(t, err, "attestation document carries no public key")
+	})
+}
@@ -0,0 +1,291 @@
+// Package jwks publishes the enclave's attested keys as a JSON Web Key Set (RFC 7517)
+// and fetches such sets on the relying party side. Every JWK carries the attestation
+// document binding it in the custom "attestation_doc" member; the client drops keys whose
+// document does not verify.
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"io"
+	"math/big"
+	"net/http"
+	"nitro/attest/attestation"
+	"nitro/attest/keyring"
+)
+
+// ContentType is the media type of a JWK Set.
+const ContentType = "application/jwk-set+json"
+
+// maxSetSize bounds the size of a fetched JWK Set.
+const maxSetSize = 1 << 20
+
+// JWK is a public JSON Web Key with its attestation document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	// AttestationDoc is the base64 COSE attestation document whose public_key is this key.
+	AttestationDoc string `json:"attestation_doc"`
+}
+
+// Set is a JWK Set.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// b64 encodes JWK members (base64url without padding).
+var b64 = base64.RawURLEncoding
+
+// FromKey converts an attested key to a JWK.
+// Pre: Parameter key is a key from a keyring.Manager.
+// Post: The JWK or an error is returned.
+func FromKey(key *keyring.Key) (JWK, error) {
+	pub, _, err := attestation.ParsePublicKey(key.PublicKey)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{
+		KeyID:          key.ID,
+		Use:            "sig",
+		AttestationDoc: base64.StdEncoding.EncodeToString(key.AttestationDoc),
+	}
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = k.Curve.Params().Name
+		jwk.X = b64.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+		jwk.Algorithm = "ES256"
+		if key.Algorithm == attestation.P384 {
+			jwk.Algorithm = "ES384"
+		}
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64.EncodeToString(k)
+		jwk.Algorithm = "EdDSA"
+	case attestation.X25519PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "X25519"
+		jwk.X = b64.EncodeToString(k)
+		jwk.Use = "enc"
+		jwk.Algorithm = "ECDH-ES"
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64.EncodeToString(k.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		jwk.Algorithm = "PS256"
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+	return jwk, nil
+}
+
+// PublicKey decodes the key material of a JWK.
+// Pre: None.
+// Post: The public key or an error is returned.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decodeInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad x member")
+		}
+		if len(x) != 32 {
+			return nil, fmt.Errorf("bad %s key length %d", j.Curve, len(x))
+		}
+		switch j.Curve {
+		case "Ed25519":
+			return ed25519.PublicKey(x), nil
+		case "X25519":
+			return attestation.X25519PublicKey(x), nil
+		}
+		return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+	case "RSA":
+		n, err := decodeInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+}
+
+// decodeInt decodes a base64url big-endian integer member.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := b64.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("bad integer member")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// NewHandler serves the currently valid keys of a manager as a JWK Set.
+// Pre: Parameter keys is the enclave's key manager.
+// Post: An http.Handler answering GET requests is returned.
+func NewHandler(keys *keyring.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		set := Set{Keys: []JWK{}}
+		for _, key := range keys.Keys() {
+			jwk, err := FromKey(key)
+			if err != nil {
+				http.Error(w, "could not encode key", http.StatusInternalServerError)
+				return
+			}
+			set.Keys = append(set.Keys, jwk)
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(set)
+	})
+}
+
+// VerifiedKey is a key whose attestation document passed verification.
+type VerifiedKey struct {
+	JWK
+	// Key is the decoded public key.
+	Key crypto.PublicKey
+	// Document is the verified attestation document.
+	Document *nitrite.Document
+}
+
+// Client fetches and verifies a remote JWK Set.
+type Client struct {
+	// HTTPClient performs requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// URL is the JWK Set endpoint.
+	URL string
+	// Options configure attestation document verification.
+	Options attestation.VerifyOptions
+	// OnReject, if not nil, is told about every key that was dropped.
+	OnReject func(kid string, err error)
+}
+
+// Fetch downloads the JWK Set and returns the keys that pass verification.
+// Pre: Parameter ctx bounds the request.
+// Post: The verified keys (possibly none) are returned, or an error is returned if the
+// set could not be fetched.
+func (c *Client) Fetch(ctx context.Context) ([]VerifiedKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+", application/json")
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch jwks")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %s", resp.Status)
+	}
+	var set Set
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSetSize)).Decode(&set); err != nil {
+		return nil, errors.Wrap(err, "could not decode jwks")
+	}
+	return c.VerifySet(set), nil
+}
+
+// VerifySet returns the keys of set that pass verification.
+// Pre: Parameter set is a JWK Set.
+// Post: The verified keys are returned in set order.
+func (c *Client) VerifySet(set Set) []VerifiedKey {
+	var keys []VerifiedKey
+	for _, jwk := range set.Keys {
+		key, err := Verify(jwk, c.Options)
+		if err != nil {
+			if c.OnReject != nil {
+				c.OnReject(jwk.KeyID, err)
+			}
+			continue
+		}
+		keys = append(keys, *key)
+	}
+	return keys
+}
+
+// Verify checks one JWK: its attestation document must verify, its public_key must be
+// the JWK's key, and kid must be the key's thumbprint.
+// Pre: Parameter opts configures document verification.
+// Post: The verified key or an error is returned.
+func Verify(jwk JWK, opts attestation.VerifyOptions) (*VerifiedKey, error) {
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := attestation.MarshalPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := base64.StdEncoding.DecodeString(jwk.AttestationDoc)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad attestation_doc member")
+	}
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(res.Document.PublicKey, der) {
+		return nil, errors.New("key is not the attested public key")
+	}
+	if jwk.KeyID != keyring.Thumbprint(der) {
+		return nil, errors.New("kid is not the key thumbprint")
+	}
+	return &VerifiedKey{JWK: jwk, Key: pub, Document: res.Document}, nil
+}
@@ -0,0 +1,124 @@
+package jwks
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/keyring"
+	"testing"
+	"time"
+)
+
+func TestJWK(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	opts := attestation.VerifyOptions{Roots: sim.Roots()}
+
+	for _, alg := range []attestation.KeyAlgorithm{attestation.P256, attestation.P384, attestation.Ed25519, attestation.X25519, attestation.RSA} {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: alg, Interval: time.Hour})
+			require.NoError(t, err)
+			key := keys.Keys()[0]
+			jwk, err := FromKey(key)
+			require.NoError(t, err)
+
+			verified, err := Verify(jwk, opts)
+			require.NoError(t, err)
+			der, err := attestation.MarshalPublicKey(verified.Key)
+			require.NoError(t, err)
+			require.Equal(t, key.PublicKey, der)
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour, Overlap: time.Hour})
+	require.NoError(t, err)
+	_, err = keys.Rotate()
+	require.NoError(t, err)
+
+	t.Run("fetch", func(t *testing.T) {
+		srv := httptest.NewServer(NewHandler(keys))
+		defer srv.Close()
+		c := &Client{URL: srv.URL, Options: attestation.VerifyOptions{Roots: sim.Roots()}}
+		verified, err := c.Fetch(context.Background())
+		require.NoError(t, err)
+		require.Len(t, verified, 2)
+		for i, key := range keys.Keys() {
+			require.Equal(t, key.ID, verified[i].KeyID)
+			require.Equal(t, sim.ModuleID, verified[i].Document.ModuleID)
+		}
+	})
+
+	t.Run("rejects bad keys", func(t *testing.T) {
+		good, err := FromKey(keys.Keys()[0])
+		require.NoError(t, err)
+		swapped, err := FromKey(keys.Keys()[1])
+		require.NoError(t, err)
+		swapped.AttestationDoc = good.AttestationDoc
+
+		other, err := attestationtest.NewSimulator(nil)
+		require.NoError(t, err)
+		otherKeys, err := keyring.NewManager(other, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+		require.NoError(t, err)
+		untrusted, err := FromKey(otherKeys.Keys()[0])
+		require.NoError(t, err)
+
+		relabeled := good
+		relabeled.KeyID = swapped.KeyID
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(Set{Keys: []JWK{swapped, untrusted, good, relabeled}})
+		}))
+		defer srv.Close()
+
+		var rejected []string
+		c := &Client{
+			URL:      srv.URL,
+			Options:  attestation.VerifyOptions{Roots: sim.Roots()},
+			OnReject: func(kid string, err error) { rejected = append(rejected, err.Error()) },
+		}
+		verified, err := c.Fetch(context.Background())
+		require.NoError(t, err)
+		require.Len(t, verified, 1)
+		require.Equal(t, good.KeyID, verified[0].KeyID)
+		require.Len(t, rejected, 3)
+		require.Equal(t, "key is not the attested public key", rejected[0])
+		require.Equal(t, "kid is not the key thumbprint", rejected[2])
+	})
+
+	t.Run("bad member", func(t *testing.T) {
+		jwk, err := FromKey(keys.Keys()[0])
+		require.NoError(t, err)
+		jwk.Curve = "P-521"
+		_, err = Verify(jwk, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.EqualError(t, err, `unsupported curve "P-521"`)
+
+		jwk, err = FromKey(keys.Keys()[0])
+		require.NoError(t, err)
+		jwk.AttestationDoc = base64.StdEncoding.EncodeToString([]byte("garbage"))
+		_, err = Verify(jwk, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.Error(t, err)
+	})
+
+	t.Run("endpoint error", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		_, err := (&Client{URL: srv.URL}).Fetch(context.Background())
+		require.EqualError(t, err, "jwks endpoint returned 404 Not Found")
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		NewHandler(keys).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
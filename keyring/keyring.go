@@ -0,0 +1,206 @@
+// Package keyring manages the enclave's attested keys. A Manager rotates keys on a
+// schedule, obtains an attestation document binding every new public key, and keeps
+// retired keys valid for an overlap window so that clients holding the previous key set
+// are not cut off mid-rotation.
+package keyring
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key is an attested key held by a Manager. Everything but the private key is safe to
+// publish.
+type Key struct {
+	// ID is the base64url SHA-256 thumbprint of PublicKey.
+	ID string `json:"kid"`
+	// Algorithm is the key algorithm.
+	Algorithm attestation.KeyAlgorithm `json:"alg"`
+	// PublicKey is the PKIX DER public key.
+	PublicKey []byte `json:"public_key"`
+	// AttestationDoc is the document whose public_key field is PublicKey.
+	AttestationDoc []byte `json:"attestation_doc"`
+	// NotBefore is the time the key was created.
+	NotBefore time.Time `json:"not_before"`
+	// NotAfter is the time after which the key must no longer be used.
+	NotAfter time.Time `json:"not_after"`
+
+	private crypto.PrivateKey
+}
+
+// PrivateKey returns the key's private half. It never leaves the enclave.
+func (k *Key) PrivateKey() crypto.PrivateKey {
+	return k.private
+}
+
+// Signer returns the private key as a crypto.Signer, if the algorithm can sign.
+func (k *Key) Signer() (crypto.Signer, bool) {
+	signer, ok := k.private.(crypto.Signer)
+	return signer, ok
+}
+
+// ValidAt reports whether the key may be used at t.
+func (k *Key) ValidAt(t time.Time) bool {
+	return !t.Before(k.NotBefore) && t.Before(k.NotAfter)
+}
+
+// Thumbprint computes the key identifier used for a PKIX DER public key.
+func Thumbprint(publicKey []byte) string {
+	digest := sha256.Sum256(publicKey)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// Options configure a Manager.
+type Options struct {
+	// Algorithm is the algorithm of generated keys.
+	Algorithm attestation.KeyAlgorithm
+	// Interval is how long a key stays current before it is rotated out.
+	Interval time.Duration
+	// Overlap is how long a rotated out key remains valid.
+	Overlap time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Manager rotates attested keys.
+type Manager struct {
+	sess attestation.Session
+	opts Options
+
+	mu   sync.RWMutex
+	keys []*Key // newest first
+}
+
+// NewManager creates a Manager holding one freshly generated and attested key.
+// Pre: Parameter sess is the enclave's NSM session. Parameter opts has a positive
+// Interval and a non-negative Overlap.
+// Post: A Manager or an error is returned.
+func NewManager(sess attestation.Session, opts Options) (*Manager, error) {
+	if opts.Interval <= 0 {
+		return nil, errors.New("rotation interval must be positive")
+	}
+	if opts.Overlap < 0 {
+		return nil, errors.New("overlap window must not be negative")
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	m := &Manager{sess: sess, opts: opts}
+	if _, err := m.Rotate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Rotate generates and attests a new current key. The previous current key stays valid
+// for the overlap window, and keys past their NotAfter are dropped.
+// Pre: None.
+// Post: The new current key or an error is returned. On error the key set is unchanged.
+func (m *Manager) Rotate() (*Key, error) {
+	xpub, xprv, err := attestation.GenerateKeypairFrom(m.sess, m.opts.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := attestation.RetrieveAttestationFrom(m.sess, nil, nil, xpub)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not attest new key")
+	}
+	now := m.opts.Now()
+	key := &Key{
+		ID:             Thumbprint(xpub),
+		Algorithm:      m.opts.Algorithm,
+		PublicKey:      xpub,
+		AttestationDoc: doc,
+		NotBefore:      now,
+		NotAfter:       now.Add(m.opts.Interval + m.opts.Overlap),
+		private:        xprv,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.keys) > 0 {
+		// Rotating early must not extend the previous key beyond its schedule. Keys
+		// handed out are never mutated, so the shortened key is a copy.
+		if retired := now.Add(m.opts.Overlap); retired.Before(m.keys[0].NotAfter) {
+			previous := *m.keys[0]
+			previous.NotAfter = retired
+			m.keys[0] = &previous
+		}
+	}
+	keys := []*Key{key}
+	for _, k := range m.keys {
+		if k.ValidAt(now) {
+			keys = append(keys, k)
+		}
+	}
+	m.keys = keys
+	return key, nil
+}
+
+// ErrNoCurrentKey is returned by Current once the newest key has expired, which happens
+// only when rotation has kept failing for longer than Interval plus Overlap.
+var ErrNoCurrentKey = errors.New("current key has expired; key rotation is failing")
+
+// Current returns the key that new signatures and encryptions should use.
+// Pre: None.
+// Post: The newest key is returned, or ErrNoCurrentKey if it is no longer valid.
+func (m *Manager) Current() (*Key, error) {
+	now := m.opts.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if key := m.keys[0]; key.ValidAt(now) {
+		return key, nil
+	}
+	return nil, ErrNoCurrentKey
+}
+
+// Keys returns the keys that are valid now, newest first.
+func (m *Manager) Keys() []*Key {
+	now := m.opts.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]*Key, 0, len(m.keys))
+	for _, k := range m.keys {
+		if k.ValidAt(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].NotBefore.After(keys[j].NotBefore) })
+	return keys
+}
+
+// Key looks up a valid key by ID.
+func (m *Manager) Key(id string) (*Key, bool) {
+	for _, k := range m.Keys() {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Run rotates keys every Interval until ctx is done. Rotation failures are retried at
+// the next tick; the current key stays in use meanwhile, until it expires.
+// Pre: Parameter ctx bounds the lifetime of the rotation loop. Parameter onError, if not
+// nil, is told about failed rotations.
+// Post: The context's error is returned once it is done.
+func (m *Manager) Run(ctx context.Context, onError func(error)) error {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := m.Rotate(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
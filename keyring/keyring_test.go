@@ -0,0 +1,131 @@
+package keyring
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestManager(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	clk := &clock{now: time.Now()}
+	m, err := NewManager(sim, Options{
+		Algorithm: attestation.P256,
+		Interval:  time.Hour,
+		Overlap:   10 * time.Minute,
+		Now:       clk.Now,
+	})
+	require.NoError(t, err)
+	first, err := m.Current()
+	require.NoError(t, err)
+
+	t.Run("key is attested", func(t *testing.T) {
+		res, err := attestation.VerifyDocument(first.AttestationDoc, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.NoError(t, err)
+		require.Equal(t, first.PublicKey, res.Document.PublicKey)
+		require.Equal(t, Thumbprint(first.PublicKey), first.ID)
+		_, ok := first.Signer()
+		require.True(t, ok)
+	})
+
+	t.Run("overlap window", func(t *testing.T) {
+		clk.Advance(time.Hour)
+		second, err := m.Rotate()
+		require.NoError(t, err)
+		current, err := m.Current()
+		require.NoError(t, err)
+		require.Equal(t, second, current)
+		require.NotEqual(t, first.ID, second.ID)
+		require.Equal(t, []*Key{second, first}, m.Keys())
+
+		found, ok := m.Key(first.ID)
+		require.True(t, ok)
+		require.Equal(t, first, found)
+
+		clk.Advance(10 * time.Minute)
+		require.Equal(t, []*Key{second}, m.Keys())
+		_, ok = m.Key(first.ID)
+		require.False(t, ok)
+	})
+
+	t.Run("early rotation shortens the previous key", func(t *testing.T) {
+		previous, err := m.Current()
+		require.NoError(t, err)
+		clk.Advance(time.Minute)
+		_, err = m.Rotate()
+		require.NoError(t, err)
+		shortened, ok := m.Key(previous.ID)
+		require.True(t, ok)
+		require.Equal(t, clk.Now().Add(10*time.Minute), shortened.NotAfter)
+		require.Len(t, m.Keys(), 2)
+	})
+
+	t.Run("expired current key", func(t *testing.T) {
+		clk.Advance(time.Hour + 10*time.Minute)
+		_, err := m.Current()
+		require.Equal(t, ErrNoCurrentKey, err, "a key outliving failed rotations is not used")
+		require.Empty(t, m.Keys())
+		rotated, err := m.Rotate()
+		require.NoError(t, err)
+		current, err := m.Current()
+		require.NoError(t, err)
+		require.Equal(t, rotated, current)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewManager(sim, Options{Algorithm: attestation.P256})
+		require.EqualError(t, err, "rotation interval must be positive")
+		_, err = NewManager(sim, Options{Algorithm: attestation.P256, Interval: time.Hour, Overlap: -1})
+		require.EqualError(t, err, "overlap window must not be negative")
+		_, err = NewManager(sim, Options{Algorithm: "DSA", Interval: time.Hour})
+		require.EqualError(t, err, `unsupported key algorithm "DSA"`)
+	})
+}
+
+func TestManagerRun(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	m, err := NewManager(sim, Options{
+		Algorithm: attestation.Ed25519,
+		Interval:  10 * time.Millisecond,
+		Overlap:   time.Second,
+	})
+	require.NoError(t, err)
+	first, err := m.Current()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx, nil) }()
+	require.Eventually(t, func() bool {
+		current, err := m.Current()
+		return err == nil && current != first
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+	_, ok := m.Key(first.ID)
+	require.True(t, ok)
+}
@@ -0,0 +1,110 @@
+// Package logging adapts common loggers to attestation.Logger, so the entries package
+// attestation logs end up wherever an application already sends its logs:
+//
+//	attestation.SetLogger(logging.Zap(zapLogger))
+//
+// Entries reach the adapters already redacted.
+package logging
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"log"
+	"nitro/attest/attestation"
+	"strconv"
+	"strings"
+)
+
+// Zap adapts a zap logger.
+// Pre: Parameter l is a zap logger.
+// Post: The adapter is returned.
+func Zap(l *zap.Logger) attestation.Logger {
+	return zapLogger{l}
+}
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+func (z zapLogger) Log(level attestation.Level, msg string, fields []attestation.Field) {
+	zf := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zf[i] = zap.Any(f.Key, f.Value)
+	}
+	switch level {
+	case attestation.LevelDebug:
+		z.l.Debug(msg, zf...)
+	case attestation.LevelInfo:
+		z.l.Info(msg, zf...)
+	case attestation.LevelWarn:
+		z.l.Warn(msg, zf...)
+	default:
+		z.l.Error(msg, zf...)
+	}
+}
+
+// Logrus adapts a logrus logger or entry.
+// Pre: Parameter l is a *logrus.Logger or *logrus.Entry.
+// Post: The adapter is returned.
+func Logrus(l logrus.FieldLogger) attestation.Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (r logrusLogger) Log(level attestation.Level, msg string, fields []attestation.Field) {
+	lf := make(logrus.Fields, len(fields))
+	for _, f := range fields {
+		lf[f.Key] = f.Value
+	}
+	entry := r.l.WithFields(lf)
+	switch level {
+	case attestation.LevelDebug:
+		entry.Debug(msg)
+	case attestation.LevelInfo:
+		entry.Info(msg)
+	case attestation.LevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+// Std adapts a standard library logger, writing each entry as one line of logfmt
+// key=value pairs and leaving out fields without a value. Entries below min are
+// discarded.
+// Pre: Parameter l is a logger.
+// Post: The adapter is returned.
+func Std(l *log.Logger, min attestation.Level) attestation.Logger {
+	return stdLogger{l: l, min: min}
+}
+
+type stdLogger struct {
+	l   *log.Logger
+	min attestation.Level
+}
+
+func (s stdLogger) Log(level attestation.Level, msg string, fields []attestation.Field) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "level=%s msg=%s", level, quote(msg))
+	for _, f := range fields {
+		if f.Value != nil {
+			fmt.Fprintf(&b, " %s=%s", f.Key, quote(fmt.Sprint(f.Value)))
+		}
+	}
+	s.l.Print(b.String())
+}
+
+// quote quotes a logfmt value if it needs it.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log"
+	"nitro/attest/attestation"
+	"testing"
+)
+
+func TestAdapters(t *testing.T) {
+	fields := []attestation.Field{
+		attestation.ModuleIDField("i-0123-enc4567"),
+		attestation.CheckField(attestation.ClassNonce),
+		{Key: attestation.FieldNonce},
+	}
+
+	t.Run("zap", func(t *testing.T) {
+		var buf bytes.Buffer
+		core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.DebugLevel)
+		Zap(zap.New(core)).Log(attestation.LevelWarn, "attestation document rejected", fields)
+		var logged map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &logged))
+		require.Equal(t, "warn", logged["level"])
+		require.Equal(t, "attestation document rejected", logged["msg"])
+		require.Equal(t, "i-0123-enc4567", logged["module_id"])
+		require.Equal(t, "nonce", logged["check"])
+	})
+
+	t.Run("logrus", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := logrus.New()
+		l.SetOutput(&buf)
+		l.SetFormatter(&logrus.JSONFormatter{})
+		l.SetLevel(logrus.DebugLevel)
+		Logrus(l).Log(attestation.LevelDebug, "nonce created", fields)
+		var logged map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &logged))
+		require.Equal(t, "debug", logged["level"])
+		require.Equal(t, "nonce created", logged["msg"])
+		require.Equal(t, "i-0123-enc4567", logged["module_id"])
+	})
+
+	t.Run("std", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := Std(log.New(&buf, "", 0), attestation.LevelInfo)
+		l.Log(attestation.LevelDebug, "nonce created", fields)
+		require.Empty(t, buf.String())
+		l.Log(attestation.LevelError, "certificate revoked", append(fields, attestation.Field{Key: "subject", Value: `CN=a "b"`}))
+		require.Equal(t, `level=error msg="certificate revoked" module_id=i-0123-enc4567 check=nonce subject="CN=a \"b\""`+"\n", buf.String())
+	})
+}
@@ -0,0 +1,101 @@
+// Package metrics exports Prometheus metrics about attestation: how often each operation
+// of package attestation succeeds or fails, why it fails, and how long it takes.
+//
+// Nothing is registered with the global Prometheus registry. Create Metrics, register it
+// with a registry of your choosing and install it with attestation.SetObserver, or let
+// Install do both:
+//
+//	reg := prometheus.NewRegistry()
+//	if _, err := metrics.Install(reg); err != nil { ... }
+//	http.Handle("/metrics", metrics.Handler(reg))
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"nitro/attest/attestation"
+	"time"
+)
+
+// Namespace prefixes the names of the metrics.
+const Namespace = "attest"
+
+// Outcome label values.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// classNone is the error_class label of successful operations.
+const classNone = "none"
+
+// Metrics is an attestation.Observer that records operations as Prometheus metrics. It
+// is a prometheus.Collector.
+type Metrics struct {
+	operations *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+}
+
+// New creates unregistered metrics.
+// Pre: None.
+// Post: The metrics are returned.
+func New() *Metrics {
+	return &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "operations_total",
+			Help:      "Attestation operations by operation, outcome and error class.",
+		}, []string{"operation", "outcome", "error_class"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of attestation operations by operation and outcome.",
+			// From half a millisecond for NSM calls to minutes for CRL downloads.
+			Buckets: prometheus.ExponentialBuckets(0.0005, 4, 10),
+		}, []string{"operation", "outcome"}),
+	}
+}
+
+// Install creates metrics, registers them with reg and makes them the observer of
+// package attestation.
+// Pre: Parameter reg does not hold attestation metrics yet.
+// Post: The installed metrics, or an error if they could not be registered, are returned.
+func Install(reg prometheus.Registerer) (*Metrics, error) {
+	m := New()
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	attestation.SetObserver(m)
+	return m, nil
+}
+
+// Observe records one operation.
+func (m *Metrics) Observe(op attestation.Operation, d time.Duration, class attestation.ErrorClass) {
+	outcome, label := OutcomeSuccess, classNone
+	if class != attestation.ClassNone {
+		outcome, label = OutcomeFailure, string(class)
+	}
+	m.operations.WithLabelValues(string(op), outcome, label).Inc()
+	m.durations.WithLabelValues(string(op), outcome).Observe(d.Seconds())
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.durations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.durations.Collect(ch)
+}
+
+// Handler serves the metrics gathered by g in the Prometheus exposition format, for
+// mounting at /metrics.
+// Pre: Parameter g is the registry the metrics were registered with.
+// Post: The handler is returned.
+func Handler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http/httptest"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+	"time"
+)
+
+// counts returns attest_operations_total by "operation/outcome/error_class".
+func counts(t *testing.T, g prometheus.Gatherer) map[string]float64 {
+	families, err := g.Gather()
+	require.NoError(t, err)
+	found := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "attest_operations_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			found[labels(metric)] = metric.GetCounter().GetValue()
+		}
+	}
+	return found
+}
+
+func labels(metric *dto.Metric) string {
+	values := map[string]string{}
+	for _, pair := range metric.GetLabel() {
+		values[pair.GetName()] = pair.GetValue()
+	}
+	return values["operation"] + "/" + values["outcome"] + "/" + values["error_class"]
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := Install(reg)
+	require.NoError(t, err)
+	defer attestation.SetObserver(nil)
+
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+
+	t.Run("operations", func(t *testing.T) {
+		nonce, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		_, _, err = attestation.GenerateKeypairFrom(sim, attestation.P256)
+		require.NoError(t, err)
+		_, _, err = attestation.GenerateKeypairFrom(sim, "DSA")
+		require.Error(t, err)
+		doc, err := attestation.RetrieveAttestationFrom(sim, nonce.Value, nil, nil)
+		require.NoError(t, err)
+
+		_, err = attestation.VerifyDocument(doc, attestation.VerifyOptions{Roots: sim.Roots(), Nonce: nonce})
+		require.NoError(t, err)
+		_, err = attestation.VerifyDocument(doc, attestation.VerifyOptions{Roots: sim.Roots(), Nonce: &attestation.Nonce{Value: []byte{1}}})
+		require.Error(t, err)
+		_, err = attestation.VerifyDocument(doc, attestation.VerifyOptions{
+			Roots:  sim.Roots(),
+			Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}},
+		})
+		require.Error(t, err)
+		_, err = attestation.VerifyDocument(doc, attestation.VerifyOptions{})
+		require.Error(t, err, "the document does not chain to the AWS root")
+		_, err = attestation.VerifyDocument([]byte("junk"), attestation.VerifyOptions{})
+		require.Error(t, err)
+
+		require.Equal(t, map[string]float64{
+			"create_nonce/success/none":            1,
+			"generate_keypair/success/none":        1,
+			"generate_keypair/failure/unsupported": 1,
+			"retrieve_attestation/success/none":    1,
+			"verify/success/none":                  1,
+			"verify/failure/nonce":                 1,
+			"verify/failure/policy":                1,
+			"verify/failure/certificate":           1,
+			"verify/failure/malformed":             1,
+			"revocation_check/success/none":        1,
+		}, counts(t, reg))
+	})
+
+	t.Run("NSM errors", func(t *testing.T) {
+		before := counts(t, reg)["retrieve_attestation/failure/nsm_error"]
+		_, err := attestation.RetrieveAttestationFrom(sim, make([]byte, 4096), nil, nil)
+		require.Error(t, err)
+		require.Equal(t, before+1, counts(t, reg)["retrieve_attestation/failure/nsm_error"])
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		Handler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, 200, rec.Code)
+		body, err := io.ReadAll(rec.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `attest_operations_total{error_class="none",operation="create_nonce",outcome="success"} 1`)
+		require.Contains(t, string(body), `attest_operation_duration_seconds_bucket{operation="verify",outcome="failure",le="+Inf"} 4`)
+	})
+
+	t.Run("no global registration", func(t *testing.T) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		require.NoError(t, err)
+		for _, family := range families {
+			require.NotContains(t, family.GetName(), Namespace+"_")
+		}
+		_, err = Install(reg)
+		require.Error(t, err, "registering twice with one registry fails")
+	})
+}
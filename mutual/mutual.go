@@ -0,0 +1,296 @@
+// Package mutual implements mutual attestation between two enclaves. Each side sends a
+// nonce and an ephemeral X25519 session key, each answers with an attestation document
+// carrying the peer's nonce, its own session key and the hash of both hellos, and each
+// checks the peer's document against its own policy. Both sides end with the same
+// session secret.
+//
+//	initiator -> responder: hello (version, nonce, session key)
+//	responder -> initiator: hello, document
+//	initiator -> responder: document
+//	responder -> initiator: finished (MAC proving the responder derived the secret)
+package mutual
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/protocol"
+	"time"
+)
+
+// Version is the protocol version spoken by this package.
+const Version = 1
+
+// Frame types.
+const (
+	frameHello    byte = 0x01
+	frameDocument byte = 0x02
+	frameFinished byte = 0x03
+	frameError    byte = 0x04
+)
+
+const (
+	nonceSize = 32
+	helloSize = 1 + nonceSize + 32
+	label     = "nitro-attest mutual v1"
+)
+
+// DefaultTimeout bounds the exchange when no timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
+// Peer is one side of a mutual attestation.
+type Peer struct {
+	// Session is this enclave's NSM session.
+	Session attestation.Session
+	// Options configure verification of the peer's document, in particular its roots
+	// and this side's policy for the peer. Options.Nonce is set by the exchange.
+	Options attestation.VerifyOptions
+	// Timeout bounds the exchange. Zero means DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Result is the outcome of a successful mutual attestation.
+type Result struct {
+	// Peer is the peer's verified attestation document.
+	Peer *nitrite.Document
+	// Secret is the 32 byte session secret shared with the peer.
+	Secret []byte
+}
+
+// hello is one side's opening message.
+type hello struct {
+	raw   []byte
+	nonce []byte
+	key   attestation.X25519PublicKey
+}
+
+func (p *Peer) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return p.Timeout
+}
+
+// newHello generates a session key and nonce from the NSM.
+func (p *Peer) newHello() (*hello, *attestation.X25519PrivateKey, []byte, error) {
+	der, xprv, err := attestation.GenerateKeypairFrom(p.Session, attestation.X25519)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	priv := xprv.(*attestation.X25519PrivateKey)
+	raw := make([]byte, helloSize)
+	raw[0] = Version
+	if _, err := io.ReadFull(p.Session, raw[1:1+nonceSize]); err != nil {
+		return nil, nil, nil, err
+	}
+	copy(raw[1+nonceSize:], priv.Public().(attestation.X25519PublicKey))
+	h, err := parseHello(raw)
+	return h, priv, der, err
+}
+
+func parseHello(raw []byte) (*hello, error) {
+	if len(raw) != helloSize {
+		return nil, errors.New("malformed hello")
+	}
+	if raw[0] != Version {
+		return nil, fmt.Errorf("unsupported protocol version %d", raw[0])
+	}
+	return &hello{raw: raw, nonce: raw[1 : 1+nonceSize], key: raw[1+nonceSize:]}, nil
+}
+
+// hellosHash binds documents to this exchange; it is their user_data.
+func hellosHash(initiator, responder *hello) []byte {
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(initiator.raw)
+	h.Write(responder.raw)
+	return h.Sum(nil)
+}
+
+// Initiate runs the exchange as the side that opened the connection.
+// Pre: Parameter conn is connected to a peer calling Respond.
+// Post: The Result or an error is returned. Errors reported by the peer start with
+// "peer error".
+func (p *Peer) Initiate(conn net.Conn) (*Result, error) {
+	if err := conn.SetDeadline(time.Now().Add(p.timeout())); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	mine, priv, der, err := p.newHello()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, frameHello, mine.raw); err != nil {
+		return nil, errors.Wrap(err, "could not send hello")
+	}
+	raw, err := expect(conn, frameHello)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := parseHello(raw)
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	binding := hellosHash(mine, theirs)
+	peerDoc, err := expect(conn, frameDocument)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.verifyPeer(peerDoc, mine.nonce, theirs, binding)
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	doc, err := attestation.RetrieveAttestationFrom(p.Session, theirs.nonce, binding, der)
+	if err != nil {
+		return nil, fail(conn, errors.Wrap(err, "attestation failed"))
+	}
+	if err := writeFrame(conn, frameDocument, doc); err != nil {
+		return nil, errors.Wrap(err, "could not send document")
+	}
+	secret, finished, err := deriveSecret(priv, theirs.key, mine, theirs, peerDoc, doc)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := expect(conn, frameFinished)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, finished) {
+		return nil, errors.New("bad finished message")
+	}
+	return &Result{Peer: res.Document, Secret: secret}, nil
+}
+
+// Respond runs the exchange as the side that accepted the connection.
+// Pre: Parameter conn is connected to a peer calling Initiate.
+// Post: The Result or an error is returned. Errors reported by the peer start with
+// "peer error".
+func (p *Peer) Respond(conn net.Conn) (*Result, error) {
+	if err := conn.SetDeadline(time.Now().Add(p.timeout())); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	raw, err := expect(conn, frameHello)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := parseHello(raw)
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	mine, priv, der, err := p.newHello()
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	binding := hellosHash(theirs, mine)
+	doc, err := attestation.RetrieveAttestationFrom(p.Session, theirs.nonce, binding, der)
+	if err != nil {
+		return nil, fail(conn, errors.Wrap(err, "attestation failed"))
+	}
+	if err := writeFrame(conn, frameHello, mine.raw); err != nil {
+		return nil, errors.Wrap(err, "could not send hello")
+	}
+	if err := writeFrame(conn, frameDocument, doc); err != nil {
+		return nil, errors.Wrap(err, "could not send document")
+	}
+	peerDoc, err := expect(conn, frameDocument)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.verifyPeer(peerDoc, mine.nonce, theirs, binding)
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	secret, finished, err := deriveSecret(priv, theirs.key, theirs, mine, doc, peerDoc)
+	if err != nil {
+		return nil, fail(conn, err)
+	}
+	if err := writeFrame(conn, frameFinished, finished); err != nil {
+		return nil, errors.Wrap(err, "could not send finished")
+	}
+	return &Result{Peer: res.Document, Secret: secret}, nil
+}
+
+// verifyPeer checks the peer's document: it must verify under this side's options, carry
+// this side's nonce and the hellos hash, and attest the peer's session key.
+func (p *Peer) verifyPeer(doc, nonce []byte, peer *hello, binding []byte) (*nitrite.Result, error) {
+	opts := p.Options
+	opts.Nonce = &attestation.Nonce{Value: nonce, Expiration: time.Now().Add(p.timeout())}
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "peer attestation rejected")
+	}
+	if !bytes.Equal(res.Document.UserData, binding) {
+		return nil, errors.New("peer attestation rejected: document is for another exchange")
+	}
+	key, _, err := attestation.ParsePublicKey(res.Document.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "peer attestation rejected")
+	}
+	if k, ok := key.(attestation.X25519PublicKey); !ok || !bytes.Equal(k, peer.key) {
+		return nil, errors.New("peer attestation rejected: session key is not attested")
+	}
+	return res, nil
+}
+
+// deriveSecret computes the session secret and the responder's finished MAC from the
+// X25519 shared secret and the full transcript.
+func deriveSecret(priv *attestation.X25519PrivateKey, peer attestation.X25519PublicKey, initiator, responder *hello, responderDoc, initiatorDoc []byte) ([]byte, []byte, error) {
+	shared, err := priv.SharedKey(peer)
+	if err != nil {
+		return nil, nil, errors.New("invalid peer session key")
+	}
+	transcript := sha256.New()
+	transcript.Write(hellosHash(initiator, responder))
+	transcript.Write(responderDoc)
+	transcript.Write(initiatorDoc)
+	th := transcript.Sum(nil)
+
+	kdf := hkdf.New(sha256.New, shared, th, []byte(label))
+	secret := make([]byte, 32)
+	finishedKey := make([]byte, 32)
+	if _, err := io.ReadFull(kdf, secret); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(kdf, finishedKey); err != nil {
+		return nil, nil, err
+	}
+	mac := hmac.New(sha256.New, finishedKey)
+	mac.Write(th)
+	return secret, mac.Sum(nil), nil
+}
+
+// fail reports err to the peer and returns it.
+func fail(conn net.Conn, err error) error {
+	writeFrame(conn, frameError, []byte(err.Error()))
+	return err
+}
+
+// expect reads a frame of the given type, turning error frames into errors.
+func expect(r io.Reader, want byte) ([]byte, error) {
+	prefix, payload, err := protocol.ReadFrame(r, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read from peer")
+	}
+	switch prefix[0] {
+	case want:
+		return payload, nil
+	case frameError:
+		return nil, fmt.Errorf("peer error: %s", payload)
+	}
+	return nil, fmt.Errorf("unexpected frame type %#02x", prefix[0])
+}
+
+// writeFrame writes a frame in the framing of package protocol.
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	return protocol.WriteFrame(w, []byte{typ}, payload)
+}
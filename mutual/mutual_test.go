@@ -0,0 +1,109 @@
+package mutual
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+)
+
+// exchange runs Initiate and Respond against each other over a pipe.
+func exchange(t *testing.T, initiator, responder *Peer) (*Result, *Result, error, error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	type outcome struct {
+		res *Result
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := responder.Respond(b)
+		done <- outcome{res, err}
+	}()
+	ires, ierr := initiator.Initiate(a)
+	r := <-done
+	return ires, r.res, ierr, r.err
+}
+
+func policy(index uint, value []byte) *attestation.Policy {
+	return &attestation.Policy{PCRs: map[uint]attestation.Measurement{index: value}}
+}
+
+func TestMutual(t *testing.T) {
+	pcrA := bytes.Repeat([]byte{0x0a}, 48)
+	pcrB := bytes.Repeat([]byte{0x0b}, 48)
+	simA, err := attestationtest.NewSimulator(map[uint][]byte{0: pcrA})
+	require.NoError(t, err)
+	simB, err := attestationtest.NewSimulator(map[uint][]byte{0: pcrB})
+	require.NoError(t, err)
+
+	peerA := func(expect []byte) *Peer {
+		return &Peer{Session: simA, Options: attestation.VerifyOptions{Roots: simB.Roots(), Policy: policy(0, expect)}}
+	}
+	peerB := func(expect []byte) *Peer {
+		return &Peer{Session: simB, Options: attestation.VerifyOptions{Roots: simA.Roots(), Policy: policy(0, expect)}}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		a, b, errA, errB := exchange(t, peerA(pcrB), peerB(pcrA))
+		require.NoError(t, errA)
+		require.NoError(t, errB)
+		require.Equal(t, simB.ModuleID, a.Peer.ModuleID)
+		require.Equal(t, simA.ModuleID, b.Peer.ModuleID)
+		require.Equal(t, pcrB, a.Peer.PCRs[0])
+		require.Equal(t, pcrA, b.Peer.PCRs[0])
+		require.Len(t, a.Secret, 32)
+		require.Equal(t, a.Secret, b.Secret)
+	})
+
+	t.Run("fresh secret per exchange", func(t *testing.T) {
+		a1, _, err, _ := exchange(t, peerA(pcrB), peerB(pcrA))
+		require.NoError(t, err)
+		a2, _, err, _ := exchange(t, peerA(pcrB), peerB(pcrA))
+		require.NoError(t, err)
+		require.NotEqual(t, a1.Secret, a2.Secret)
+	})
+
+	t.Run("initiator rejects responder", func(t *testing.T) {
+		_, _, errA, errB := exchange(t, peerA(pcrA), peerB(pcrA))
+		require.EqualError(t, errA, "peer attestation rejected: PCR0 mismatch")
+		require.EqualError(t, errB, "peer error: peer attestation rejected: PCR0 mismatch")
+	})
+
+	t.Run("responder rejects initiator", func(t *testing.T) {
+		_, _, errA, errB := exchange(t, peerA(pcrB), peerB(pcrB))
+		require.EqualError(t, errB, "peer attestation rejected: PCR0 mismatch")
+		require.EqualError(t, errA, "peer error: peer attestation rejected: PCR0 mismatch")
+	})
+
+	t.Run("untrusted roots", func(t *testing.T) {
+		a := &Peer{Session: simA, Options: attestation.VerifyOptions{Roots: simA.Roots()}}
+		_, _, errA, errB := exchange(t, a, peerB(pcrA))
+		require.Error(t, errA)
+		require.Contains(t, errB.Error(), "peer error: peer attestation rejected")
+	})
+
+	t.Run("document for another exchange", func(t *testing.T) {
+		b := peerB(pcrA)
+		mine, _, _, err := b.newHello()
+		require.NoError(t, err)
+		other, _, _, err := b.newHello()
+		require.NoError(t, err)
+		theirs, _, der, err := peerA(pcrB).newHello()
+		require.NoError(t, err)
+		doc, err := attestation.RetrieveAttestationFrom(simA, mine.nonce, hellosHash(theirs, other), der)
+		require.NoError(t, err)
+		_, err = b.verifyPeer(doc, mine.nonce, theirs, hellosHash(theirs, mine))
+		require.EqualError(t, err, "peer attestation rejected: document is for another exchange")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		raw := make([]byte, helloSize)
+		raw[0] = Version + 1
+		_, err := parseHello(raw)
+		require.EqualError(t, err, "unsupported protocol version 2")
+	})
+}
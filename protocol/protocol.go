@@ -0,0 +1,230 @@
+// Package protocol implements a framed challenge-response attestation exchange over any
+// io.ReadWriter. The client sends a fresh nonce, the server asks the NSM for a document
+// carrying that nonce, and the client verifies the document.
+//
+// Every frame is a one byte protocol version, a one byte frame type, a big-endian uint32
+// payload length and the payload. WriteFrame and ReadFrame implement this framing for
+// any prefix, so that packages channel and mutual share it with their own frame types.
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"io"
+	"nitro/attest/attestation"
+	"time"
+)
+
+// Version is the protocol version spoken by this package.
+const Version = 1
+
+// Frame types.
+const (
+	frameChallenge byte = 0x01 // payload: nonce
+	frameDocument  byte = 0x02 // payload: COSE attestation document
+	frameError     byte = 0x03 // payload: UTF-8 error message
+)
+
+// MaxFrameSize is the largest frame payload, ample for an attestation document.
+const MaxFrameSize = 1 << 16
+
+const (
+	// DefaultTimeout bounds an exchange when no timeout is configured.
+	DefaultTimeout = 10 * time.Second
+	// DefaultNonceLifetime is how long a client nonce is accepted.
+	DefaultNonceLifetime = 30 * time.Second
+)
+
+// RemoteError is an error reported by the peer in an error frame.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "peer error: " + e.Message
+}
+
+// deadliner is implemented by connections that support timeouts, such as net.Conn.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// setDeadline bounds the exchange on rw if it supports deadlines. The returned function
+// clears the deadline again.
+func setDeadline(rw io.ReadWriter, timeout time.Duration) (func(), error) {
+	d, ok := rw.(deadliner)
+	if !ok {
+		return func() {}, nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if err := d.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	return func() { d.SetDeadline(time.Time{}) }, nil
+}
+
+// FrameHeader encodes the header of a frame: the prefix identifying the frame, followed
+// by the payload size as a big-endian uint32.
+// Pre: Parameter size is at most MaxFrameSize.
+// Post: The header is returned.
+func FrameHeader(prefix []byte, size int) []byte {
+	h := make([]byte, len(prefix)+4)
+	copy(h, prefix)
+	binary.BigEndian.PutUint32(h[len(prefix):], uint32(size))
+	return h
+}
+
+// WriteFrame writes one frame with a single Write call.
+// Pre: None.
+// Post: The frame is written, or an error is returned if the payload exceeds
+// MaxFrameSize or the write fails.
+func WriteFrame(w io.Writer, prefix, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return errors.New("frame too large")
+	}
+	_, err := w.Write(append(FrameHeader(prefix, len(payload)), payload...))
+	return err
+}
+
+// ReadFrame reads one frame whose header starts with prefixSize bytes.
+// Pre: None.
+// Post: The prefix and payload are returned, or an error if the frame cannot be read or
+// announces a payload larger than MaxFrameSize.
+func ReadFrame(r io.Reader, prefixSize int) ([]byte, []byte, error) {
+	header := make([]byte, prefixSize+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[prefixSize:])
+	if size > MaxFrameSize {
+		return nil, nil, errors.New("frame too large")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	return header[:prefixSize], payload, nil
+}
+
+// writeFrame writes one frame of this protocol.
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	return WriteFrame(w, []byte{Version, typ}, payload)
+}
+
+// readFrame reads one frame of this protocol.
+func readFrame(r io.Reader) (byte, byte, []byte, error) {
+	prefix, payload, err := ReadFrame(r, 2)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return prefix[0], prefix[1], payload, nil
+}
+
+// Client is the verifying side of the exchange.
+type Client struct {
+	// Options configure document verification. Options.Nonce is set by Attest.
+	Options attestation.VerifyOptions
+	// NonceLifetime is how long the server has to answer. Zero means
+	// DefaultNonceLifetime.
+	NonceLifetime time.Duration
+	// Timeout bounds the exchange if the connection supports deadlines. Zero means
+	// DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Attest challenges the server on rw and verifies its answer.
+// Pre: Parameter rw is connected to a Server.
+// Post: The verified document is returned, or an error is returned. Errors reported by
+// the server are of type *RemoteError.
+func (c *Client) Attest(rw io.ReadWriter) (*nitrite.Result, error) {
+	reset, err := setDeadline(rw, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer reset()
+
+	lifetime := c.NonceLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultNonceLifetime
+	}
+	n, err := attestation.CreateNonce(lifetime)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(rw, frameChallenge, n.Value); err != nil {
+		return nil, errors.Wrap(err, "could not send challenge")
+	}
+	version, typ, payload, err := readFrame(rw)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response")
+	}
+	if version != Version {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	switch typ {
+	case frameDocument:
+	case frameError:
+		return nil, &RemoteError{Message: string(payload)}
+	default:
+		return nil, fmt.Errorf("unexpected frame type %#02x", typ)
+	}
+	opts := c.Options
+	opts.Nonce = n
+	return attestation.VerifyDocument(payload, opts)
+}
+
+// Server is the attesting side of the exchange, running inside the enclave.
+type Server struct {
+	// Session is the NSM session used to attest.
+	Session attestation.Session
+	// PublicKey, if set, is placed in every document.
+	PublicKey []byte
+	// UserData, if set, is placed in every document.
+	UserData []byte
+	// Timeout bounds the exchange if the connection supports deadlines. Zero means
+	// DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Serve answers one challenge on rw. Protocol violations and attestation failures are
+// reported to the client in an error frame as well as returned.
+// Pre: Parameter rw is connected to a Client.
+// Post: Nil is returned once a document was sent, otherwise an error is returned.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	reset, err := setDeadline(rw, s.Timeout)
+	if err != nil {
+		return err
+	}
+	defer reset()
+
+	version, typ, payload, err := readFrame(rw)
+	if err != nil {
+		return errors.Wrap(err, "could not read challenge")
+	}
+	if version != Version {
+		return s.fail(rw, fmt.Errorf("unsupported protocol version %d", version))
+	}
+	if typ != frameChallenge {
+		return s.fail(rw, fmt.Errorf("unexpected frame type %#02x", typ))
+	}
+	doc, err := attestation.RetrieveAttestationFrom(s.Session, payload, s.UserData, s.PublicKey)
+	if err != nil {
+		return s.fail(rw, errors.Wrap(err, "attestation failed"))
+	}
+	if err := writeFrame(rw, frameDocument, doc); err != nil {
+		return errors.Wrap(err, "could not send document")
+	}
+	return nil
+}
+
+// fail reports err to the client and returns it.
+func (s *Server) fail(w io.Writer, err error) error {
+	if werr := writeFrame(w, frameError, []byte(err.Error())); werr != nil {
+		return errors.Wrap(werr, "could not send error")
+	}
+	return err
+}
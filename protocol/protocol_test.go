@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"os"
+	"testing"
+	"time"
+)
+
+// serve runs one server exchange on a pipe and returns the client end.
+func serve(t *testing.T, s *Server) (net.Conn, <-chan error) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(server) }()
+	return client, done
+}
+
+func TestExchange(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0x01}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	client := &Client{Options: attestation.VerifyOptions{Roots: sim.Roots()}}
+
+	t.Run("success", func(t *testing.T) {
+		conn, done := serve(t, &Server{Session: sim, UserData: []byte("hello")})
+		res, err := client.Attest(conn)
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		require.Equal(t, []byte("hello"), res.Document.UserData)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+	})
+
+	t.Run("policy mismatch", func(t *testing.T) {
+		conn, done := serve(t, &Server{Session: sim})
+		strict := &Client{Options: attestation.VerifyOptions{
+			Roots:  sim.Roots(),
+			Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}},
+		}}
+		_, err := strict.Attest(conn)
+		require.EqualError(t, err, "PCR0 mismatch")
+		require.NoError(t, <-done)
+	})
+
+	t.Run("attestation failure", func(t *testing.T) {
+		conn, done := serve(t, &Server{Session: sim, UserData: make([]byte, 4096)})
+		_, err := client.Attest(conn)
+		var remote *RemoteError
+		require.True(t, errors.As(err, &remote))
+		require.Contains(t, remote.Message, "attestation failed")
+		require.Error(t, <-done)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		conn, done := serve(t, &Server{Session: sim})
+		go conn.Write([]byte{Version + 1, frameChallenge, 0, 0, 0, 0})
+		version, typ, payload, err := readFrame(conn)
+		require.NoError(t, err)
+		require.Equal(t, byte(Version), version)
+		require.Equal(t, frameError, typ)
+		require.Equal(t, "unsupported protocol version 2", string(payload))
+		require.EqualError(t, <-done, "unsupported protocol version 2")
+	})
+
+	t.Run("unexpected frame", func(t *testing.T) {
+		conn, done := serve(t, &Server{Session: sim})
+		go writeFrame(conn, frameDocument, []byte("doc"))
+		_, typ, _, err := readFrame(conn)
+		require.NoError(t, err)
+		require.Equal(t, frameError, typ)
+		require.EqualError(t, <-done, "unexpected frame type 0x02")
+	})
+
+	t.Run("oversized frame", func(t *testing.T) {
+		_, _, _, err := readFrame(bytes.NewReader([]byte{Version, frameDocument, 0xff, 0xff, 0xff, 0xff}))
+		require.EqualError(t, err, "frame too large")
+		require.EqualError(t, WriteFrame(io.Discard, []byte{0x01}, make([]byte, MaxFrameSize+1)), "frame too large")
+	})
+
+	t.Run("other prefixes", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrame(&buf, []byte{0x07}, []byte("payload")))
+		require.Equal(t, append(FrameHeader([]byte{0x07}, 7), "payload"...), buf.Bytes())
+		prefix, payload, err := ReadFrame(&buf, 1)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x07}, prefix)
+		require.Equal(t, "payload", string(payload))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		conn, server := net.Pipe()
+		defer conn.Close()
+		defer server.Close()
+		go readFrame(server) // accept the challenge, never answer
+		_, err := (&Client{Timeout: 50 * time.Millisecond}).Attest(conn)
+		require.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+	})
+
+	t.Run("stale document", func(t *testing.T) {
+		// A server replaying a document for another nonce is rejected.
+		conn, server := net.Pipe()
+		defer conn.Close()
+		defer server.Close()
+		doc, err := attestation.RetrieveAttestationFrom(sim, []byte("old nonce"), nil, nil)
+		require.NoError(t, err)
+		go func() {
+			readFrame(server)
+			writeFrame(server, frameDocument, doc)
+		}()
+		_, err = client.Attest(conn)
+		require.EqualError(t, err, "mismatched nonce")
+	})
+}
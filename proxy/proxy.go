@@ -0,0 +1,271 @@
+// Package proxy is the parent-side proxy into the enclave. It accepts TCP connections on
+// the parent instance and forwards each one, byte for byte, to the enclave over vsock, so
+// HTTP clients on the parent's network can reach a server running in the enclave.
+//
+// The upstream transport is a function, which lets tests forward to Unix sockets in place
+// of vsock.
+package proxy
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"nitro/attest/vsock"
+	"sync"
+	"time"
+)
+
+// DefaultIdleTimeout closes connections that carry no data in either direction for this
+// long.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// DefaultDialTimeout bounds connecting to the enclave in VsockDialer.
+const DefaultDialTimeout = 5 * time.Second
+
+// ErrProxyClosed is returned by Serve after Close.
+var ErrProxyClosed = errors.New("proxy closed")
+
+// Dialer connects to the upstream, normally the enclave.
+type Dialer func() (net.Conn, error)
+
+// VsockDialer returns a Dialer for port on the enclave with the given CID.
+// Pre: Parameter cid is the enclave's context identifier.
+// Post: The Dialer is returned.
+func VsockDialer(cid, port uint32) Dialer {
+	return func() (net.Conn, error) {
+		return vsock.Dial(cid, port, DefaultDialTimeout)
+	}
+}
+
+// Proxy forwards connections accepted on the parent instance to an upstream, normally
+// the enclave. The first call to Serve sizes the connection limit from MaxConns and
+// connection goroutines read the other fields without locking, so configure the proxy
+// before serving.
+type Proxy struct {
+	// Dial connects to the upstream for each accepted connection.
+	Dial Dialer
+	// MaxConns bounds concurrent connections; connections over the limit are closed on
+	// accept. Zero means no limit.
+	MaxConns int
+	// IdleTimeout closes idle connections. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// AccessLog receives one line per connection. If nil, access logs are discarded.
+	AccessLog *log.Logger
+
+	mu        sync.Mutex
+	closed    bool
+	sem       chan struct{}
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// Serve accepts connections on l and forwards each in its own goroutine.
+// Pre: Parameter l is a listener, such as a TCP listener on the parent instance.
+// Post: ErrProxyClosed is returned after Close, otherwise the error that stopped l.
+func (p *Proxy) Serve(l net.Listener) error {
+	if p.Dial == nil {
+		return errors.New("no upstream dialer configured")
+	}
+	if !p.track(l, nil) {
+		l.Close()
+		return ErrProxyClosed
+	}
+	defer p.untrack(l, nil)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return ErrProxyClosed
+			}
+			return err
+		}
+		if !p.acquire() {
+			p.logger().Printf("remote=%s status=rejected reason=%q", conn.RemoteAddr(), "connection limit reached")
+			conn.Close()
+			continue
+		}
+		if !p.track(nil, conn) {
+			p.release()
+			conn.Close()
+			return ErrProxyClosed
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.release()
+			defer p.untrack(nil, conn)
+			p.forward(conn)
+		}()
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+// Pre: Parameter addr is a host:port address on the parent instance.
+// Post: As for Serve, or the error from listening.
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Close stops all listeners and connections and waits for their goroutines.
+// Pre: None.
+// Post: Nil is returned once the proxy has stopped.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Proxy) track(l net.Listener, c net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	if p.listeners == nil {
+		p.listeners = map[net.Listener]struct{}{}
+		p.conns = map[net.Conn]struct{}{}
+	}
+	if p.sem == nil && p.MaxConns > 0 {
+		p.sem = make(chan struct{}, p.MaxConns)
+	}
+	if l != nil {
+		p.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		p.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (p *Proxy) untrack(l net.Listener, c net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.listeners, l)
+	delete(p.conns, c)
+	if c != nil {
+		c.Close()
+	}
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// acquire takes a connection slot without blocking.
+func (p *Proxy) acquire() bool {
+	if p.sem == nil {
+		return true
+	}
+	select {
+	case p.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Proxy) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+func (p *Proxy) logger() *log.Logger {
+	if p.AccessLog == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return p.AccessLog
+}
+
+func (p *Proxy) idleTimeout() time.Duration {
+	if p.IdleTimeout <= 0 {
+		return DefaultIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+// forward connects client to the upstream and copies in both directions until both are
+// done or the connection goes idle.
+func (p *Proxy) forward(client net.Conn) {
+	start := time.Now()
+	upstream, err := p.Dial()
+	if err != nil {
+		p.logger().Printf("remote=%s status=error reason=%q", client.RemoteAddr(), err.Error())
+		return
+	}
+	defer upstream.Close()
+
+	// Activity in either direction resets the shared timer; when it fires both sides are
+	// closed, which ends both copies.
+	var idled bool
+	var mu sync.Mutex
+	timer := time.AfterFunc(p.idleTimeout(), func() {
+		mu.Lock()
+		idled = true
+		mu.Unlock()
+		client.Close()
+		upstream.Close()
+	})
+	defer timer.Stop()
+	touch := func() { timer.Reset(p.idleTimeout()) }
+
+	var in, out int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out = pipe(client, upstream, touch)
+	}()
+	in = pipe(upstream, client, touch)
+	<-done
+
+	mu.Lock()
+	status := "closed"
+	if idled {
+		status = "idle"
+	}
+	mu.Unlock()
+	p.logger().Printf("remote=%s upstream=%s duration=%s in=%d out=%d status=%s",
+		client.RemoteAddr(), upstream.RemoteAddr(), time.Since(start).Round(time.Millisecond), in, out, status)
+}
+
+// pipe copies src to dst, calling touch after every read, and then half-closes dst if it
+// supports it, or closes it otherwise. It returns the number of bytes copied.
+func pipe(dst, src net.Conn, touch func()) int64 {
+	n, _ := io.Copy(dst, &activityReader{r: src, touch: touch})
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	return n
+}
+
+type activityReader struct {
+	r     io.Reader
+	touch func()
+}
+
+func (a *activityReader) Read(b []byte) (int, error) {
+	n, err := a.r.Read(b)
+	if n > 0 {
+		a.touch()
+	}
+	return n, err
+}
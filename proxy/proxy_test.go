@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a log destination safe for the proxy's goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// upstream serves h on a Unix socket standing in for the enclave's vsock port.
+func upstream(t *testing.T, h http.Handler) Dialer {
+	path := filepath.Join(t.TempDir(), "enclave.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: h}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return func() (net.Conn, error) {
+		return net.Dial("unix", path)
+	}
+}
+
+// start runs p on a local TCP port and returns its address.
+func start(t *testing.T, p *Proxy) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(l) }()
+	t.Cleanup(func() {
+		p.Close()
+		require.Equal(t, ErrProxyClosed, <-done)
+	})
+	return l.Addr().String()
+}
+
+func TestProxy(t *testing.T) {
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	})
+
+	t.Run("forwards http", func(t *testing.T) {
+		logs := &syncBuffer{}
+		p := &Proxy{Dial: upstream(t, hello), AccessLog: log.New(logs, "", 0)}
+		addr := start(t, p)
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := client.Get("http://" + addr + "/enclave")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "hello from /enclave", string(body))
+
+		require.Eventually(t, func() bool { return strings.Contains(logs.String(), "status=closed") }, time.Second, 10*time.Millisecond)
+		line := logs.String()
+		require.Contains(t, line, "remote=127.0.0.1:")
+		require.Contains(t, line, "enclave.sock")
+		require.NotContains(t, line, "in=0 ")
+		require.NotContains(t, line, "out=0 ")
+	})
+
+	t.Run("connection limit", func(t *testing.T) {
+		logs := &syncBuffer{}
+		p := &Proxy{Dial: upstream(t, hello), MaxConns: 1, AccessLog: log.New(logs, "", 0)}
+		addr := start(t, p)
+
+		held, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer held.Close()
+		// The held connection occupies the slot once the proxy has dialled upstream; a
+		// request on it proves that.
+		fmt.Fprint(held, "GET / HTTP/1.1\r\nHost: enclave\r\n\r\n")
+		buf := make([]byte, 12)
+		_, err = io.ReadFull(held, buf)
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/1.1 200", string(buf))
+
+		rejected, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer rejected.Close()
+		rejected.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = rejected.Read(make([]byte, 1))
+		require.Equal(t, io.EOF, err)
+		require.Contains(t, logs.String(), `status=rejected reason="connection limit reached"`)
+
+		held.Close()
+		require.Eventually(t, func() bool {
+			res, err := (&http.Client{Transport: &http.Transport{DisableKeepAlives: true}}).Get("http://" + addr + "/")
+			if err != nil {
+				return false
+			}
+			res.Body.Close()
+			return res.StatusCode == http.StatusOK
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		logs := &syncBuffer{}
+		p := &Proxy{Dial: upstream(t, hello), IdleTimeout: 50 * time.Millisecond, AccessLog: log.New(logs, "", 0)}
+		addr := start(t, p)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		require.Equal(t, io.EOF, err)
+		require.Eventually(t, func() bool { return strings.Contains(logs.String(), "status=idle") }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("upstream unavailable", func(t *testing.T) {
+		logs := &syncBuffer{}
+		dial := func() (net.Conn, error) {
+			return net.Dial("unix", filepath.Join(t.TempDir(), "missing.sock"))
+		}
+		p := &Proxy{Dial: dial, AccessLog: log.New(logs, "", 0)}
+		addr := start(t, p)
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		require.Equal(t, io.EOF, err)
+		require.Contains(t, logs.String(), "status=error")
+	})
+
+	t.Run("no dialer", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		require.EqualError(t, (&Proxy{}).Serve(l), "no upstream dialer configured")
+	})
+}
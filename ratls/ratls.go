@@ -0,0 +1,192 @@
+// Package ratls embeds attestation in TLS (RA-TLS). The enclave presents a self-signed
+// certificate carrying an attestation document whose public_key is the certificate's
+// SubjectPublicKeyInfo. Clients verify the document instead of a certificate chain, so a
+// standard TLS handshake also attests the enclave.
+//
+// The key is bound through public_key rather than user_data because the enclave never
+// attests a public key on request from outside, while user_data is caller controlled.
+package ratls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"math/big"
+	"nitro/attest/attestation"
+	"time"
+)
+
+// OIDAttestation identifies the certificate extension holding the COSE attestation
+// document. The arc is not registered; both sides of a deployment must agree on it, and
+// may set it to an identifier of their own organisation.
+var OIDAttestation = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 58270, 1, 1}
+
+// DefaultValidity is the lifetime of certificates when none is configured. Certificates
+// never outlive the NSM certificate that signed their attestation document, which lives
+// for a few hours, so servers must issue a new certificate before NotAfter.
+const DefaultValidity = 12 * time.Hour
+
+// CertOptions configure NewCertificate.
+type CertOptions struct {
+	// Algorithm of the TLS key. Zero means P-256. It must be able to sign.
+	Algorithm attestation.KeyAlgorithm
+	// DNSNames are placed in the certificate's subject alternative names.
+	DNSNames []string
+	// Validity is the certificate lifetime. Zero means DefaultValidity. It is cut short
+	// where the attestation document's certificate expires.
+	Validity time.Duration
+}
+
+// NewCertificate generates a TLS key inside the enclave and issues a self-signed RA-TLS
+// certificate for it.
+// Pre: Parameter sess is the enclave's NSM session.
+// Post: The certificate with its private key or an error is returned.
+func NewCertificate(sess attestation.Session, opts CertOptions) (*tls.Certificate, error) {
+	alg := opts.Algorithm
+	if alg == "" {
+		alg = attestation.P256
+	}
+	validity := opts.Validity
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	xpub, xprv, err := attestation.GenerateKeypairFrom(sess, alg)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := xprv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s keys cannot sign certificates", alg)
+	}
+	doc, err := attestation.RetrieveAttestationFrom(sess, nil, nil, xpub)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not attest tls key")
+	}
+	parsed, err := attestation.ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	docCert, err := x509.ParseCertificate(parsed.Certificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse nsm certificate")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if docCert.NotAfter.Before(notAfter) {
+		// Past this the document no longer verifies, and neither would the certificate.
+		notAfter = docCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: "nitro enclave"},
+		DNSNames:        opts.DNSNames,
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: OIDAttestation, Value: doc}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: signer, Leaf: leaf}, nil
+}
+
+// ExtractDocument returns the attestation document carried by a certificate.
+// Pre: Parameter cert is a parsed certificate.
+// Post: The COSE encoded document or an error is returned.
+func ExtractDocument(cert *x509.Certificate) ([]byte, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDAttestation) {
+			return ext.Value, nil
+		}
+	}
+	return nil, errors.New("certificate carries no attestation document")
+}
+
+// VerifyCertificate verifies an RA-TLS certificate: the embedded document must pass
+// verification and its public_key must be the certificate's key.
+// Pre: Parameter cert is the peer's leaf certificate. Parameter opts configures document
+// verification; opts.CurrentTime, if set, is also used for the certificate's validity.
+// Post: The document verification result or an error is returned.
+func VerifyCertificate(cert *x509.Certificate, opts attestation.VerifyOptions) (*nitrite.Result, error) {
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, errors.New("certificate has expired or is not yet valid")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return nil, errors.Wrap(err, "bad certificate signature")
+	}
+	doc, err := ExtractDocument(cert)
+	if err != nil {
+		return nil, err
+	}
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(res.Document.PublicKey, cert.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("certificate key is not the attested key")
+	}
+	return res, nil
+}
+
+// VerifyPeerCertificate returns a tls.Config.VerifyPeerCertificate callback that verifies
+// the peer's RA-TLS certificate.
+// Pre: Parameter opts configures document verification, including the policy.
+// Post: The callback is returned.
+func VerifyPeerCertificate(opts attestation.VerifyOptions) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer presented no certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		_, err = VerifyCertificate(cert, opts)
+		return err
+	}
+}
+
+// ClientConfig returns a TLS client configuration that accepts a server only if its
+// certificate attests an enclave satisfying opts. Chain verification is replaced by
+// attestation, hence InsecureSkipVerify.
+// Pre: Parameter opts configures document verification.
+// Post: The configuration is returned.
+func ClientConfig(opts attestation.VerifyOptions) *tls.Config {
+	return &tls.Config{
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: VerifyPeerCertificate(opts),
+	}
+}
+
+// ServerConfig returns a TLS server configuration presenting an RA-TLS certificate.
+// Pre: Parameter cert is from NewCertificate.
+// Post: The configuration is returned.
+func ServerConfig(cert *tls.Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+}
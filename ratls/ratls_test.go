@@ -0,0 +1,158 @@
+package ratls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+	"time"
+)
+
+// dial runs a TLS handshake over a pipe and returns the client side's error.
+func dial(t *testing.T, server, client *tls.Config) error {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- tls.Server(b, server).Handshake()
+		b.Close()
+	}()
+	err := tls.Client(a, client).Handshake()
+	a.Close()
+	<-done
+	return err
+}
+
+func TestRATLS(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0x03}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	policy := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}}
+	cert, err := NewCertificate(sim, CertOptions{DNSNames: []string{"enclave.local"}})
+	require.NoError(t, err)
+
+	t.Run("handshake", func(t *testing.T) {
+		err := dial(t, ServerConfig(cert), ClientConfig(attestation.VerifyOptions{Roots: sim.Roots(), Policy: policy}))
+		require.NoError(t, err)
+	})
+
+	t.Run("certificate", func(t *testing.T) {
+		res, err := VerifyCertificate(cert.Leaf, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.NoError(t, err)
+		require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+		require.Equal(t, []string{"enclave.local"}, cert.Leaf.DNSNames)
+	})
+
+	t.Run("policy mismatch", func(t *testing.T) {
+		strict := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}}
+		err := dial(t, ServerConfig(cert), ClientConfig(attestation.VerifyOptions{Roots: sim.Roots(), Policy: strict}))
+		require.EqualError(t, err, "PCR0 mismatch")
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		ca, err := attestationtest.NewCA()
+		require.NoError(t, err)
+		err = dial(t, ServerConfig(cert), ClientConfig(attestation.VerifyOptions{Roots: ca.Roots()}))
+		require.Error(t, err)
+	})
+
+	t.Run("document for another key", func(t *testing.T) {
+		doc, err := ExtractDocument(cert.Leaf)
+		require.NoError(t, err)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			NotBefore:       time.Now().Add(-time.Minute),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtraExtensions: []pkix.Extension{{Id: OIDAttestation, Value: doc}},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		forged := &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+		err = dial(t, ServerConfig(forged), ClientConfig(attestation.VerifyOptions{Roots: sim.Roots()}))
+		require.EqualError(t, err, "certificate key is not the attested key")
+	})
+
+	t.Run("key only in user data", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		digest := sha256.Sum256(spki)
+		doc, err := attestation.RetrieveAttestationFrom(sim, nil, digest[:], nil)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			NotBefore:       time.Now().Add(-time.Minute),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtraExtensions: []pkix.Extension{{Id: OIDAttestation, Value: doc}},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		forged, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		_, err = VerifyCertificate(forged, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.EqualError(t, err, "certificate key is not the attested key")
+	})
+
+	t.Run("plain certificate", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		plain, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		_, err = VerifyCertificate(plain, attestation.VerifyOptions{Roots: sim.Roots()})
+		require.EqualError(t, err, "certificate carries no attestation document")
+	})
+
+	t.Run("expired certificate", func(t *testing.T) {
+		_, err := VerifyCertificate(cert.Leaf, attestation.VerifyOptions{
+			Roots:       sim.Roots(),
+			CurrentTime: time.Now().Add(DefaultValidity + time.Hour),
+		})
+		require.EqualError(t, err, "certificate has expired or is not yet valid")
+	})
+
+	t.Run("lifetime capped by the nsm certificate", func(t *testing.T) {
+		doc, err := ExtractDocument(cert.Leaf)
+		require.NoError(t, err)
+		parsed, err := attestation.ParseDocument(doc)
+		require.NoError(t, err)
+		nsmCert, err := x509.ParseCertificate(parsed.Certificate)
+		require.NoError(t, err)
+		require.True(t, nsmCert.NotAfter.Before(time.Now().Add(DefaultValidity)))
+		require.Equal(t, nsmCert.NotAfter, cert.Leaf.NotAfter)
+
+		_, err = VerifyCertificate(cert.Leaf, attestation.VerifyOptions{
+			Roots:       sim.Roots(),
+			CurrentTime: cert.Leaf.NotAfter.Add(-time.Minute),
+		})
+		require.NoError(t, err, "the certificate verifies until its NotAfter")
+		short, err := NewCertificate(sim, CertOptions{Validity: time.Hour})
+		require.NoError(t, err)
+		require.True(t, short.Leaf.NotAfter.Before(nsmCert.NotAfter))
+	})
+
+	t.Run("cannot sign", func(t *testing.T) {
+		_, err := NewCertificate(sim, CertOptions{Algorithm: attestation.X25519})
+		require.EqualError(t, err, "X25519 keys cannot sign certificates")
+	})
+}
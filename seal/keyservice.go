@@ -0,0 +1,207 @@
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"nitro/attest/attestation"
+	"sync"
+)
+
+// dataKeySize is the length of AES-256 data and master keys.
+const dataKeySize = 32
+
+// KeyService releases data keys to attested enclaves. It models AWS KMS with a key
+// policy conditioned on PCRs: plaintext key material only ever leaves the service
+// encrypted to the public key of a verified attestation document.
+type KeyService interface {
+	// KeyPolicy returns the PCR policy enforced for keyID.
+	KeyPolicy(keyID string) (*attestation.Policy, error)
+	// GenerateDataKey creates a data key under keyID. It returns the data key wrapped by
+	// keyID and the plaintext data key encrypted to the public key in attestationDoc.
+	GenerateDataKey(keyID string, attestationDoc []byte) (wrapped, forRecipient []byte, err error)
+	// Decrypt unwraps a data key wrapped by keyID and returns it encrypted to the public
+	// key in attestationDoc.
+	Decrypt(keyID string, wrapped, attestationDoc []byte) ([]byte, error)
+	// Encrypt wraps a caller supplied data key with keyID. Like KMS Encrypt it needs no
+	// attestation, since the caller already holds the plaintext.
+	Encrypt(keyID string, dataKey []byte) ([]byte, error)
+}
+
+// masterKey is a key held by LocalKeyService together with its release policy.
+type masterKey struct {
+	key    []byte
+	policy attestation.Policy
+}
+
+// LocalKeyService is an in-memory KeyService for development and tests.
+type LocalKeyService struct {
+	roots *x509.CertPool
+
+	mu   sync.Mutex
+	keys map[string]*masterKey
+}
+
+// NewLocalKeyService creates an empty LocalKeyService.
+// Pre: Parameter roots are the trusted attestation roots; nil selects the AWS root.
+// Post: A LocalKeyService is returned.
+func NewLocalKeyService(roots *x509.CertPool) *LocalKeyService {
+	return &LocalKeyService{
+		roots: roots,
+		keys:  make(map[string]*masterKey),
+	}
+}
+
+// CreateKey creates a master key that is only released to enclaves satisfying policy.
+// The service keeps its own copy of policy, so later changes by the caller have no effect.
+// Pre: Parameter policy names at least one PCR.
+// Post: The new key identifier or an error is returned.
+func (s *LocalKeyService) CreateKey(policy attestation.Policy) (string, error) {
+	if len(policy.PCRs) == 0 {
+		return "", errors.New("key policy must name at least one PCR")
+	}
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+	keyID := hex.EncodeToString(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = &masterKey{key: key, policy: *policy.Clone()}
+	return keyID, nil
+}
+
+// KeyPolicy returns a copy of the PCR policy enforced for keyID.
+func (s *LocalKeyService) KeyPolicy(keyID string) (*attestation.Policy, error) {
+	mk, err := s.lookup(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return mk.policy.Clone(), nil
+}
+
+// GenerateDataKey creates a data key under keyID for the enclave in attestationDoc.
+func (s *LocalKeyService) GenerateDataKey(keyID string, attestationDoc []byte) ([]byte, []byte, error) {
+	mk, recipient, err := s.authorize(keyID, attestationDoc)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := encrypt(mk.key, dataKey, []byte(keyID))
+	if err != nil {
+		return nil, nil, err
+	}
+	forRecipient, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, dataKey, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrapped, forRecipient, nil
+}
+
+// Decrypt unwraps a data key for the enclave in attestationDoc.
+func (s *LocalKeyService) Decrypt(keyID string, wrapped, attestationDoc []byte) ([]byte, error) {
+	mk, recipient, err := s.authorize(keyID, attestationDoc)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := decrypt(mk.key, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unwrap data key")
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, dataKey, nil)
+}
+
+// Encrypt wraps dataKey with keyID.
+func (s *LocalKeyService) Encrypt(keyID string, dataKey []byte) ([]byte, error) {
+	mk, err := s.lookup(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(mk.key, dataKey, []byte(keyID))
+}
+
+// lookup finds the master key for keyID.
+func (s *LocalKeyService) lookup(keyID string) (*masterKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mk, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return mk, nil
+}
+
+// authorize verifies attestationDoc against the policy of keyID.
+// Pre: Parameter keyID names a master key. Parameter attestationDoc is a raw document
+// carrying an RSA public key.
+// Post: The master key and the recipient public key are returned, or an error is returned.
+func (s *LocalKeyService) authorize(keyID string, attestationDoc []byte) (*masterKey, *rsa.PublicKey, error) {
+	mk, err := s.lookup(keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := attestation.VerifyDocument(attestationDoc, attestation.VerifyOptions{
+		Roots:  s.roots,
+		Policy: &mk.policy,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "key release denied")
+	}
+	pub, _, err := attestation.ParsePublicKey(res.Document.PublicKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "key release denied")
+	}
+	recipient, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("key release denied: attestation public key is not RSA")
+	}
+	return mk, recipient, nil
+}
+
+// encrypt seals plaintext under key with AES-GCM, prefixing the random nonce.
+func encrypt(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// decrypt opens a ciphertext produced by encrypt.
+func decrypt(key, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], aad)
+}
+
+// newGCM creates an AES-GCM AEAD for key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,192 @@
+package seal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+)
+
+// Release announces a new enclave build that sealed data may be migrated to.
+type Release struct {
+	// Name identifies the build, for example a version tag.
+	Name string `json:"name"`
+	// KeyID names the KeyService key bound to the new build's PCRs.
+	KeyID string `json:"key_id"`
+	// Policy is the PCR policy of the new build.
+	Policy attestation.Policy `json:"policy"`
+}
+
+// SignedRelease is a Release signed by the release authority.
+type SignedRelease struct {
+	// Payload is the JSON encoded Release.
+	Payload []byte `json:"payload"`
+	// Signature is the authority's signature over Payload.
+	Signature []byte `json:"signature"`
+}
+
+// MigrationRequest is the new enclave's answer to a migration challenge.
+type MigrationRequest struct {
+	// AttestationDoc is bound to the challenge nonce and, through its user data, to the
+	// release.
+	AttestationDoc []byte `json:"attestation_doc"`
+	// Release is the signed release the new enclave claims to belong to.
+	Release *SignedRelease `json:"release"`
+}
+
+// SignRelease signs a release with the release authority's key.
+// Pre: Parameter release describes the new build. Parameter signer is an ECDSA, Ed25519
+// or RSA key.
+// Post: The SignedRelease or an error is returned.
+func SignRelease(release *Release, signer crypto.Signer) (*SignedRelease, error) {
+	payload, err := json.Marshal(release)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		signature, err = signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(payload)
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SignedRelease{Payload: payload, Signature: signature}, nil
+}
+
+// VerifyRelease checks a release signature.
+// Pre: Parameter signed is a SignedRelease. Parameter authority is the release
+// authority's public key.
+// Post: The Release or an error is returned.
+func VerifyRelease(signed *SignedRelease, authority crypto.PublicKey) (*Release, error) {
+	if signed == nil {
+		return nil, errors.New("missing release")
+	}
+	digest := sha256.Sum256(signed.Payload)
+	var ok bool
+	switch pub := authority.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, signed.Payload, signed.Signature)
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest[:], signed.Signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signed.Signature) == nil
+	default:
+		return nil, fmt.Errorf("unsupported release authority key %T", authority)
+	}
+	if !ok {
+		return nil, errors.New("invalid release signature")
+	}
+	release := &Release{}
+	if err := json.Unmarshal(signed.Payload, release); err != nil {
+		return nil, errors.Wrap(err, "invalid release")
+	}
+	return release, nil
+}
+
+// RequestMigration answers a migration challenge on the new enclave.
+// Pre: Parameter sess is the new enclave's NSM session. Parameter challenge is the nonce
+// issued by the old enclave. Parameter release is the new build's signed release.
+// Post: A MigrationRequest or an error is returned.
+func RequestMigration(sess attestation.Session, challenge []byte, release *SignedRelease) (*MigrationRequest, error) {
+	if release == nil {
+		return nil, errors.New("missing release")
+	}
+	digest := sha256.Sum256(release.Payload)
+	doc, err := attestation.RetrieveAttestationFrom(sess, challenge, digest[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return &MigrationRequest{AttestationDoc: doc, Release: release}, nil
+}
+
+// Migrator runs the old enclave's side of a migration.
+type Migrator struct {
+	// Session is the old enclave's NSM session.
+	Session attestation.Session
+	// Keys is the key release service holding both the old and the new keys.
+	Keys KeyService
+	// Authority is the public key that signs releases.
+	Authority crypto.PublicKey
+	// Roots are the trusted attestation roots; nil selects the AWS root.
+	Roots *x509.CertPool
+}
+
+// Migrate re-wraps sealed blobs for the new enclave build described by req.
+// Pre: Parameter challenge is the nonce previously sent to the new enclave. Parameter req
+// is the new enclave's answer. Parameter blobs were sealed for the old enclave.
+// Post: Blobs sealed under the release's key and policy are returned, in the same order,
+// or an error is returned and nothing is migrated.
+func (m *Migrator) Migrate(challenge *attestation.Nonce, req *MigrationRequest, blobs [][]byte) ([][]byte, error) {
+	release, err := VerifyRelease(req.Release, m.Authority)
+	if err != nil {
+		return nil, err
+	}
+	res, err := attestation.VerifyDocument(req.AttestationDoc, attestation.VerifyOptions{
+		Roots:  m.Roots,
+		Nonce:  challenge,
+		Policy: &release.Policy,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "new enclave attestation rejected")
+	}
+	digest := sha256.Sum256(req.Release.Payload)
+	if !bytes.Equal(res.Document.UserData, digest[:]) {
+		return nil, errors.New("new enclave attestation is not bound to the release")
+	}
+	keyPolicy, err := m.Keys.KeyPolicy(release.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if !keyPolicy.Equal(&release.Policy) {
+		return nil, errors.New("release policy does not match the policy of its key")
+	}
+	xprv, doc, err := attestRecipient(m.Session)
+	if err != nil {
+		return nil, err
+	}
+	migrated := make([][]byte, 0, len(blobs))
+	for i, blob := range blobs {
+		header, aad, ciphertext, err := split(blob)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d", i)
+		}
+		forRecipient, err := m.Keys.Decrypt(header.KeyID, header.WrappedKey, doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d", i)
+		}
+		dataKey, err := openForRecipient(xprv, forRecipient)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d", i)
+		}
+		plaintext, err := decrypt(dataKey, ciphertext, aad)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d: could not unseal", i)
+		}
+		wrapped, err := m.Keys.Encrypt(release.KeyID, dataKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d", i)
+		}
+		blob, err := assemble(&Header{
+			Version:    Version,
+			KeyID:      release.KeyID,
+			Policy:     release.Policy,
+			WrappedKey: wrapped,
+		}, dataKey, plaintext)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blob %d", i)
+		}
+		migrated = append(migrated, blob)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,128 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	oldPCR := bytes.Repeat([]byte{0x01}, 48)
+	newPCR := bytes.Repeat([]byte{0x02}, 48)
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	oldEnclave, err := ca.NewSimulator(map[uint][]byte{0: oldPCR})
+	require.NoError(t, err)
+	newEnclave, err := ca.NewSimulator(map[uint][]byte{0: newPCR})
+	require.NoError(t, err)
+
+	keys := NewLocalKeyService(ca.Roots())
+	oldPolicy := attestation.Policy{PCRs: map[uint]attestation.Measurement{0: oldPCR}}
+	newPolicy := attestation.Policy{PCRs: map[uint]attestation.Measurement{0: newPCR}}
+	oldKey, err := keys.CreateKey(oldPolicy)
+	require.NoError(t, err)
+	newKey, err := keys.CreateKey(newPolicy)
+	require.NoError(t, err)
+
+	authorityPub, authority, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	release, err := SignRelease(&Release{Name: "v2", KeyID: newKey, Policy: newPolicy}, authority)
+	require.NoError(t, err)
+
+	first, err := Seal(oldEnclave, keys, oldKey, []byte("first secret"))
+	require.NoError(t, err)
+	second, err := Seal(oldEnclave, keys, oldKey, []byte("second secret"))
+	require.NoError(t, err)
+
+	migrator := &Migrator{
+		Session:   oldEnclave,
+		Keys:      keys,
+		Authority: authorityPub,
+		Roots:     ca.Roots(),
+	}
+
+	// handshake runs one challenge-response exchange, passing messages through JSON as
+	// they would travel between enclaves.
+	handshake := func(t *testing.T, enclave attestation.Session, release *SignedRelease) ([][]byte, error) {
+		challenge, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		req, err := RequestMigration(enclave, challenge.Value, release)
+		require.NoError(t, err)
+		enc, err := json.Marshal(req)
+		require.NoError(t, err)
+		received := &MigrationRequest{}
+		require.NoError(t, json.Unmarshal(enc, received))
+		return migrator.Migrate(challenge, received, [][]byte{first, second})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		migrated, err := handshake(t, newEnclave, release)
+		require.NoError(t, err)
+		require.Len(t, migrated, 2)
+
+		header, err := ParseHeader(migrated[0])
+		require.NoError(t, err)
+		require.Equal(t, newKey, header.KeyID)
+		require.True(t, header.Policy.Equal(&newPolicy))
+
+		plaintext, err := Unseal(newEnclave, keys, migrated[0])
+		require.NoError(t, err)
+		require.Equal(t, []byte("first secret"), plaintext)
+		plaintext, err = Unseal(newEnclave, keys, migrated[1])
+		require.NoError(t, err)
+		require.Equal(t, []byte("second secret"), plaintext)
+
+		_, err = Unseal(oldEnclave, keys, migrated[0])
+		require.EqualError(t, err, "key release denied: PCR0 mismatch")
+	})
+
+	t.Run("enclave outside the release", func(t *testing.T) {
+		rogue, err := ca.NewSimulator(map[uint][]byte{0: bytes.Repeat([]byte{0x03}, 48)})
+		require.NoError(t, err)
+		_, err = handshake(t, rogue, release)
+		require.EqualError(t, err, "new enclave attestation rejected: PCR0 mismatch")
+	})
+
+	t.Run("unsigned release", func(t *testing.T) {
+		_, impostor, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		forged, err := SignRelease(&Release{Name: "v2", KeyID: newKey, Policy: newPolicy}, impostor)
+		require.NoError(t, err)
+		_, err = handshake(t, newEnclave, forged)
+		require.EqualError(t, err, "invalid release signature")
+	})
+
+	t.Run("release key with another policy", func(t *testing.T) {
+		mismatched, err := SignRelease(&Release{Name: "v2", KeyID: oldKey, Policy: newPolicy}, authority)
+		require.NoError(t, err)
+		_, err = handshake(t, newEnclave, mismatched)
+		require.EqualError(t, err, "release policy does not match the policy of its key")
+	})
+
+	t.Run("stale challenge", func(t *testing.T) {
+		challenge, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		req, err := RequestMigration(newEnclave, []byte("replayed"), release)
+		require.NoError(t, err)
+		_, err = migrator.Migrate(challenge, req, [][]byte{first})
+		require.EqualError(t, err, "new enclave attestation rejected: mismatched nonce")
+	})
+
+	t.Run("attestation bound to another release", func(t *testing.T) {
+		challenge, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		other, err := SignRelease(&Release{Name: "v3", KeyID: newKey, Policy: newPolicy}, authority)
+		require.NoError(t, err)
+		req, err := RequestMigration(newEnclave, challenge.Value, other)
+		require.NoError(t, err)
+		req.Release = release
+		_, err = migrator.Migrate(challenge, req, [][]byte{first})
+		require.EqualError(t, err, "new enclave attestation is not bound to the release")
+	})
+}
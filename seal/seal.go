@@ -0,0 +1,171 @@
+// Package seal encrypts enclave secrets for storage on the untrusted parent instance.
+// Sealed data is encrypted with AES-GCM under a data key that a KeyService only releases
+// to enclaves whose attested PCRs satisfy the key's policy. When a new enclave build
+// ships, a Migrator in the old enclave re-wraps sealed data for the new build's PCRs.
+package seal
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+)
+
+// Version is the sealed blob format written by Seal.
+const Version = 1
+
+// magic identifies a sealed blob.
+var magic = []byte("NSEAL")
+
+// maxHeaderSize bounds the header length accepted by ParseHeader.
+const maxHeaderSize = 1 << 16
+
+// Header is the authenticated, unencrypted prefix of a sealed blob.
+type Header struct {
+	// Version is the blob format version.
+	Version uint8 `json:"-"`
+	// KeyID names the KeyService key that wraps the data key.
+	KeyID string `json:"key_id"`
+	// Policy is the PCR policy the key service enforces for KeyID.
+	Policy attestation.Policy `json:"policy"`
+	// WrappedKey is the data key wrapped by KeyID.
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// Seal encrypts plaintext so that only an enclave satisfying the policy of keyID can
+// recover it.
+// Pre: Parameter sess is the enclave's NSM session. Parameter keys is the key release
+// service. Parameter keyID names a key whose policy the calling enclave satisfies.
+// Post: The sealed blob or an error is returned.
+func Seal(sess attestation.Session, keys KeyService, keyID string, plaintext []byte) ([]byte, error) {
+	policy, err := keys.KeyPolicy(keyID)
+	if err != nil {
+		return nil, err
+	}
+	xprv, doc, err := attestRecipient(sess)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, forRecipient, err := keys.GenerateDataKey(keyID, doc)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openForRecipient(xprv, forRecipient)
+	if err != nil {
+		return nil, err
+	}
+	header := &Header{
+		Version:    Version,
+		KeyID:      keyID,
+		Policy:     *policy,
+		WrappedKey: wrapped,
+	}
+	return assemble(header, dataKey, plaintext)
+}
+
+// Unseal recovers the plaintext of a sealed blob.
+// Pre: Parameter sess is the enclave's NSM session. Parameter keys is the key release
+// service. Parameter blob was produced by Seal.
+// Post: The plaintext or an error is returned.
+func Unseal(sess attestation.Session, keys KeyService, blob []byte) ([]byte, error) {
+	header, aad, ciphertext, err := split(blob)
+	if err != nil {
+		return nil, err
+	}
+	xprv, doc, err := attestRecipient(sess)
+	if err != nil {
+		return nil, err
+	}
+	forRecipient, err := keys.Decrypt(header.KeyID, header.WrappedKey, doc)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openForRecipient(xprv, forRecipient)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decrypt(dataKey, ciphertext, aad)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unseal")
+	}
+	return plaintext, nil
+}
+
+// ParseHeader reads the header of a sealed blob without decrypting it.
+// Pre: Parameter blob was produced by Seal.
+// Post: The header or an error is returned.
+func ParseHeader(blob []byte) (*Header, error) {
+	header, _, _, err := split(blob)
+	return header, err
+}
+
+// attestRecipient creates an ephemeral RSA keypair and an attestation document binding
+// its public key, which the key service encrypts data keys to.
+func attestRecipient(sess attestation.Session) (crypto.Decrypter, []byte, error) {
+	xpub, xprv, err := attestation.GenerateKeypairFrom(sess, attestation.RSA)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := attestation.RetrieveAttestationFrom(sess, nil, nil, xpub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return xprv.(crypto.Decrypter), doc, nil
+}
+
+// openForRecipient decrypts a data key the key service encrypted to xprv.
+func openForRecipient(xprv crypto.Decrypter, forRecipient []byte) ([]byte, error) {
+	dataKey, err := xprv.Decrypt(rand.Reader, forRecipient, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decrypt data key")
+	}
+	return dataKey, nil
+}
+
+// assemble encodes the header and encrypts plaintext, authenticating the header.
+// The layout is magic, version, big-endian uint32 header length, JSON header, then the
+// AES-GCM nonce and ciphertext.
+func assemble(header *Header, dataKey, plaintext []byte) ([]byte, error) {
+	enc, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.WriteByte(header.Version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(enc)))
+	buf.Write(enc)
+	ciphertext, err := encrypt(dataKey, plaintext, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(ciphertext)
+	return buf.Bytes(), nil
+}
+
+// split parses a sealed blob into its header, the authenticated prefix and the
+// ciphertext.
+func split(blob []byte) (*Header, []byte, []byte, error) {
+	prefix := len(magic) + 1 + 4
+	if len(blob) < prefix || !bytes.Equal(blob[:len(magic)], magic) {
+		return nil, nil, nil, errors.New("not a sealed blob")
+	}
+	version := blob[len(magic)]
+	if version != Version {
+		return nil, nil, nil, fmt.Errorf("unsupported sealed blob version %d", version)
+	}
+	size := binary.BigEndian.Uint32(blob[len(magic)+1 : prefix])
+	if size > maxHeaderSize || int(size) > len(blob)-prefix {
+		return nil, nil, nil, errors.New("truncated sealed blob header")
+	}
+	header := &Header{Version: version}
+	if err := json.Unmarshal(blob[prefix:prefix+int(size)], header); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "invalid sealed blob header")
+	}
+	return header, blob[:prefix+int(size)], blob[prefix+int(size):], nil
+}
@@ -0,0 +1,112 @@
+package seal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"testing"
+)
+
+func TestSealUnseal(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0x11}, 48)
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	enclave, err := ca.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	keys := NewLocalKeyService(ca.Roots())
+	keyID, err := keys.CreateKey(attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}})
+	require.NoError(t, err)
+
+	blob, err := Seal(enclave, keys, keyID, []byte("top secret"))
+	require.NoError(t, err)
+	require.NotContains(t, string(blob), "top secret")
+
+	t.Run("round trip", func(t *testing.T) {
+		plaintext, err := Unseal(enclave, keys, blob)
+		require.NoError(t, err)
+		require.Equal(t, []byte("top secret"), plaintext)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		header, err := ParseHeader(blob)
+		require.NoError(t, err)
+		require.Equal(t, uint8(Version), header.Version)
+		require.Equal(t, keyID, header.KeyID)
+		require.Equal(t, attestation.Measurement(pcr0), header.Policy.PCRs[0])
+	})
+
+	t.Run("different enclave", func(t *testing.T) {
+		other, err := ca.NewSimulator(map[uint][]byte{0: bytes.Repeat([]byte{0x22}, 48)})
+		require.NoError(t, err)
+		_, err = Unseal(other, keys, blob)
+		require.EqualError(t, err, "key release denied: PCR0 mismatch")
+		_, err = Seal(other, keys, keyID, []byte("x"))
+		require.EqualError(t, err, "key release denied: PCR0 mismatch")
+	})
+
+	t.Run("untrusted attestation root", func(t *testing.T) {
+		impostor, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+		require.NoError(t, err)
+		_, err = Unseal(impostor, keys, blob)
+		require.Error(t, err)
+	})
+
+	t.Run("tampered header", func(t *testing.T) {
+		tampered := bytes.Replace(blob, []byte(`"key_id"`), []byte(`"key_iD"`), 1)
+		_, err := Unseal(enclave, keys, tampered)
+		require.Error(t, err)
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		tampered := append([]byte(nil), blob...)
+		tampered[len(tampered)-1] ^= 1
+		_, err := Unseal(enclave, keys, tampered)
+		require.EqualError(t, err, "could not unseal: cipher: message authentication failed")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		tampered := append([]byte(nil), blob...)
+		tampered[len(magic)] = 9
+		_, err := Unseal(enclave, keys, tampered)
+		require.EqualError(t, err, "unsupported sealed blob version 9")
+	})
+
+	t.Run("not a blob", func(t *testing.T) {
+		_, err := ParseHeader([]byte("hello"))
+		require.EqualError(t, err, "not a sealed blob")
+	})
+}
+
+func TestLocalKeyService(t *testing.T) {
+	t.Run("empty policy", func(t *testing.T) {
+		_, err := NewLocalKeyService(nil).CreateKey(attestation.Policy{})
+		require.EqualError(t, err, "key policy must name at least one PCR")
+	})
+
+	t.Run("policies are copied", func(t *testing.T) {
+		pcr0 := bytes.Repeat([]byte{0x11}, 48)
+		keys := NewLocalKeyService(nil)
+		policy := attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}}
+		keyID, err := keys.CreateKey(policy)
+		require.NoError(t, err)
+		policy.PCRs[0] = make([]byte, 48)
+		policy.PCRs[1] = pcr0
+		pcr0[0] = 0
+
+		got, err := keys.KeyPolicy(keyID)
+		require.NoError(t, err)
+		want := map[uint]attestation.Measurement{0: bytes.Repeat([]byte{0x11}, 48)}
+		require.Equal(t, want, got.PCRs, "the caller's policy is not shared")
+		got.PCRs[0][0] = 0
+		delete(got.PCRs, 0)
+		again, err := keys.KeyPolicy(keyID)
+		require.NoError(t, err)
+		require.Equal(t, want, again.PCRs, "the returned policy is not shared")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, err := NewLocalKeyService(nil).KeyPolicy("missing")
+		require.EqualError(t, err, `unknown key "missing"`)
+	})
+}
@@ -0,0 +1,270 @@
+// Package server is the enclave's request server. It answers attestation, nonce-bound key
+// and health requests from the parent instance over any net.Listener, in production a
+// vsock listener from package vsock.
+//
+// Requests and responses are JSON objects, one after another on the same connection.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jessicatrinh/nsm/request"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/keyring"
+	"sync"
+	"time"
+)
+
+// Request types.
+const (
+	// RequestAttestation asks for a document with the given nonce and user data. It never
+	// carries a public key: the parent could then have the enclave vouch for a key the
+	// parent holds. Keys are attested through RequestKey only. For the same reason the
+	// user data is attested as attestation.RelayedUserData, never as sent.
+	RequestAttestation = "attestation"
+	// RequestKey asks for the enclave's current key, attested with the given nonce and,
+	// like RequestAttestation, the relayed user data.
+	RequestKey = "key"
+	// RequestHealth asks whether the enclave and its NSM are up.
+	RequestHealth = "health"
+)
+
+const (
+	// DefaultIdleTimeout closes connections that send no request for this long.
+	DefaultIdleTimeout = 2 * time.Minute
+	// maxRequestSize bounds a single encoded request.
+	maxRequestSize = 16 << 10
+	writeTimeout   = 10 * time.Second
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("server closed")
+
+// Request is a request from the parent instance.
+type Request struct {
+	Type  string `json:"type"`
+	Nonce []byte `json:"nonce,omitempty"`
+	// UserData is attested as attestation.RelayedUserData(UserData).
+	UserData []byte `json:"user_data,omitempty"`
+	// PublicKey is refused: see RequestAttestation. It is decoded only so that such
+	// requests fail rather than silently dropping the key.
+	PublicKey []byte `json:"public_key,omitempty"`
+}
+
+// Response answers a Request. Error is set if and only if the request failed.
+type Response struct {
+	Error          string                   `json:"error,omitempty"`
+	AttestationDoc []byte                   `json:"attestation_doc,omitempty"`
+	KeyID          string                   `json:"kid,omitempty"`
+	Algorithm      attestation.KeyAlgorithm `json:"alg,omitempty"`
+	PublicKey      []byte                   `json:"public_key,omitempty"`
+	Status         string                   `json:"status,omitempty"`
+	ModuleID       string                   `json:"module_id,omitempty"`
+}
+
+// Server answers the parent instance's attestation and key requests, serving each
+// connection in its own goroutine. Those goroutines read the configuration fields
+// without locking, so set them before the first call to Serve.
+type Server struct {
+	// Session is the NSM session.
+	Session attestation.Session
+	// Keys supplies the key for key requests. If nil, key requests fail.
+	Keys *keyring.Manager
+	// IdleTimeout closes idle connections. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// Serve accepts connections on l and serves each in its own goroutine.
+// Pre: Parameter l is a listener, such as one from vsock.Listen.
+// Post: ErrServerClosed is returned after Close, otherwise the error that stopped l.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nil, conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops all listeners and connections and waits for their goroutines.
+// Pre: None.
+// Post: Nil is returned once the server has stopped.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) track(l net.Listener, c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]struct{}{}
+		s.conns = map[net.Conn]struct{}{}
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		s.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+	delete(s.conns, c)
+	if c != nil {
+		c.Close()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// serveConn answers requests on conn until it is closed, idle or malformed.
+func (s *Server) serveConn(conn net.Conn) {
+	idle := s.IdleTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	limited := &io.LimitedReader{R: conn}
+	dec := json.NewDecoder(limited)
+	enc := json.NewEncoder(conn)
+	for {
+		limited.N = maxRequestSize
+		conn.SetReadDeadline(time.Now().Add(idle))
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				enc.Encode(Response{Error: "malformed request"})
+			}
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := enc.Encode(s.Handle(&req)); err != nil {
+			return
+		}
+	}
+}
+
+// Handle answers a single request. Transports other than Serve may call it directly.
+// Pre: Parameter req is a decoded Request.
+// Post: The Response is returned; failures are reported in Response.Error.
+func (s *Server) Handle(req *Request) *Response {
+	switch req.Type {
+	case RequestAttestation:
+		if len(req.PublicKey) > 0 {
+			return &Response{Error: "attestation requests cannot carry a public key; use a key request"}
+		}
+		doc, err := attestation.RetrieveAttestationFrom(s.Session, req.Nonce, attestation.RelayedUserData(req.UserData), nil)
+		if err != nil {
+			return &Response{Error: errors.Wrap(err, "attestation failed").Error()}
+		}
+		return &Response{AttestationDoc: doc}
+	case RequestKey:
+		if s.Keys == nil {
+			return &Response{Error: "no key manager configured"}
+		}
+		if len(req.Nonce) == 0 {
+			return &Response{Error: "key requests need a nonce"}
+		}
+		key, err := s.Keys.Current()
+		if err != nil {
+			return &Response{Error: err.Error()}
+		}
+		doc, err := attestation.RetrieveAttestationFrom(s.Session, req.Nonce, attestation.RelayedUserData(req.UserData), key.PublicKey)
+		if err != nil {
+			return &Response{Error: errors.Wrap(err, "attestation failed").Error()}
+		}
+		return &Response{AttestationDoc: doc, KeyID: key.ID, Algorithm: key.Algorithm, PublicKey: key.PublicKey}
+	case RequestHealth:
+		res, err := s.Session.Send(&request.DescribeNSM{})
+		if err != nil || res.Error != "" || res.DescribeNSM == nil {
+			return &Response{Error: "nsm unavailable"}
+		}
+		return &Response{Status: "ok", ModuleID: res.DescribeNSM.ModuleID}
+	}
+	return &Response{Error: fmt.Sprintf("unknown request type %q", req.Type)}
+}
+
+// Client sends requests to a Server over one connection. It is not safe for concurrent
+// use.
+type Client struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// NewClient wraps a connection to a Server.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}
+}
+
+// Do sends a request and waits for its response.
+// Pre: None.
+// Post: The Response is returned, or an error is returned if the request failed in
+// transport or was refused by the server.
+func (c *Client) Do(req *Request) (*Response, error) {
+	if err := c.enc.Encode(req); err != nil {
+		return nil, errors.Wrap(err, "could not send request")
+	}
+	var res Response
+	if err := c.dec.Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "could not read response")
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	return &res, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/authn"
+	"nitro/attest/keyring"
+	"nitro/attest/ratls"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listen starts a server on a Unix socket, standing in for vsock.
+func listen(t *testing.T, s *Server) string {
+	path := filepath.Join(t.TempDir(), "enclave.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		require.Equal(t, ErrServerClosed, <-done)
+	})
+	return path
+}
+
+func dial(t *testing.T, path string) *Client {
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+	require.NoError(t, err)
+	path := listen(t, &Server{Session: sim, Keys: keys})
+	opts := attestation.VerifyOptions{Roots: sim.Roots()}
+
+	t.Run("attestation", func(t *testing.T) {
+		c := dial(t, path)
+		n, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		res, err := c.Do(&Request{Type: RequestAttestation, Nonce: n.Value, UserData: []byte("parent")})
+		require.NoError(t, err)
+		opts := opts
+		opts.Nonce = n
+		verified, err := attestation.VerifyDocument(res.AttestationDoc, opts)
+		require.NoError(t, err)
+		require.Equal(t, attestation.RelayedUserData([]byte("parent")), verified.Document.UserData)
+	})
+
+	t.Run("parent cannot forge bindings", func(t *testing.T) {
+		c := dial(t, path)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		digest := sha256.Sum256(spki)
+		res, err := c.Do(&Request{Type: RequestAttestation, UserData: digest[:]})
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			NotBefore:       time.Now().Add(-time.Minute),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtraExtensions: []pkix.Extension{{Id: ratls.OIDAttestation, Value: res.AttestationDoc}},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		_, err = ratls.VerifyCertificate(cert, opts)
+		require.EqualError(t, err, "certificate key is not the attested key")
+
+		binding := make([]byte, 32)
+		_, err = rand.Read(binding)
+		require.NoError(t, err)
+		res, err = c.Do(&Request{Type: RequestAttestation, UserData: binding})
+		require.NoError(t, err)
+		auth := &authn.Authenticator{Options: opts}
+		_, err = auth.Authenticate(base64.StdEncoding.EncodeToString(res.AttestationDoc), "", binding)
+		require.EqualError(t, err, "attestation rejected: document is not bound to this connection")
+	})
+
+	t.Run("nonce-bound key", func(t *testing.T) {
+		c := dial(t, path)
+		n, err := attestation.CreateNonce(time.Minute)
+		require.NoError(t, err)
+		res, err := c.Do(&Request{Type: RequestKey, Nonce: n.Value})
+		require.NoError(t, err)
+		require.Equal(t, keys.Keys()[0].ID, res.KeyID)
+		require.Equal(t, attestation.P256, res.Algorithm)
+		opts := opts
+		opts.Nonce = n
+		verified, err := attestation.VerifyDocument(res.AttestationDoc, opts)
+		require.NoError(t, err)
+		require.Equal(t, res.PublicKey, verified.Document.PublicKey)
+
+		_, err = c.Do(&Request{Type: RequestKey})
+		require.EqualError(t, err, "key requests need a nonce")
+	})
+
+	t.Run("health", func(t *testing.T) {
+		res, err := dial(t, path).Do(&Request{Type: RequestHealth})
+		require.NoError(t, err)
+		require.Equal(t, "ok", res.Status)
+		require.Equal(t, sim.ModuleID, res.ModuleID)
+	})
+
+	t.Run("several requests on one connection", func(t *testing.T) {
+		c := dial(t, path)
+		for i := 0; i < 3; i++ {
+			_, err := c.Do(&Request{Type: RequestHealth})
+			require.NoError(t, err)
+		}
+		_, err := c.Do(&Request{Type: "reboot"})
+		require.EqualError(t, err, `unknown request type "reboot"`)
+		_, err = c.Do(&Request{Type: RequestHealth})
+		require.NoError(t, err)
+	})
+
+	t.Run("attestation failure", func(t *testing.T) {
+		_, err := dial(t, path).Do(&Request{Type: RequestAttestation, Nonce: make([]byte, 4096)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "attestation failed")
+	})
+
+	t.Run("caller-chosen key refused", func(t *testing.T) {
+		pub, _, err := attestation.GenerateKeypairFrom(rand.Reader, attestation.P256)
+		require.NoError(t, err)
+		_, err = dial(t, path).Do(&Request{Type: RequestAttestation, Nonce: []byte{1}, PublicKey: pub})
+		require.EqualError(t, err, "attestation requests cannot carry a public key; use a key request")
+	})
+
+	t.Run("malformed request", func(t *testing.T) {
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("{nope\n"))
+		require.NoError(t, err)
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		require.Equal(t, `{"error":"malformed request"}`, string(bytes.TrimSpace(buf[:n])))
+	})
+
+	t.Run("no key manager", func(t *testing.T) {
+		res := (&Server{Session: sim}).Handle(&Request{Type: RequestKey, Nonce: []byte{1}})
+		require.Equal(t, "no key manager configured", res.Error)
+	})
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	sim, err := attestationtest.NewSimulator(nil)
+	require.NoError(t, err)
+	path := listen(t, &Server{Session: sim, IdleTimeout: 20 * time.Millisecond})
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.False(t, isTimeout(err), "server should have closed the idle connection")
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,220 @@
+// Package signing turns the enclave into an attested signer. An Envelope carries a
+// payload, its signature and the attestation document binding the signing key, so that
+// anyone holding the attestation roots can verify it offline.
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+	"nitro/attest/keyring"
+	"time"
+)
+
+// Version is the envelope format produced by Signer.
+const Version = 1
+
+// signatureContext separates envelope signatures from anything else the same key might
+// sign.
+const signatureContext = "nitro-attest signed envelope v1\x00"
+
+// clockSkew tolerates differences between the enclave's clock and the Nitro Secure
+// Module's, which stamps the document.
+const clockSkew = time.Minute
+
+// Envelope is a self-contained, offline verifiable signature.
+type Envelope struct {
+	// Version is the envelope format.
+	Version int `json:"version"`
+	// Algorithm is the signing key algorithm.
+	Algorithm attestation.KeyAlgorithm `json:"alg"`
+	// PublicKey is the PKIX DER signing key; it must equal the document's public_key.
+	PublicKey []byte `json:"public_key"`
+	// SignedAt is when the signature was made. It is covered by the signature.
+	SignedAt time.Time `json:"signed_at"`
+	// Payload is the signed data.
+	Payload []byte `json:"payload"`
+	// Signature is over the context string, SignedAt and Payload.
+	Signature []byte `json:"signature"`
+	// AttestationDoc binds PublicKey to the enclave that holds it.
+	AttestationDoc []byte `json:"attestation_doc"`
+}
+
+// VerifyOptions configure Verify.
+type VerifyOptions struct {
+	// Document configures verification of the attestation document.
+	Document attestation.VerifyOptions
+	// KeyLifetime is how long a key signs after it was attested: the Interval plus
+	// Overlap of the signer's keyring.Options. Envelopes signed outside that window are
+	// rejected, so that a key leaked after its rotation cannot sign further. Required.
+	KeyLifetime time.Duration
+	// Offline verifies the certificate chain at the document's own timestamp and, unless
+	// Document.Revocation is set, skips revocation, so that envelopes stay verifiable
+	// after the short-lived enclave certificate expires. Document.CurrentTime must then
+	// be zero.
+	Offline bool
+}
+
+// Signer signs payloads inside the enclave with the current attested key.
+type Signer struct {
+	keys *keyring.Manager
+	now  func() time.Time
+}
+
+// NewSigner creates a Signer drawing keys from a key manager.
+// Pre: Parameter keys manages signing capable keys (P-256, P-384, Ed25519 or RSA).
+// Post: A Signer is returned.
+func NewSigner(keys *keyring.Manager) *Signer {
+	return &Signer{keys: keys, now: time.Now}
+}
+
+// Sign signs payload with the current key.
+// Pre: Parameter payload is arbitrary data.
+// Post: A signed Envelope or an error is returned.
+func (s *Signer) Sign(payload []byte) (*Envelope, error) {
+	key, err := s.keys.Current()
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.Signer()
+	if !ok {
+		return nil, fmt.Errorf("%s keys cannot sign", key.Algorithm)
+	}
+	env := &Envelope{
+		Version:        Version,
+		Algorithm:      key.Algorithm,
+		PublicKey:      key.PublicKey,
+		SignedAt:       s.now().UTC().Truncate(time.Second),
+		Payload:        payload,
+		AttestationDoc: key.AttestationDoc,
+	}
+	sig, err := Sign(signer, key.Algorithm, env.signedBytes())
+	if err != nil {
+		return nil, err
+	}
+	env.Signature = sig
+	return env, nil
+}
+
+// Verify checks an envelope: the attestation document, that the signing key is the
+// document's public_key, that it was signed within the key's lifetime, and the
+// signature.
+// Pre: Parameter env is an Envelope. Parameter opts sets the key lifetime and configures
+// document verification.
+// Post: The document verification result or an error is returned.
+func Verify(env *Envelope, opts VerifyOptions) (*nitrite.Result, error) {
+	if env.Version != Version {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	if opts.KeyLifetime <= 0 {
+		return nil, errors.New("envelope verification needs the key lifetime")
+	}
+	docOpts := opts.Document
+	if opts.Offline {
+		if !docOpts.CurrentTime.IsZero() {
+			return nil, errors.New("offline verification uses the document time; leave CurrentTime unset")
+		}
+		doc, err := attestation.ParseDocument(env.AttestationDoc)
+		if err != nil {
+			return nil, err
+		}
+		docOpts.CurrentTime = attestation.DocumentTime(doc)
+		if docOpts.Revocation == nil {
+			docOpts.Revocation = attestation.SkipRevocation
+		}
+	}
+	res, err := attestation.VerifyDocument(env.AttestationDoc, docOpts)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(res.Document.PublicKey, env.PublicKey) {
+		return nil, errors.New("signing key is not the attested public key")
+	}
+	attested := attestation.DocumentTime(res.Document)
+	if env.SignedAt.Before(attested.Add(-clockSkew)) || env.SignedAt.After(attested.Add(opts.KeyLifetime+clockSkew)) {
+		return nil, errors.New("envelope was signed outside the key's lifetime")
+	}
+	pub, alg, err := attestation.ParsePublicKey(env.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if alg != env.Algorithm {
+		return nil, fmt.Errorf("envelope algorithm %s does not match %s key", env.Algorithm, alg)
+	}
+	if err := VerifySignature(pub, env.signedBytes(), env.Signature); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// signedBytes is the message covered by the envelope signature.
+func (env *Envelope) signedBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(signatureContext)
+	buf.WriteString(env.SignedAt.UTC().Format(time.RFC3339))
+	buf.WriteByte(0)
+	buf.Write(env.Payload)
+	return buf.Bytes()
+}
+
+// Sign signs message with the hash that suits the key algorithm: SHA-256 for P-256 and
+// RSA (PSS), SHA-384 for P-384, and none for Ed25519.
+// Pre: Parameter signer is a private key of algorithm alg.
+// Post: The signature or an error is returned.
+func Sign(signer crypto.Signer, alg attestation.KeyAlgorithm, message []byte) ([]byte, error) {
+	switch alg {
+	case attestation.P256:
+		digest := sha256.Sum256(message)
+		return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case attestation.P384:
+		digest := sha512.Sum384(message)
+		return signer.Sign(rand.Reader, digest[:], crypto.SHA384)
+	case attestation.Ed25519:
+		return signer.Sign(rand.Reader, message, crypto.Hash(0))
+	case attestation.RSA:
+		digest := sha256.Sum256(message)
+		return signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	}
+	return nil, fmt.Errorf("%s keys cannot sign", alg)
+}
+
+// VerifySignature checks a signature made by Sign.
+// Pre: Parameter pub is the signer's public key.
+// Post: Nil is returned if the signature is valid, otherwise an error is returned.
+func VerifySignature(pub crypto.PublicKey, message, signature []byte) error {
+	ok := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		alg, err := attestation.KeyAlgorithmOf(key)
+		if err != nil {
+			return err
+		}
+		if alg == attestation.P384 {
+			digest := sha512.Sum384(message)
+			ok = ecdsa.VerifyASN1(key, digest[:], signature)
+		} else {
+			digest := sha256.Sum256(message)
+			ok = ecdsa.VerifyASN1(key, digest[:], signature)
+		}
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		ok = rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	default:
+		return fmt.Errorf("unsupported signing key %T", pub)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/keyring"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0x0a}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+
+	for _, alg := range []attestation.KeyAlgorithm{attestation.P256, attestation.P384, attestation.Ed25519, attestation.RSA} {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: alg, Interval: time.Hour})
+			require.NoError(t, err)
+			env, err := NewSigner(keys).Sign([]byte("hello"))
+			require.NoError(t, err)
+
+			// Round trip through JSON, as an envelope would travel.
+			enc, err := json.Marshal(env)
+			require.NoError(t, err)
+			received := &Envelope{}
+			require.NoError(t, json.Unmarshal(enc, received))
+
+			res, err := Verify(received, VerifyOptions{
+				Document: attestation.VerifyOptions{
+					Roots:  sim.Roots(),
+					Policy: &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}},
+				},
+				KeyLifetime: time.Hour,
+			})
+			require.NoError(t, err)
+			require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+		})
+	}
+
+	keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+	require.NoError(t, err)
+	signer := NewSigner(keys)
+	opts := VerifyOptions{Document: attestation.VerifyOptions{Roots: sim.Roots()}, KeyLifetime: time.Hour}
+
+	t.Run("expired key", func(t *testing.T) {
+		now := time.Now()
+		keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour, Now: func() time.Time { return now }})
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+		_, err = NewSigner(keys).Sign([]byte("hello"))
+		require.Equal(t, keyring.ErrNoCurrentKey, err, "rotation stalled past the key's lifetime")
+	})
+
+	t.Run("after the certificate expired", func(t *testing.T) {
+		then := time.Now().Add(-24 * time.Hour)
+		old, err := sim.CA().NewSimulatorAt(map[uint][]byte{0: pcr0}, then)
+		require.NoError(t, err)
+		clock := func() time.Time { return then }
+		keys, err := keyring.NewManager(old, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour, Now: clock})
+		require.NoError(t, err)
+		signer := NewSigner(keys)
+		signer.now = clock
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+
+		_, err = Verify(env, opts)
+		require.Error(t, err, "verification is online unless asked otherwise")
+		offline := opts
+		offline.Offline = true
+		res, err := Verify(env, offline)
+		require.NoError(t, err)
+		require.Equal(t, old.ModuleID, res.Document.ModuleID)
+
+		online := offline
+		online.Document.Revocation = attestation.OnlineRevocation
+		_, err = Verify(env, online)
+		require.EqualError(t, err, "certificate 0 was revoked", "online checks count expired certificates as revoked")
+		conflicting := offline
+		conflicting.Document.CurrentTime = time.Now()
+		_, err = Verify(env, conflicting)
+		require.EqualError(t, err, "offline verification uses the document time; leave CurrentTime unset")
+	})
+
+	t.Run("signed outside the key lifetime", func(t *testing.T) {
+		key := keys.Keys()[0]
+		priv, ok := key.Signer()
+		require.True(t, ok)
+		for _, at := range []time.Duration{-time.Hour, 2 * time.Hour} {
+			env, err := signer.Sign([]byte("hello"))
+			require.NoError(t, err)
+			// A key leaked after its rotation signs with any timestamp it likes.
+			env.SignedAt = env.SignedAt.Add(at)
+			env.Signature, err = Sign(priv, key.Algorithm, env.signedBytes())
+			require.NoError(t, err)
+			_, err = Verify(env, opts)
+			require.EqualError(t, err, "envelope was signed outside the key's lifetime", at)
+		}
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+		_, err = Verify(env, VerifyOptions{Document: opts.Document})
+		require.EqualError(t, err, "envelope verification needs the key lifetime")
+	})
+
+	t.Run("tampered payload", func(t *testing.T) {
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+		env.Payload = []byte("hellO")
+		_, err = Verify(env, opts)
+		require.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("tampered timestamp", func(t *testing.T) {
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+		env.SignedAt = env.SignedAt.Add(time.Hour)
+		_, err = Verify(env, opts)
+		require.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("key not in document", func(t *testing.T) {
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+		other, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Hour})
+		require.NoError(t, err)
+		env.AttestationDoc = other.Keys()[0].AttestationDoc
+		_, err = Verify(env, opts)
+		require.EqualError(t, err, "signing key is not the attested public key")
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		env, err := signer.Sign([]byte("hello"))
+		require.NoError(t, err)
+		ca, err := attestationtest.NewCA()
+		require.NoError(t, err)
+		_, err = Verify(env, VerifyOptions{Document: attestation.VerifyOptions{Roots: ca.Roots()}, KeyLifetime: time.Hour})
+		require.Error(t, err)
+	})
+
+	t.Run("cannot sign with X25519", func(t *testing.T) {
+		x, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.X25519, Interval: time.Hour})
+		require.NoError(t, err)
+		_, err = NewSigner(x).Sign([]byte("hello"))
+		require.EqualError(t, err, "X25519 keys cannot sign")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := Verify(&Envelope{Version: 2}, opts)
+		require.EqualError(t, err, "unsupported envelope version 2")
+	})
+}
@@ -0,0 +1,394 @@
+// Package token mints and verifies attestation tokens: short-lived JWTs that vouch for an
+// attestation document, so that downstream services need neither COSE nor the AWS
+// certificate chain. An Issuer verifies a document against its policy and signs the
+// result with a key from a keyring.Manager, which rotates it; a Verifier checks tokens
+// against the issuer's keys, in process or fetched from its JWK Set.
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/pkg/errors"
+	"nitro/attest/attestation"
+	"nitro/attest/jwks"
+	"nitro/attest/keyring"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is the lifetime of tokens when none is configured.
+	DefaultTTL = 5 * time.Minute
+	// DefaultMaxAge is how old a document may be when no MaxAge is configured.
+	DefaultMaxAge = 5 * time.Minute
+	// clockSkew tolerates documents stamped slightly in the future.
+	clockSkew = time.Minute
+	// DefaultMinRefresh bounds how often RemoteKeys refetches the key set.
+	DefaultMinRefresh = time.Minute
+	// fetchTimeout bounds a single key set fetch in RemoteKeys.
+	fetchTimeout = 10 * time.Second
+)
+
+// Claims are the claims of an attestation token. The subject is the enclave's module ID.
+type Claims struct {
+	jwt.StandardClaims
+	// ModuleID is the enclave's module ID.
+	ModuleID string `json:"module_id"`
+	// PCRs are the enclave's measurements. All-zero PCRs, which the enclave never
+	// extended, are left out.
+	PCRs map[uint]attestation.Measurement `json:"pcrs"`
+	// PublicKeyThumbprint is the keyring.Thumbprint of the attested public key, if any.
+	PublicKeyThumbprint string `json:"pk_thumbprint,omitempty"`
+	// UserDataHash is the base64url SHA-256 of the attested user data, if any.
+	UserDataHash string `json:"user_data_hash,omitempty"`
+	// PolicyID identifies the policy the document satisfied; see attestation.Policy.ID.
+	PolicyID string `json:"policy_id"`
+}
+
+// IssuerOptions configure an Issuer.
+type IssuerOptions struct {
+	// Issuer is the "iss" claim.
+	Issuer string
+	// Audience, if set, is the "aud" claim.
+	Audience string
+	// TTL is the token lifetime. Zero means DefaultTTL. Tokens never outlive the key
+	// that signs them, so the keyring's Overlap should be at least TTL.
+	TTL time.Duration
+	// Verify configures document verification. Verify.Policy is required; Verify.Nonce is
+	// replaced by the nonce passed to Issue.
+	Verify attestation.VerifyOptions
+	// MaxAge rejects documents older than this. Zero means DefaultMaxAge.
+	MaxAge time.Duration
+	// PolicyID is the "policy_id" claim. Empty means Verify.Policy.ID().
+	PolicyID string
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Issuer mints attestation tokens.
+type Issuer struct {
+	keys *keyring.Manager
+	opts IssuerOptions
+}
+
+// NewIssuer creates an Issuer signing with the current key of keys.
+// Pre: Parameter keys holds signing keys; opts.Verify.Policy is set.
+// Post: An Issuer or an error is returned.
+func NewIssuer(keys *keyring.Manager, opts IssuerOptions) (*Issuer, error) {
+	if opts.Verify.Policy == nil {
+		return nil, errors.New("issuer needs a policy")
+	}
+	key, err := keys.Current()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := signingMethod(key.Algorithm); err != nil {
+		return nil, err
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.PolicyID == "" {
+		opts.PolicyID = opts.Verify.Policy.ID()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Issuer{keys: keys, opts: opts}, nil
+}
+
+// Issue verifies a document and mints a token for it. The document must carry a nonce
+// and be no older than MaxAge: a token outlives the exchange it was issued in, so a
+// document anyone could replay must not buy one.
+// Pre: Parameter doc is the COSE encoded attestation document. Parameter nonce is the
+// challenge the caller gave the enclave for this document; the caller accepts each nonce
+// once.
+// Post: The signed token and its claims, or an error, are returned.
+func (i *Issuer) Issue(doc []byte, nonce *attestation.Nonce) (string, *Claims, error) {
+	if nonce == nil {
+		return "", nil, errors.New("token issuance needs a nonce")
+	}
+	opts := i.opts.Verify
+	opts.Nonce = nonce
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "attestation rejected")
+	}
+	maxAge := i.opts.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	now := i.opts.Now()
+	produced := attestation.DocumentTime(res.Document)
+	if produced.Before(now.Add(-maxAge)) || produced.After(now.Add(clockSkew)) {
+		return "", nil, errors.New("attestation rejected: document is stale")
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", nil, err
+	}
+	key, err := i.keys.Current()
+	if err != nil {
+		return "", nil, err
+	}
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", nil, err
+	}
+	expires := now.Add(i.opts.TTL)
+	if key.NotAfter.Before(expires) {
+		expires = key.NotAfter
+	}
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        base64.RawURLEncoding.EncodeToString(jti),
+			Issuer:    i.opts.Issuer,
+			Subject:   res.Document.ModuleID,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expires.Unix(),
+		},
+		ModuleID: res.Document.ModuleID,
+		PCRs:     map[uint]attestation.Measurement{},
+		PolicyID: i.opts.PolicyID,
+	}
+	if i.opts.Audience != "" {
+		claims.Audience = []string{i.opts.Audience}
+	}
+	for index, value := range res.Document.PCRs {
+		if !attestation.IsZeroMeasurement(value) {
+			claims.PCRs[index] = value
+		}
+	}
+	if len(res.Document.PublicKey) > 0 {
+		claims.PublicKeyThumbprint = keyring.Thumbprint(res.Document.PublicKey)
+	}
+	if len(res.Document.UserData) > 0 {
+		digest := sha256.Sum256(res.Document.UserData)
+		claims.UserDataHash = base64.RawURLEncoding.EncodeToString(digest[:])
+	}
+	tok := jwt.NewWithClaims(method, claims)
+	tok.Header["kid"] = key.ID
+	signed, err := tok.SignedString(key.PrivateKey())
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not sign token")
+	}
+	return signed, claims, nil
+}
+
+// KeySource looks up the issuer's public keys by key ID.
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// managerKeys is a KeySource over an in-process keyring.
+type managerKeys struct {
+	keys *keyring.Manager
+}
+
+// ManagerKeys returns a KeySource serving the valid keys of an in-process Manager, for
+// verifiers running next to the issuer.
+func ManagerKeys(keys *keyring.Manager) KeySource {
+	return managerKeys{keys: keys}
+}
+
+func (m managerKeys) PublicKey(kid string) (crypto.PublicKey, error) {
+	key, ok := m.keys.Key(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	pub, _, err := attestation.ParsePublicKey(key.PublicKey)
+	return pub, err
+}
+
+// RemoteKeys is a KeySource over the issuer's published JWK Set. Only keys whose
+// attestation documents pass Client.Options are used. Unknown key IDs trigger a refetch,
+// at most once per MinRefresh, which picks up rotated keys.
+type RemoteKeys struct {
+	// Client fetches and verifies the key set.
+	Client *jwks.Client
+	// MinRefresh is the minimum time between fetches. Zero means DefaultMinRefresh.
+	MinRefresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// PublicKey returns the key with the given ID, fetching the set if needed.
+func (r *RemoteKeys) PublicKey(kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.keys[kid]; ok {
+		return key, nil
+	}
+	minRefresh := r.MinRefresh
+	if minRefresh <= 0 {
+		minRefresh = DefaultMinRefresh
+	}
+	if !r.fetched.IsZero() && time.Since(r.fetched) < minRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	verified, err := r.Client.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.fetched = time.Now()
+	r.keys = make(map[string]crypto.PublicKey, len(verified))
+	for _, k := range verified {
+		r.keys[k.KeyID] = k.Key
+	}
+	if key, ok := r.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Verifier verifies attestation tokens. It holds no state of its own, so one Verifier
+// may check tokens from many goroutines as long as its Keys do.
+type Verifier struct {
+	// Keys supplies the issuer's public keys.
+	Keys KeySource
+	// Issuer, if set, must equal the "iss" claim.
+	Issuer string
+	// Audience, if set, must be among the "aud" claims.
+	Audience string
+	// PolicyID, if set, must equal the "policy_id" claim.
+	PolicyID string
+	// Leeway tolerates clock skew in the time claims.
+	Leeway time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Verify checks a token's signature and claims.
+// Pre: Parameter token is a compact JWT.
+// Post: The verified claims or an error are returned.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{"ES256", "ES384", "PS256", "EdDSA"},
+		SkipClaimsValidation: true,
+	}
+	claims := &Claims{}
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		pub, err := v.Keys.PublicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		alg, err := attestation.KeyAlgorithmOf(pub)
+		if err != nil {
+			return nil, err
+		}
+		method, err := signingMethod(alg)
+		if err != nil {
+			return nil, err
+		}
+		if method.Alg() != t.Method.Alg() {
+			return nil, fmt.Errorf("token algorithm %s does not match its key", t.Method.Alg())
+		}
+		return pub, nil
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+			err = ve.Inner
+		}
+		return nil, errors.Wrap(err, "invalid token")
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(c *Claims) error {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	t := now()
+	if c.ExpiresAt == 0 || !t.Before(time.Unix(c.ExpiresAt, 0).Add(v.Leeway)) {
+		return errors.New("token has expired")
+	}
+	if t.Add(v.Leeway).Before(time.Unix(c.NotBefore, 0)) || t.Add(v.Leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("token issuer %q is not trusted", c.Issuer)
+	}
+	if v.Audience != "" && !contains(c.Audience, v.Audience) {
+		return errors.New("token is for another audience")
+	}
+	if v.PolicyID != "" && c.PolicyID != v.PolicyID {
+		return errors.New("token was issued under another policy")
+	}
+	return nil
+}
+
+// signingMethod maps key algorithms to JWS algorithms, as jwks.FromKey does.
+func signingMethod(alg attestation.KeyAlgorithm) (jwt.SigningMethod, error) {
+	switch alg {
+	case attestation.P256:
+		return jwt.SigningMethodES256, nil
+	case attestation.P384:
+		return jwt.SigningMethodES384, nil
+	case attestation.RSA:
+		return jwt.SigningMethodPS256, nil
+	case attestation.Ed25519:
+		return signingMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("%s keys cannot sign tokens", alg)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// edDSA implements the EdDSA JWS algorithm (RFC 8037), which jwt-go v3 lacks.
+type edDSA struct{}
+
+var signingMethodEdDSA = &edDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod { return signingMethodEdDSA })
+}
+
+func (*edDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (*edDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+func (*edDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package token
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/form3tech-oss/jwt-go"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"nitro/attest/jwks"
+	"nitro/attest/keyring"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestToken(t *testing.T) {
+	pcr := bytes.Repeat([]byte{0x0a}, 48)
+	sim, err := attestationtest.NewSimulator(map[uint][]byte{0: pcr})
+	require.NoError(t, err)
+	policy := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr}}
+	clk := &clock{now: time.Now()}
+
+	newIssuer := func(t *testing.T, alg attestation.KeyAlgorithm) (*Issuer, *keyring.Manager) {
+		keys, err := keyring.NewManager(sim, keyring.Options{Algorithm: alg, Interval: time.Hour, Overlap: 10 * time.Minute, Now: clk.Now})
+		require.NoError(t, err)
+		iss, err := NewIssuer(keys, IssuerOptions{
+			Issuer:   "https://verifier.example",
+			Audience: "payments",
+			Verify:   attestation.VerifyOptions{Roots: sim.Roots(), Policy: policy},
+			Now:      clk.Now,
+		})
+		require.NoError(t, err)
+		return iss, keys
+	}
+	issuer, keys := newIssuer(t, attestation.P256)
+	verifier := &Verifier{Keys: ManagerKeys(keys), Issuer: "https://verifier.example", Audience: "payments", PolicyID: policy.ID(), Now: clk.Now}
+
+	pub, _, err := attestation.GenerateKeypairFrom(sim, attestation.P256)
+	require.NoError(t, err)
+	doc, err := attestation.RetrieveAttestationFrom(sim, []byte("nonce"), []byte("user data"), pub)
+	require.NoError(t, err)
+	nonce := &attestation.Nonce{Value: []byte("nonce"), Expiration: time.Now().Add(time.Hour)}
+
+	t.Run("issue and verify", func(t *testing.T) {
+		tok, issued, err := issuer.Issue(doc, nonce)
+		require.NoError(t, err)
+		claims, err := verifier.Verify(tok)
+		require.NoError(t, err)
+		require.Equal(t, issued, claims)
+
+		require.Equal(t, sim.ModuleID, claims.ModuleID)
+		require.Equal(t, sim.ModuleID, claims.Subject)
+		require.Equal(t, map[uint]attestation.Measurement{0: pcr}, claims.PCRs)
+		require.Equal(t, keyring.Thumbprint(pub), claims.PublicKeyThumbprint)
+		digest := sha256.Sum256([]byte("user data"))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(digest[:]), claims.UserDataHash)
+		require.Equal(t, policy.ID(), claims.PolicyID)
+		require.Equal(t, clk.Now().Add(DefaultTTL).Unix(), claims.ExpiresAt)
+		require.NotEmpty(t, claims.Id)
+	})
+
+	t.Run("document must verify", func(t *testing.T) {
+		_, _, err := issuer.Issue(doc, &attestation.Nonce{Value: []byte("other"), Expiration: time.Now().Add(time.Minute)})
+		require.EqualError(t, err, "attestation rejected: mismatched nonce")
+
+		other, err := attestationtest.NewSimulator(map[uint][]byte{0: make([]byte, 48)})
+		require.NoError(t, err)
+		iss, err := NewIssuer(keys, IssuerOptions{Verify: attestation.VerifyOptions{Roots: other.Roots(), Policy: policy}})
+		require.NoError(t, err)
+		wrongPCR, err := attestation.RetrieveAttestationFrom(other, nonce.Value, nil, nil)
+		require.NoError(t, err)
+		_, _, err = iss.Issue(wrongPCR, nonce)
+		require.EqualError(t, err, "attestation rejected: PCR0 mismatch")
+	})
+
+	t.Run("replayed documents", func(t *testing.T) {
+		bare, err := attestation.RetrieveAttestationFrom(sim, nil, []byte("user data"), pub)
+		require.NoError(t, err)
+		_, _, err = issuer.Issue(bare, nil)
+		require.EqualError(t, err, "token issuance needs a nonce")
+
+		late, err := NewIssuer(keys, IssuerOptions{
+			Verify: attestation.VerifyOptions{Roots: sim.Roots(), Policy: policy},
+			Now:    func() time.Time { return clk.Now().Add(DefaultMaxAge + time.Minute) },
+		})
+		require.NoError(t, err)
+		_, _, err = late.Issue(doc, nonce)
+		require.EqualError(t, err, "attestation rejected: document is stale")
+	})
+
+	t.Run("algorithms", func(t *testing.T) {
+		for _, alg := range []attestation.KeyAlgorithm{attestation.P384, attestation.Ed25519, attestation.RSA} {
+			iss, keys := newIssuer(t, alg)
+			tok, _, err := iss.Issue(doc, nonce)
+			require.NoError(t, err, alg)
+			_, err = (&Verifier{Keys: ManagerKeys(keys), Now: clk.Now}).Verify(tok)
+			require.NoError(t, err, alg)
+		}
+		x, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.X25519, Interval: time.Hour})
+		require.NoError(t, err)
+		_, err = NewIssuer(x, IssuerOptions{Verify: attestation.VerifyOptions{Policy: policy}})
+		require.EqualError(t, err, "X25519 keys cannot sign tokens")
+		_, err = NewIssuer(keys, IssuerOptions{})
+		require.EqualError(t, err, "issuer needs a policy")
+	})
+
+	t.Run("claims are checked", func(t *testing.T) {
+		tok, _, err := issuer.Issue(doc, nonce)
+		require.NoError(t, err)
+		for _, tc := range []struct {
+			name string
+			v    Verifier
+			err  string
+		}{
+			{"issuer", Verifier{Issuer: "https://evil.example"}, `token issuer "https://verifier.example" is not trusted`},
+			{"audience", Verifier{Audience: "billing"}, "token is for another audience"},
+			{"policy", Verifier{PolicyID: (&attestation.Policy{}).ID()}, "token was issued under another policy"},
+			{"expired", Verifier{Now: func() time.Time { return clk.Now().Add(DefaultTTL) }}, "token has expired"},
+			{"not yet valid", Verifier{Now: func() time.Time { return clk.Now().Add(-time.Minute) }}, "token is not valid yet"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				v := tc.v
+				v.Keys = ManagerKeys(keys)
+				if v.Now == nil {
+					v.Now = clk.Now
+				}
+				_, err := v.Verify(tok)
+				require.EqualError(t, err, tc.err)
+			})
+		}
+		leeway := Verifier{Keys: ManagerKeys(keys), Leeway: time.Minute, Now: func() time.Time { return clk.Now().Add(DefaultTTL) }}
+		_, err = leeway.Verify(tok)
+		require.NoError(t, err)
+	})
+
+	t.Run("forgeries", func(t *testing.T) {
+		tok, _, err := issuer.Issue(doc, nonce)
+		require.NoError(t, err)
+		parts := strings.Split(tok, ".")
+
+		tampered := &Claims{}
+		_, _, err = new(jwt.Parser).ParseUnverified(tok, tampered)
+		require.NoError(t, err)
+		tampered.PCRs[0] = make([]byte, 48)
+		forged := jwt.NewWithClaims(jwt.SigningMethodES256, tampered)
+		forged.Header["kid"] = keys.Keys()[0].ID
+		unsigned, err := forged.SigningString()
+		require.NoError(t, err)
+		_, err = verifier.Verify(unsigned + "." + parts[2])
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid token")
+
+		none := jwt.NewWithClaims(jwt.SigningMethodNone, tampered)
+		none.Header["kid"] = keys.Keys()[0].ID
+		s, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		_, err = verifier.Verify(s)
+		require.EqualError(t, err, "invalid token: signing method none is invalid")
+
+		hs := jwt.NewWithClaims(jwt.SigningMethodHS256, tampered)
+		hs.Header["kid"] = keys.Keys()[0].ID
+		s, err = hs.SignedString(keys.Keys()[0].PublicKey)
+		require.NoError(t, err)
+		_, err = verifier.Verify(s)
+		require.EqualError(t, err, "invalid token: signing method HS256 is invalid")
+
+		es384 := jwt.NewWithClaims(jwt.SigningMethodES384, tampered)
+		es384.Header["kid"] = keys.Keys()[0].ID
+		unsigned, err = es384.SigningString()
+		require.NoError(t, err)
+		_, err = verifier.Verify(unsigned + "." + parts[2])
+		require.EqualError(t, err, "invalid token: token algorithm ES384 does not match its key")
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		iss, keys := newIssuer(t, attestation.P256)
+		v := &Verifier{Keys: ManagerKeys(keys), Now: clk.Now}
+		before, _, err := iss.Issue(doc, nonce)
+		require.NoError(t, err)
+		_, err = keys.Rotate()
+		require.NoError(t, err)
+		after, claims, err := iss.Issue(doc, nonce)
+		require.NoError(t, err)
+		_, err = v.Verify(before)
+		require.NoError(t, err, "tokens signed before a rotation verify during the overlap")
+		_, err = v.Verify(after)
+		require.NoError(t, err)
+
+		// The retired key expires with the overlap window, so tokens it signed do too.
+		clk.Advance(10 * time.Minute)
+		_, err = v.Verify(before)
+		require.Error(t, err)
+		clk.Advance(-10 * time.Minute)
+		require.LessOrEqual(t, claims.ExpiresAt, keys.Keys()[0].NotAfter.Unix())
+	})
+
+	t.Run("ttl capped by key lifetime", func(t *testing.T) {
+		short, err := keyring.NewManager(sim, keyring.Options{Algorithm: attestation.P256, Interval: time.Minute, Now: clk.Now})
+		require.NoError(t, err)
+		iss, err := NewIssuer(short, IssuerOptions{Verify: attestation.VerifyOptions{Roots: sim.Roots(), Policy: policy}, Now: clk.Now})
+		require.NoError(t, err)
+		_, claims, err := iss.Issue(doc, nonce)
+		require.NoError(t, err)
+		require.Equal(t, short.Keys()[0].NotAfter.Unix(), claims.ExpiresAt)
+	})
+
+	t.Run("remote keys", func(t *testing.T) {
+		iss, keys := newIssuer(t, attestation.P256)
+		srv := httptest.NewServer(jwks.NewHandler(keys))
+		defer srv.Close()
+		remote := &RemoteKeys{Client: &jwks.Client{URL: srv.URL, Options: attestation.VerifyOptions{Roots: sim.Roots()}}}
+		v := &Verifier{Keys: remote, Now: clk.Now}
+
+		tok, _, err := iss.Issue(doc, nonce)
+		require.NoError(t, err)
+		_, err = v.Verify(tok)
+		require.NoError(t, err)
+
+		_, err = keys.Rotate()
+		require.NoError(t, err)
+		rotated, _, err := iss.Issue(doc, nonce)
+		require.NoError(t, err)
+		_, err = v.Verify(rotated)
+		require.EqualError(t, err, `invalid token: unknown signing key "`+keys.Keys()[0].ID+`"`, "refetch is rate limited")
+
+		remote.MinRefresh = time.Nanosecond
+		_, err = v.Verify(rotated)
+		require.NoError(t, err)
+	})
+}
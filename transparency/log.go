@@ -0,0 +1,350 @@
+// Package transparency keeps a tamper-evident, append-only log of the attestation
+// documents a verifier accepted, modeled on Certificate Transparency (RFC 6962), so that
+// third parties can audit which enclave builds were trusted.
+//
+// Entries are the leaves of a Merkle tree. The log signs tree heads committing to its
+// contents; an inclusion proof shows an entry is covered by a tree head, and a
+// consistency proof shows a later tree head extends an earlier one, so the log cannot
+// drop or rewrite entries without the change showing. The tree is kept in a bbolt
+// database: every leaf hash and every complete subtree hash is stored, so roots and
+// proofs take O(log² n) reads.
+package transparency
+
+import (
+	"crypto"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/hf/nitrite"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"math/bits"
+	"nitro/attest/attestation"
+	"time"
+)
+
+var (
+	bucketMeta   = []byte("meta")
+	bucketLeaves = []byte("leaves")
+	bucketNodes  = []byte("nodes")
+
+	keySize  = []byte("size")
+	keyLogID = []byte("log_id")
+)
+
+// Entry is one accepted attestation document. Its JSON encoding is the leaf of the tree.
+type Entry struct {
+	// Time is when the document was accepted.
+	Time time.Time `json:"time"`
+	// Document is the COSE encoded attestation document, so that anyone can verify it.
+	Document []byte `json:"document"`
+	// PolicyID is the ID of the policy the document satisfied, if any.
+	PolicyID string `json:"policy_id,omitempty"`
+}
+
+// ParseEntry decodes a leaf.
+// Pre: None.
+// Post: The entry or an error is returned.
+func ParseEntry(leaf []byte) (*Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(leaf, &e); err != nil {
+		return nil, errors.Wrap(err, "malformed log entry")
+	}
+	return &e, nil
+}
+
+// Options configure a Log.
+type Options struct {
+	// Timeout bounds waiting for another process to release the database. Zero waits
+	// forever.
+	Timeout time.Duration
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Log is a transparency log. It is safe for concurrent use.
+type Log struct {
+	db     *bolt.DB
+	signer crypto.Signer
+	opts   Options
+}
+
+// Open opens the log in the named file, creating it if necessary. A log is bound to the
+// key that first signs for it; without a signer the log can be appended to and proven
+// from, but cannot sign tree heads.
+// Pre: None.
+// Post: The log or an error is returned.
+func Open(name string, signer crypto.Signer, opts Options) (*Log, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	var id []byte
+	if signer != nil {
+		var err error
+		if id, err = KeyID(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(name, 0600, &bolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open transparency log")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketLeaves, bucketNodes} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if id == nil {
+			return nil
+		}
+		meta := tx.Bucket(bucketMeta)
+		switch stored := meta.Get(keyLogID); {
+		case stored == nil:
+			return meta.Put(keyLogID, id)
+		case string(stored) != string(id):
+			return errors.New("log was created with a different key")
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "could not initialize transparency log")
+	}
+	return &Log{db: db, signer: signer, opts: opts}, nil
+}
+
+// Close closes the database.
+func (l *Log) Close() error {
+	return l.db.Close()
+}
+
+// Append adds an entry, stamping it with the current time if it has none.
+// Pre: Parameter e holds a document.
+// Post: The index of the entry or an error is returned.
+func (l *Log) Append(e Entry) (uint64, error) {
+	if len(e.Document) == 0 {
+		return 0, errors.New("entry has no document")
+	}
+	if e.Time.IsZero() {
+		e.Time = l.opts.Now()
+	}
+	e.Time = e.Time.UTC()
+	leaf, err := json.Marshal(&e)
+	if err != nil {
+		return 0, err
+	}
+	var index uint64
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		meta, nodes := tx.Bucket(bucketMeta), tx.Bucket(bucketNodes)
+		index = size(meta)
+		if err := tx.Bucket(bucketLeaves).Put(indexKey(index), leaf); err != nil {
+			return err
+		}
+		hash := LeafHash(leaf)
+		if err := nodes.Put(nodeKey(0, index), hash); err != nil {
+			return err
+		}
+		// Each leaf completes the subtrees it is the last leaf of.
+		for level, i := uint(1), index; i&1 == 1; level, i = level+1, i>>1 {
+			hash = nodeHash(nodes.Get(nodeKey(level-1, i-1)), hash)
+			if err := nodes.Put(nodeKey(level, i>>1), hash); err != nil {
+				return err
+			}
+		}
+		return meta.Put(keySize, indexKey(index+1))
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "could not append to transparency log")
+	}
+	return index, nil
+}
+
+// Verify verifies a document with attestation.VerifyDocument and logs it if it is
+// accepted. A document that cannot be logged is rejected.
+// Pre: Parameter doc is the COSE encoded attestation document.
+// Post: The verification result or an error is returned.
+func (l *Log) Verify(doc []byte, opts attestation.VerifyOptions) (*nitrite.Result, error) {
+	res, err := attestation.VerifyDocument(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	e := Entry{Document: doc}
+	if opts.Policy != nil {
+		e.PolicyID = opts.Policy.ID()
+	}
+	if _, err := l.Append(e); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Size returns the number of entries.
+// Pre: None.
+// Post: The size or an error is returned.
+func (l *Log) Size() (uint64, error) {
+	var n uint64
+	err := l.db.View(func(tx *bolt.Tx) error {
+		n = size(tx.Bucket(bucketMeta))
+		return nil
+	})
+	return n, err
+}
+
+// Leaf returns the encoded entry at index.
+// Pre: None.
+// Post: The leaf or an error is returned.
+func (l *Log) Leaf(index uint64) ([]byte, error) {
+	var leaf []byte
+	err := l.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketLeaves).Get(indexKey(index))
+		if v == nil {
+			return fmt.Errorf("no entry %d in log", index)
+		}
+		leaf = append([]byte(nil), v...)
+		return nil
+	})
+	return leaf, err
+}
+
+// Root returns the root hash of the tree of the first n entries.
+// Pre: None.
+// Post: The root hash or an error is returned.
+func (l *Log) Root(n uint64) ([]byte, error) {
+	var root []byte
+	err := l.view(n, func(t tree) (err error) {
+		if n == 0 {
+			root = EmptyRoot()
+			return nil
+		}
+		root, err = t.subtree(0, n)
+		return err
+	})
+	return root, err
+}
+
+// SignedTreeHead signs the tree of all current entries.
+// Pre: The log was opened with a signer.
+// Post: The signed tree head or an error is returned.
+func (l *Log) SignedTreeHead() (*SignedTreeHead, error) {
+	if l.signer == nil {
+		return nil, errors.New("log has no signing key")
+	}
+	sth := &SignedTreeHead{Timestamp: l.opts.Now()}
+	err := l.db.View(func(tx *bolt.Tx) (err error) {
+		sth.TreeSize = size(tx.Bucket(bucketMeta))
+		if sth.TreeSize == 0 {
+			sth.RootHash = EmptyRoot()
+			return nil
+		}
+		sth.RootHash, err = tree{tx}.subtree(0, sth.TreeSize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := SignTreeHead(l.signer, sth); err != nil {
+		return nil, err
+	}
+	return sth, nil
+}
+
+// InclusionProof proves that the entry at index is in the tree of the first n entries.
+// Pre: None.
+// Post: The proof or an error is returned.
+func (l *Log) InclusionProof(index, n uint64) (*InclusionProof, error) {
+	if index >= n {
+		return nil, fmt.Errorf("leaf index %d is outside a tree of size %d", index, n)
+	}
+	p := &InclusionProof{LeafIndex: index, TreeSize: n}
+	err := l.view(n, func(t tree) (err error) {
+		p.Leaf = append([]byte(nil), t.tx.Bucket(bucketLeaves).Get(indexKey(index))...)
+		p.AuditPath, err = inclusionPath(t, index, 0, n)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ConsistencyProof proves that the tree of the first second entries extends the tree of
+// the first first entries.
+// Pre: None.
+// Post: The proof or an error is returned.
+func (l *Log) ConsistencyProof(first, second uint64) (*ConsistencyProof, error) {
+	if first > second {
+		return nil, fmt.Errorf("tree of size %d cannot extend a tree of size %d", second, first)
+	}
+	p := &ConsistencyProof{FirstSize: first, SecondSize: second}
+	if first == 0 || first == second {
+		return p, nil
+	}
+	err := l.view(second, func(t tree) (err error) {
+		p.Path, err = consistencyPath(t, first, 0, second, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// view runs fn on the stored tree, after checking it has at least n entries.
+func (l *Log) view(n uint64, fn func(tree) error) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		if have := size(tx.Bucket(bucketMeta)); n > have {
+			return fmt.Errorf("log has %d entries, not %d", have, n)
+		}
+		return fn(tree{tx})
+	})
+}
+
+// tree reads subtree hashes from the nodes bucket.
+type tree struct {
+	tx *bolt.Tx
+}
+
+// subtree splits a range of leaves into the complete subtrees that are stored.
+func (t tree) subtree(start, n uint64) ([]byte, error) {
+	if n&(n-1) == 0 && start%n == 0 {
+		level := uint(bits.TrailingZeros64(n))
+		hash := t.tx.Bucket(bucketNodes).Get(nodeKey(level, start>>level))
+		if hash == nil {
+			return nil, fmt.Errorf("transparency log is missing the hash of leaves %d to %d", start, start+n-1)
+		}
+		return append([]byte(nil), hash...), nil
+	}
+	k := split(n)
+	left, err := t.subtree(start, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.subtree(start+k, n-k)
+	if err != nil {
+		return nil, err
+	}
+	return nodeHash(left, right), nil
+}
+
+// size reads the number of entries.
+func size(meta *bolt.Bucket) uint64 {
+	if v := meta.Get(keySize); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func indexKey(index uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
+
+// nodeKey is the key of the hash of the index'th complete subtree of 2^level leaves.
+func nodeKey(level uint, index uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = byte(level)
+	binary.BigEndian.PutUint64(key[1:], index)
+	return key
+}
@@ -0,0 +1,194 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"nitro/attest/attestation"
+	"nitro/attest/attestation/attestationtest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	now := time.Date(2022, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	name := filepath.Join(t.TempDir(), "tlog.db")
+	l, err := Open(name, key, Options{Timeout: time.Second, Now: func() time.Time { return now }})
+	require.NoError(t, err)
+
+	empty, err := l.SignedTreeHead()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), empty.TreeSize)
+	require.Equal(t, EmptyRoot(), empty.RootHash)
+
+	// Keep a reference tree and a signed tree head at every size.
+	var m memTree
+	heads := []*SignedTreeHead{empty}
+	for i := 0; i < 13; i++ {
+		index, err := l.Append(Entry{Document: []byte(fmt.Sprintf("document %d", i)), PolicyID: "policy"})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), index)
+		leaf, err := l.Leaf(index)
+		require.NoError(t, err)
+		m = append(m, LeafHash(leaf))
+		sth, err := l.SignedTreeHead()
+		require.NoError(t, err)
+		heads = append(heads, sth)
+	}
+
+	t.Run("entries", func(t *testing.T) {
+		leaf, err := l.Leaf(4)
+		require.NoError(t, err)
+		e, err := ParseEntry(leaf)
+		require.NoError(t, err)
+		require.Equal(t, []byte("document 4"), e.Document)
+		require.Equal(t, "policy", e.PolicyID)
+		require.Equal(t, now, e.Time)
+
+		_, err = l.Leaf(13)
+		require.Error(t, err)
+		_, err = l.Append(Entry{})
+		require.Error(t, err)
+	})
+
+	t.Run("tree heads", func(t *testing.T) {
+		for n, sth := range heads {
+			require.Equal(t, uint64(n), sth.TreeSize)
+			if n > 0 {
+				want, _ := m.subtree(0, uint64(n))
+				require.Equal(t, want, sth.RootHash, "tree of size %d", n)
+			}
+			require.NoError(t, VerifyTreeHead(key.Public(), sth))
+		}
+		sth := heads[5]
+		require.Equal(t, now.Truncate(time.Millisecond), sth.Timestamp)
+		root, err := l.Root(5)
+		require.NoError(t, err)
+		require.Equal(t, sth.RootHash, root)
+		_, err = l.Root(14)
+		require.Error(t, err)
+
+		b, err := json.Marshal(sth)
+		require.NoError(t, err)
+		var decoded SignedTreeHead
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		require.NoError(t, VerifyTreeHead(key.Public(), &decoded), "tree heads survive encoding")
+
+		forged := decoded
+		forged.TreeSize--
+		require.Error(t, VerifyTreeHead(key.Public(), &forged))
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		require.Error(t, VerifyTreeHead(other.Public(), &decoded))
+	})
+
+	t.Run("inclusion", func(t *testing.T) {
+		for n := uint64(1); n < uint64(len(heads)); n++ {
+			for i := uint64(0); i < n; i++ {
+				p, err := l.InclusionProof(i, n)
+				require.NoError(t, err)
+				require.NoError(t, p.Verify(heads[n]), "leaf %d of %d", i, n)
+				if n > 1 {
+					require.Error(t, p.Verify(heads[n-1]))
+				}
+			}
+		}
+		p, err := l.InclusionProof(2, 9)
+		require.NoError(t, err)
+		p.Leaf = bytes.Replace(p.Leaf, []byte("policy"), []byte("forged"), 1)
+		require.Error(t, p.Verify(heads[9]), "entries cannot be rewritten")
+
+		_, err = l.InclusionProof(9, 9)
+		require.Error(t, err)
+		_, err = l.InclusionProof(0, 14)
+		require.Error(t, err)
+	})
+
+	t.Run("consistency", func(t *testing.T) {
+		for second := uint64(0); second < uint64(len(heads)); second++ {
+			for first := uint64(0); first <= second; first++ {
+				p, err := l.ConsistencyProof(first, second)
+				require.NoError(t, err)
+				require.NoError(t, p.Verify(heads[first], heads[second]), "%d to %d", first, second)
+			}
+		}
+		p, err := l.ConsistencyProof(3, 7)
+		require.NoError(t, err)
+		require.Error(t, p.Verify(heads[3], heads[8]))
+		_, err = l.ConsistencyProof(7, 3)
+		require.Error(t, err)
+	})
+
+	t.Run("persistence", func(t *testing.T) {
+		require.NoError(t, l.Close())
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		_, err = Open(name, other, Options{Timeout: time.Second})
+		require.Error(t, err, "a log is bound to its key")
+
+		readOnly, err := Open(name, nil, Options{Timeout: time.Second})
+		require.NoError(t, err)
+		_, err = readOnly.SignedTreeHead()
+		require.Error(t, err)
+		n, err := readOnly.Size()
+		require.NoError(t, err)
+		require.Equal(t, uint64(13), n)
+		_, err = readOnly.Append(Entry{Document: []byte("document 13")})
+		require.NoError(t, err)
+		require.NoError(t, readOnly.Close())
+
+		l, err = Open(name, key, Options{Timeout: time.Second})
+		require.NoError(t, err)
+		defer l.Close()
+		sth, err := l.SignedTreeHead()
+		require.NoError(t, err)
+		require.Equal(t, uint64(14), sth.TreeSize)
+		p, err := l.ConsistencyProof(13, 14)
+		require.NoError(t, err)
+		require.NoError(t, p.Verify(heads[13], sth))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+	ca, err := attestationtest.NewCA()
+	require.NoError(t, err)
+	sim, err := ca.NewSimulator(map[uint][]byte{0: pcr0})
+	require.NoError(t, err)
+	doc, err := attestation.RetrieveAttestationFrom(sim, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	l, err := Open(filepath.Join(t.TempDir(), "tlog.db"), key, Options{Timeout: time.Second})
+	require.NoError(t, err)
+	defer l.Close()
+
+	policy := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: pcr0}}
+	res, err := l.Verify(doc, attestation.VerifyOptions{Roots: ca.Roots(), Policy: policy})
+	require.NoError(t, err)
+	require.Equal(t, sim.ModuleID, res.Document.ModuleID)
+	wrong := &attestation.Policy{PCRs: map[uint]attestation.Measurement{0: make([]byte, 48)}}
+	_, err = l.Verify(doc, attestation.VerifyOptions{Roots: ca.Roots(), Policy: wrong})
+	require.Error(t, err)
+
+	sth, err := l.SignedTreeHead()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), sth.TreeSize, "rejected documents are not logged")
+	require.NoError(t, VerifyTreeHead(key.Public(), sth))
+	p, err := l.InclusionProof(0, 1)
+	require.NoError(t, err)
+	require.NoError(t, p.Verify(sth))
+	e, err := ParseEntry(p.Leaf)
+	require.NoError(t, err)
+	require.Equal(t, doc, e.Document)
+	require.Equal(t, policy.ID(), e.PolicyID)
+}
@@ -0,0 +1,236 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// HashSize is the size of tree hashes.
+const HashSize = sha256.Size
+
+// Domain separation prefixes of RFC 6962, so that a leaf can never pass for a node.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ErrInvalidProof is returned when a proof does not verify.
+var ErrInvalidProof = errors.New("invalid merkle proof")
+
+// LeafHash returns the hash of a leaf.
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// EmptyRoot is the root hash of the empty tree.
+func EmptyRoot() []byte {
+	digest := sha256.Sum256(nil)
+	return digest[:]
+}
+
+// split returns the largest power of two smaller than n, where the tree of n leaves is
+// split into its left and right subtrees.
+// Pre: n > 1.
+func split(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// subtrees hashes ranges of leaves. A Log reads them from storage; tests compute them.
+type subtrees interface {
+	// subtree returns the hash of the n leaves from start.
+	subtree(start, n uint64) ([]byte, error)
+}
+
+// inclusionPath computes PATH(m, D[start:start+n]) of RFC 6962 section 2.1.1.
+func inclusionPath(t subtrees, m, start, n uint64) ([][]byte, error) {
+	if n <= 1 {
+		return nil, nil
+	}
+	k := split(n)
+	if m < k {
+		path, err := inclusionPath(t, m, start, k)
+		if err != nil {
+			return nil, err
+		}
+		right, err := t.subtree(start+k, n-k)
+		return append(path, right), err
+	}
+	path, err := inclusionPath(t, m-k, start+k, n-k)
+	if err != nil {
+		return nil, err
+	}
+	left, err := t.subtree(start, k)
+	return append(path, left), err
+}
+
+// consistencyPath computes SUBPROOF(m, D[start:start+n], complete) of RFC 6962 section
+// 2.1.2.
+func consistencyPath(t subtrees, m, start, n uint64, complete bool) ([][]byte, error) {
+	if m == n {
+		if complete {
+			return nil, nil
+		}
+		root, err := t.subtree(start, m)
+		return [][]byte{root}, err
+	}
+	k := split(n)
+	if m <= k {
+		path, err := consistencyPath(t, m, start, k, complete)
+		if err != nil {
+			return nil, err
+		}
+		right, err := t.subtree(start+k, n-k)
+		return append(path, right), err
+	}
+	path, err := consistencyPath(t, m-k, start+k, n-k, false)
+	if err != nil {
+		return nil, err
+	}
+	left, err := t.subtree(start, k)
+	return append(path, left), err
+}
+
+// InclusionProof shows that a leaf is in a tree. It carries the leaf, so that it can be
+// checked against a signed tree head alone.
+type InclusionProof struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	TreeSize  uint64   `json:"tree_size"`
+	Leaf      []byte   `json:"leaf"`
+	AuditPath [][]byte `json:"audit_path"`
+}
+
+// Verify checks the proof against a verified tree head of the same size.
+// Pre: None.
+// Post: Nil is returned if the leaf is in the tree, otherwise an error.
+func (p *InclusionProof) Verify(sth *SignedTreeHead) error {
+	if p.TreeSize != sth.TreeSize {
+		return fmt.Errorf("proof is for a tree of size %d, not %d", p.TreeSize, sth.TreeSize)
+	}
+	return VerifyInclusion(LeafHash(p.Leaf), p.LeafIndex, p.TreeSize, p.AuditPath, sth.RootHash)
+}
+
+// ConsistencyProof shows that a tree extends an earlier one.
+type ConsistencyProof struct {
+	FirstSize  uint64   `json:"first_size"`
+	SecondSize uint64   `json:"second_size"`
+	Path       [][]byte `json:"consistency"`
+}
+
+// Verify checks the proof against verified tree heads of the two sizes.
+// Pre: None.
+// Post: Nil is returned if the second tree extends the first, otherwise an error.
+func (p *ConsistencyProof) Verify(first, second *SignedTreeHead) error {
+	if p.FirstSize != first.TreeSize || p.SecondSize != second.TreeSize {
+		return fmt.Errorf("proof is for trees of size %d and %d, not %d and %d",
+			p.FirstSize, p.SecondSize, first.TreeSize, second.TreeSize)
+	}
+	return VerifyConsistency(p.FirstSize, p.SecondSize, first.RootHash, second.RootHash, p.Path)
+}
+
+// VerifyInclusion checks that a leaf is in a tree, following RFC 9162 section 2.1.3.2.
+// Pre: Parameter leafHash is LeafHash of the leaf. Parameter root is the root hash of
+// the tree of size leaves, typically from a verified signed tree head.
+// Post: Nil is returned if the proof shows the leaf at index, otherwise an error.
+func VerifyInclusion(leafHash []byte, index, size uint64, path [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("leaf index %d is outside a tree of size %d", index, size)
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range path {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that a tree is an append-only extension of an earlier one,
+// following RFC 9162 section 2.1.4.2.
+// Pre: Parameters firstRoot and secondRoot are the root hashes of the trees of size
+// first and second, typically from verified signed tree heads.
+// Post: Nil is returned if the proof shows the first tree is a prefix of the second,
+// otherwise an error.
+func VerifyConsistency(first, second uint64, firstRoot, secondRoot []byte, path [][]byte) error {
+	switch {
+	case first > second:
+		return fmt.Errorf("tree of size %d cannot extend a tree of size %d", second, first)
+	case first == second:
+		if len(path) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrInvalidProof
+		}
+		return nil
+	case first == 0:
+		// Every tree extends the empty tree.
+		if len(path) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+	if first&(first-1) == 0 {
+		// The first tree is a complete subtree, whose hash the proof leaves out.
+		path = append([][]byte{firstRoot}, path...)
+	}
+	if len(path) == 0 {
+		return ErrInvalidProof
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package transparency
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// memTree is a tree of leaf hashes that computes every subtree hash from scratch.
+type memTree [][]byte
+
+func (m memTree) subtree(start, n uint64) ([]byte, error) {
+	if n == 1 {
+		return m[start], nil
+	}
+	k := split(n)
+	left, _ := m.subtree(start, k)
+	right, _ := m.subtree(start+k, n-k)
+	return nodeHash(left, right), nil
+}
+
+// testLeaves are the leaves of the RFC 6962 test vectors of the certificate-transparency
+// reference implementation.
+var testLeaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+func testTree(t *testing.T, n int) memTree {
+	var m memTree
+	for i := 0; i < n; i++ {
+		leaf, err := hex.DecodeString(testLeaves[i%len(testLeaves)])
+		require.NoError(t, err)
+		m = append(m, LeafHash(append(leaf, byte(i/len(testLeaves)))))
+	}
+	return m
+}
+
+func TestRoots(t *testing.T) {
+	var m memTree
+	for _, leaf := range testLeaves {
+		b, err := hex.DecodeString(leaf)
+		require.NoError(t, err)
+		m = append(m, LeafHash(b))
+	}
+	for n, want := range map[uint64]string{
+		1: "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		2: "fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		3: "aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		4: "d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		5: "4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		6: "76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		7: "ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		8: "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	} {
+		root, _ := m.subtree(0, n)
+		require.Equal(t, want, hex.EncodeToString(root), "tree of size %d", n)
+	}
+	require.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(EmptyRoot()))
+}
+
+func TestInclusion(t *testing.T) {
+	m := testTree(t, 21)
+	for n := uint64(1); n <= uint64(len(m)); n++ {
+		root, _ := m.subtree(0, n)
+		for i := uint64(0); i < n; i++ {
+			path, err := inclusionPath(m, i, 0, n)
+			require.NoError(t, err)
+			require.NoError(t, VerifyInclusion(m[i], i, n, path, root), "leaf %d of %d", i, n)
+
+			if i > 0 {
+				require.Error(t, VerifyInclusion(m[i], i-1, n, path, root), "wrong index")
+			}
+			if n > 1 {
+				require.Error(t, VerifyInclusion(m[(i+1)%n], i, n, path, root), "wrong leaf")
+			}
+			require.Error(t, VerifyInclusion(m[i], i, n, append(path, root), root), "extra hash")
+			if len(path) > 0 {
+				require.Error(t, VerifyInclusion(m[i], i, n, path[:len(path)-1], root), "missing hash")
+				tampered := append([][]byte{}, path...)
+				tampered[0] = flip(tampered[0])
+				require.Error(t, VerifyInclusion(m[i], i, n, tampered, root), "tampered hash")
+			}
+		}
+		require.Error(t, VerifyInclusion(m[0], n, n, nil, root), "index out of range")
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	m := testTree(t, 21)
+	roots := make([][]byte, len(m)+1)
+	roots[0] = EmptyRoot()
+	for n := 1; n <= len(m); n++ {
+		roots[n], _ = m.subtree(0, uint64(n))
+	}
+	for second := uint64(1); second <= uint64(len(m)); second++ {
+		for first := uint64(1); first <= second; first++ {
+			path, err := consistencyPath(m, first, 0, second, true)
+			require.NoError(t, err)
+			require.NoError(t, VerifyConsistency(first, second, roots[first], roots[second], path), "%d to %d", first, second)
+
+			if first < second {
+				require.Error(t, VerifyConsistency(first, second, roots[first-1], roots[second], path), "wrong first root")
+				require.Error(t, VerifyConsistency(first, second, roots[first], roots[second-1], path), "wrong second root")
+				require.Error(t, VerifyConsistency(first, second, roots[first], roots[second], append(path, roots[0])), "extra hash")
+				tampered := append([][]byte{}, path...)
+				tampered[len(tampered)-1] = flip(tampered[len(tampered)-1])
+				require.Error(t, VerifyConsistency(first, second, roots[first], roots[second], tampered), "tampered hash")
+			}
+		}
+	}
+	require.NoError(t, VerifyConsistency(0, 5, nil, roots[5], nil))
+	require.Error(t, VerifyConsistency(5, 4, roots[5], roots[4], nil))
+	require.Error(t, VerifyConsistency(3, 3, roots[3], roots[4], nil))
+}
+
+func flip(b []byte) []byte {
+	c := append([]byte(nil), b...)
+	c[0] ^= 1
+	return c
+}
@@ -0,0 +1,110 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// SignedTreeHead is a log's signed commitment to the tree of its first TreeSize entries.
+type SignedTreeHead struct {
+	TreeSize uint64 `json:"tree_size"`
+	// Timestamp is when the head was signed, with millisecond precision.
+	Timestamp time.Time `json:"timestamp"`
+	RootHash  []byte    `json:"root_hash"`
+	// LogID is the KeyID of the signing key.
+	LogID     []byte `json:"log_id"`
+	Signature []byte `json:"signature"`
+}
+
+// KeyID identifies a log by the SHA-256 of its PKIX encoded public key.
+// Pre: None.
+// Post: The key ID or an error is returned.
+func KeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal log key")
+	}
+	digest := sha256.Sum256(der)
+	return digest[:], nil
+}
+
+// signedData is the TreeHeadSignature structure of RFC 6962 section 3.5: version v1,
+// signature type tree_hash, timestamp, tree size and root hash.
+func (sth *SignedTreeHead) signedData() []byte {
+	b := make([]byte, 2+8+8, 2+8+8+HashSize)
+	b[0], b[1] = 0, 1
+	binary.BigEndian.PutUint64(b[2:], uint64(sth.Timestamp.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint64(b[10:], sth.TreeSize)
+	return append(b, sth.RootHash...)
+}
+
+// SignTreeHead signs a tree head, filling in its log ID and signature. ECDSA keys sign
+// the SHA-256 of the signed data, Ed25519 keys the data itself.
+// Pre: Parameter sth has its tree size, timestamp and root hash set.
+// Post: Nil or an error is returned.
+func SignTreeHead(signer crypto.Signer, sth *SignedTreeHead) error {
+	if len(sth.RootHash) != HashSize {
+		return fmt.Errorf("root hash is %d bytes, not %d", len(sth.RootHash), HashSize)
+	}
+	sth.Timestamp = sth.Timestamp.Truncate(time.Millisecond).UTC()
+	id, err := KeyID(signer.Public())
+	if err != nil {
+		return err
+	}
+	data := sth.signedData()
+	var sig []byte
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		return fmt.Errorf("unsupported log key type %T", signer.Public())
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not sign tree head")
+	}
+	sth.LogID, sth.Signature = id, sig
+	return nil
+}
+
+// VerifyTreeHead checks that a tree head was signed by a log's key.
+// Pre: None.
+// Post: Nil is returned if the signature is valid, otherwise an error.
+func VerifyTreeHead(pub crypto.PublicKey, sth *SignedTreeHead) error {
+	id, err := KeyID(pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(id, sth.LogID) {
+		return errors.New("tree head is from a different log")
+	}
+	if len(sth.RootHash) != HashSize {
+		return fmt.Errorf("root hash is %d bytes, not %d", len(sth.RootHash), HashSize)
+	}
+	data := sth.signedData()
+	valid := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		valid = ecdsa.VerifyASN1(key, digest[:], sth.Signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, data, sth.Signature)
+	default:
+		return fmt.Errorf("unsupported log key type %T", pub)
+	}
+	if !valid {
+		return errors.New("invalid tree head signature")
+	}
+	return nil
+}
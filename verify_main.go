@@ -1,14 +0,0 @@
-package main
-
-import (
-	"nitro/attest/attestation"
-	"time"
-)
-
-func main() {
-	//doc := "hEShATgioFkRFKlpbW9kdWxlX2lkeCdpLTAxNGE1ZTdhYTcwYTM5ODYyLWVuYzAxODA3MjljYzkyOGM1ZWZmZGlnZXN0ZlNIQTM4NGl0aW1lc3RhbXAbAAABgHKczHlkcGNyc7AAWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAACWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAADWDB9H9q50reyuHzJrAYQUAgMvbB+xm7Bp0ziaIZbpf4NZriLLfVqnyC1TWDsV/8griEEWDBB0M6adkdWPvWa0WKhTiJDG/gAFolmcojxhhwe6tWWcIcvl+0h98oQfnBvo46CBiAFWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAGWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAHWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAIWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAJWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAKWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAALWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAMWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAANWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAOWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAPWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABrY2VydGlmaWNhdGVZAn4wggJ6MIICAaADAgECAhABgHKcySjF7wAAAABiayowMAoGCCqGSM49BAMDMIGOMQswCQYDVQQGEwJVUzETMBEGA1UECAwKV2FzaGluZ3RvbjEQMA4GA1UEBwwHU2VhdHRsZTEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxOTA3BgNVBAMMMGktMDE0YTVlN2FhNzBhMzk4NjIudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczAeFw0yMjA0MjgyMzU4MzdaFw0yMjA0MjkwMjU4NDBaMIGTMQswCQYDVQQGEwJVUzETMBEGA1UECAwKV2FzaGluZ3RvbjEQMA4GA1UEBwwHU2VhdHRsZTEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxPjA8BgNVBAMMNWktMDE0YTVlN2FhNzBhMzk4NjItZW5jMDE4MDcyOWNjOTI4YzVlZi51cy1lYXN0LTEuYXdzMHYwEAYHKoZIzj0CAQYFK4EEACIDYgAED62ZdCqEcWgVzHRayTMcvGsrsS6fQdgjHfmWn1cxr/7rGX8OV4ENyYt6XCOx0L8WrfZ3dd4CU1kvr5M2Joohg7C/bpVSdhB1zOYS4vqNYKJHhMKmBWz1cKVzvk9oX3NAox0wGzAMBgNVHRMBAf8EAjAAMAsGA1UdDwQEAwIGwDAKBggqhkjOPQQDAwNnADBkAjAKhcOVuIdZe3rh1Qywk5lxQnU2hEoJoKCTIyNteGSTGp6HwqI+C9B+y6hOuilvARYCMEnQOFYVpL1fWtEo+yMHULqTuU7eb/QqwpKlOSc5HUC8GK4mpmvR3WYjZTqmht1VnmhjYWJ1bmRsZYRZAhUwggIRMIIBlqADAgECAhEA+TF1aBuQr+EdRsy05Of4VjAKBggqhkjOPQQDAzBJMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxGzAZBgNVBAMMEmF3cy5uaXRyby1lbmNsYXZlczAeFw0xOTEwMjgxMzI4MDVaFw00OTEwMjgxNDI4MDVaMEkxCzAJBgNVBAYTAlVTMQ8wDQYDVQQKDAZBbWF6b24xDDAKBgNVBAsMA0FXUzEbMBkGA1UEAwwSYXdzLm5pdHJvLWVuY2xhdmVzMHYwEAYHKoZIzj0CAQYFK4EEACIDYgAE/AJU66YIwfNocOKa2pC+RjgyknNuiUv/9nLZiURLUFHlNKSx9tvjwLxYGjK3sXYHDt4S1po/6iEbZudSz33R3QlfbxNw9BcIQ9ncEAEh5M9jASgJZkSHyXlihDBNxT/0o0IwQDAPBgNVHRMBAf8EBTADAQH/MB0GA1UdDgQWBBSQJbUN2QVH55bDlvpync+Zqd9LljAOBgNVHQ8BAf8EBAMCAYYwCgYIKoZIzj0EAwMDaQAwZgIxAKN/L5Ghyb1e57hifBaY0lUDjh8DQ/lbY6lijD05gJVFoR68vy47Vdiu7nG0w9at8wIxAKLzmxYFsnAopd1LoGm1AW5ltPvej+AGHWpTGX+c2vXZQ7xh/CvrA8tv7o0jAvPf9lkCwjCCAr4wggJEoAMCAQICEB4Ea+ULZcpWaDMpq8CAG8QwCgYIKoZIzj0EAwMwSTELMAkGA1UEBhMCVVMxDzANBgNVBAoMBkFtYXpvbjEMMAoGA1UECwwDQVdTMRswGQYDVQQDDBJhd3Mubml0cm8tZW5jbGF2ZXMwHhcNMjIwNDI3MDUwNzQ2WhcNMjIwNTE3MDYwNzQ2WjBkMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxNjA0BgNVBAMMLWZmM2UwMzViM2U3Y2E2N2IudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczB2MBAGByqGSM49AgEGBSuBBAAiA2IABIoRSbUxiurEev8jb+v5tp1+48yCtypWjfDpusGnIOizPIIQYIwBHjQQkV09blTW6hkWzRPDv18bhrozA+nJjJ5kPT69v3lWBgZlQDEl0KO46NN61ks8LF6Gzg3J7tChx6OB1TCB0jASBgNVHRMBAf8ECDAGAQH/AgECMB8GA1UdIwQYMBaAFJAltQ3ZBUfnlsOW+nKdz5mp30uWMB0GA1UdDgQWBBQPkMtvv4wIM50NkHYO/ZEWkdxiQzAOBgNVHQ8BAf8EBAMCAYYwbAYDVR0fBGUwYzBhoF+gXYZbaHR0cDovL2F3cy1uaXRyby1lbmNsYXZlcy1jcmwuczMuYW1hem9uYXdzLmNvbS9jcmwvYWI0OTYwY2MtN2Q2My00MmJkLTllOWYtNTkzMzhjYjY3Zjg0LmNybDAKBggqhkjOPQQDAwNoADBlAjAl1eCJKoZAAjcI5t4HSqO0HJXOmWhIUCIa695cL0TjTpbQN03x1Tv7irYYDTrV9F0CMQCQJ/074OuD4AhrTpNT/0MH4dp2AIvxBbUaWb88a0BDpzNBjfFE9+5bWZXDh/za3ltZAxkwggMVMIICm6ADAgECAhEAp4picv3PZXJIJ7pn8eTmpzAKBggqhkjOPQQDAzBkMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxNjA0BgNVBAMMLWZmM2UwMzViM2U3Y2E2N2IudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczAeFw0yMjA0MjgxNjU0MjBaFw0yMjA1MDQwOTU0MTlaMIGJMTwwOgYDVQQDDDNiZWE1OWU2NjZlYzViOGUwLnpvbmFsLnVzLWVhc3QtMS5hd3Mubml0cm8tZW5jbGF2ZXMxDDAKBgNVBAsMA0FXUzEPMA0GA1UECgwGQW1hem9uMQswCQYDVQQGEwJVUzELMAkGA1UECAwCV0ExEDAOBgNVBAcMB1NlYXR0bGUwdjAQBgcqhkjOPQIBBgUrgQQAIgNiAAQ3fT+4iJRg1o2bPkuYdxT/KZVcxxdFbvrxHLfrmJIQsF/FoqsuuVbou3yFBcKNeM06gKSniima6txtisV1oaJ8PeAryCx2ctcT7oDQEQFlyahoTyCBwuBmDNpUbVuQ7vKjgeowgecwEgYDVR0TAQH/BAgwBgEB/wIBATAfBgNVHSMEGDAWgBQPkMtvv4wIM50NkHYO/ZEWkdxiQzAdBgNVHQ4EFgQUkXd3dazWwVYyHtufVwI9jsirCdswDgYDVR0PAQH/BAQDAgGGMIGABgNVHR8EeTB3MHWgc6Bxhm9odHRwOi8vY3JsLXVzLWVhc3QtMS1hd3Mtbml0cm8tZW5jbGF2ZXMuczMudXMtZWFzdC0xLmFtYXpvbmF3cy5jb20vY3JsL2VlMTFkZmZkLWVlOWYtNGQyNi1iYWY4LTM1ZDBjNmIwNzYyNy5jcmwwCgYIKoZIzj0EAwMDaAAwZQIwPC7foKlBDsChxf3kEe73qNrnK3TAAd1Ue4cYyR2NLnk9XeNHRJlrXc9yQLQvitGTAjEAvotaght5gVVia+bYDK3ULguyZKW94qoSokoEk4pcpcaVUsO1UVE9EK9ZOz/V3uFwWQKCMIICfjCCAgSgAwIBAgIULXWJ5k+BlhjydUGCxsrPLMhsPC8wCgYIKoZIzj0EAwMwgYkxPDA6BgNVBAMMM2JlYTU5ZTY2NmVjNWI4ZTAuem9uYWwudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczEMMAoGA1UECwwDQVdTMQ8wDQYDVQQKDAZBbWF6b24xCzAJBgNVBAYTAlVTMQswCQYDVQQIDAJXQTEQMA4GA1UEBwwHU2VhdHRsZTAeFw0yMjA0MjgyMDQyMDBaFw0yMjA0MjkyMDQyMDBaMIGOMQswCQYDVQQGEwJVUzETMBEGA1UECAwKV2FzaGluZ3RvbjEQMA4GA1UEBwwHU2VhdHRsZTEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxOTA3BgNVBAMMMGktMDE0YTVlN2FhNzBhMzk4NjIudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczB2MBAGByqGSM49AgEGBSuBBAAiA2IABM5Jm0mWkGzYHlFQUxayh2Z0VrCfklAzcJTIcP/vmSy5SYE1UBhTmhfx+vm6ht2WYrMzuvTiTyy/xXP+M1AiNFlV9xqiHUVOfcQnZaM9d5goLSI9Uchg0gAjYnTM/4kzzKMmMCQwEgYDVR0TAQH/BAgwBgEB/wIBADAOBgNVHQ8BAf8EBAMCAgQwCgYIKoZIzj0EAwMDaAAwZQIxAJjwbW80xWIwrH/pii/YezmU8pgTffRGbZHQnXLJHozQocOcQqRYxav+ViW0iZjHpQIwSDTUt8q1xLY5xiAGhodxBhMTaPwtHSAjtzOYl6hXjhEg8jvmUl3mX/+dpiN0no93anB1YmxpY19rZXlYQQSogtYvXEjQAWbOVRIuT5MYoqlQOwTj64lcODkHZPJ9c9aFXRZn7rHOkjtRO5m+haBGTuGaUCzR4ea8Fv/UQ3jjaXVzZXJfZGF0YUwAAQIDBAUGBwgJCgtlbm9uY2VIMiisRs38DMxYYI4smAEr8EHIyOF+eI7HVATuKKa9VGzvJ+kLQMh2drN4h1gLqfpjLxbJeCmPpE9tg9EJ3gS2hOl6sKFCFUlYPROLhW4z5pwM5Cg4EMG+NjUOXSeuyqtTsEionhuEW2lLmQ=="
-	doc := "hEShATgioFkREqlpbW9kdWxlX2lkeCdpLTAxNGE1ZTdhYTcwYTM5ODYyLWVuYzAxODA4NWE0NDg1YzgwNDJmZGlnZXN0ZlNIQTM4NGl0aW1lc3RhbXAbAAABgIWkho1kcGNyc7AAWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAACWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAADWDB9H9q50reyuHzJrAYQUAgMvbB+xm7Bp0ziaIZbpf4NZriLLfVqnyC1TWDsV/8griEEWDBB0M6adkdWPvWa0WKhTiJDG/gAFolmcojxhhwe6tWWcIcvl+0h98oQfnBvo46CBiAFWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAGWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAHWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAIWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAJWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAKWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAALWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAMWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAANWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAOWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAPWDAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABrY2VydGlmaWNhdGVZAn4wggJ6MIICAaADAgECAhABgIWkSFyAQgAAAABicAlKMAoGCCqGSM49BAMDMIGOMQswCQYDVQQGEwJVUzETMBEGA1UECAwKV2FzaGluZ3RvbjEQMA4GA1UEBwwHU2VhdHRsZTEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxOTA3BgNVBAMMMGktMDE0YTVlN2FhNzBhMzk4NjIudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczAeFw0yMjA1MDIxNjM5MzVaFw0yMjA1MDIxOTM5MzhaMIGTMQswCQYDVQQGEwJVUzETMBEGA1UECAwKV2FzaGluZ3RvbjEQMA4GA1UEBwwHU2VhdHRsZTEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxPjA8BgNVBAMMNWktMDE0YTVlN2FhNzBhMzk4NjItZW5jMDE4MDg1YTQ0ODVjODA0Mi51cy1lYXN0LTEuYXdzMHYwEAYHKoZIzj0CAQYFK4EEACIDYgAERXVniNk9xZUerTOr+2Jz4MQBHSz7nkqhEOxD+4LmbVbyvBJZZUJ6JjOJNlEtUII0rPYWm+7gZ57zmipwivMv7wsG3ju60XLvTx6dhEEDp3UAXAG8ffVNt+NX5fuolaydox0wGzAMBgNVHRMBAf8EAjAAMAsGA1UdDwQEAwIGwDAKBggqhkjOPQQDAwNnADBkAjAR6SgQRTyy393Lv56FiU+T2iRbDTr8/zDro7rjyfM0b9VZFvoHe+NEL0e4t+grkv0CMGrOFVIUbtm/wKOtnQOEoGjeH7RD0VwkYBW2jXwzrIqbVco3VU28YBJP+020SUhrtWhjYWJ1bmRsZYRZAhUwggIRMIIBlqADAgECAhEA+TF1aBuQr+EdRsy05Of4VjAKBggqhkjOPQQDAzBJMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxGzAZBgNVBAMMEmF3cy5uaXRyby1lbmNsYXZlczAeFw0xOTEwMjgxMzI4MDVaFw00OTEwMjgxNDI4MDVaMEkxCzAJBgNVBAYTAlVTMQ8wDQYDVQQKDAZBbWF6b24xDDAKBgNVBAsMA0FXUzEbMBkGA1UEAwwSYXdzLm5pdHJvLWVuY2xhdmVzMHYwEAYHKoZIzj0CAQYFK4EEACIDYgAE/AJU66YIwfNocOKa2pC+RjgyknNuiUv/9nLZiURLUFHlNKSx9tvjwLxYGjK3sXYHDt4S1po/6iEbZudSz33R3QlfbxNw9BcIQ9ncEAEh5M9jASgJZkSHyXlihDBNxT/0o0IwQDAPBgNVHRMBAf8EBTADAQH/MB0GA1UdDgQWBBSQJbUN2QVH55bDlvpync+Zqd9LljAOBgNVHQ8BAf8EBAMCAYYwCgYIKoZIzj0EAwMDaQAwZgIxAKN/L5Ghyb1e57hifBaY0lUDjh8DQ/lbY6lijD05gJVFoR68vy47Vdiu7nG0w9at8wIxAKLzmxYFsnAopd1LoGm1AW5ltPvej+AGHWpTGX+c2vXZQ7xh/CvrA8tv7o0jAvPf9lkCwjCCAr4wggJEoAMCAQICEB4Ea+ULZcpWaDMpq8CAG8QwCgYIKoZIzj0EAwMwSTELMAkGA1UEBhMCVVMxDzANBgNVBAoMBkFtYXpvbjEMMAoGA1UECwwDQVdTMRswGQYDVQQDDBJhd3Mubml0cm8tZW5jbGF2ZXMwHhcNMjIwNDI3MDUwNzQ2WhcNMjIwNTE3MDYwNzQ2WjBkMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQLDANBV1MxNjA0BgNVBAMMLWZmM2UwMzViM2U3Y2E2N2IudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczB2MBAGByqGSM49AgEGBSuBBAAiA2IABIoRSbUxiurEev8jb+v5tp1+48yCtypWjfDpusGnIOizPIIQYIwBHjQQkV09blTW6hkWzRPDv18bhrozA+nJjJ5kPT69v3lWBgZlQDEl0KO46NN61ks8LF6Gzg3J7tChx6OB1TCB0jASBgNVHRMBAf8ECDAGAQH/AgECMB8GA1UdIwQYMBaAFJAltQ3ZBUfnlsOW+nKdz5mp30uWMB0GA1UdDgQWBBQPkMtvv4wIM50NkHYO/ZEWkdxiQzAOBgNVHQ8BAf8EBAMCAYYwbAYDVR0fBGUwYzBhoF+gXYZbaHR0cDovL2F3cy1uaXRyby1lbmNsYXZlcy1jcmwuczMuYW1hem9uYXdzLmNvbS9jcmwvYWI0OTYwY2MtN2Q2My00MmJkLTllOWYtNTkzMzhjYjY3Zjg0LmNybDAKBggqhkjOPQQDAwNoADBlAjAl1eCJKoZAAjcI5t4HSqO0HJXOmWhIUCIa695cL0TjTpbQN03x1Tv7irYYDTrV9F0CMQCQJ/074OuD4AhrTpNT/0MH4dp2AIvxBbUaWb88a0BDpzNBjfFE9+5bWZXDh/za3ltZAxcwggMTMIICmqADAgECAhA2/fXXYkH4oxV0/Hz9Vpn+MAoGCCqGSM49BAMDMGQxCzAJBgNVBAYTAlVTMQ8wDQYDVQQKDAZBbWF6b24xDDAKBgNVBAsMA0FXUzE2MDQGA1UEAwwtZmYzZTAzNWIzZTdjYTY3Yi51cy1lYXN0LTEuYXdzLm5pdHJvLWVuY2xhdmVzMB4XDTIyMDUwMTIxNTYyMVoXDTIyMDUwNzExNTYyMFowgYkxPDA6BgNVBAMMMzNmOGViMGZmOTczYmJhN2Muem9uYWwudXMtZWFzdC0xLmF3cy5uaXRyby1lbmNsYXZlczEMMAoGA1UECwwDQVdTMQ8wDQYDVQQKDAZBbWF6b24xCzAJBgNVBAYTAlVTMQswCQYDVQQIDAJXQTEQMA4GA1UEBwwHU2VhdHRsZTB2MBAGByqGSM49AgEGBSuBBAAiA2IABJoVtBpqJI+qeFYsrUf0x31DVNm1QQ0sn5KOAkyyjwzwY1HF0g4qqWddN8SYqfgttBkraosNXqFXkXvxZ5iajD4Ntp7XGulLU/RWtXggys9VTBITLmUDvAON+iqnOTbfr6OB6jCB5zASBgNVHRMBAf8ECDAGAQH/AgEBMB8GA1UdIwQYMBaAFA+Qy2+/jAgznQ2Qdg79kRaR3GJDMB0GA1UdDgQWBBTXxcK2UgA54Z25Yq4rbJLf+uzg/TAOBgNVHQ8BAf8EBAMCAYYwgYAGA1UdHwR5MHcwdaBzoHGGb2h0dHA6Ly9jcmwtdXMtZWFzdC0xLWF3cy1uaXRyby1lbmNsYXZlcy5zMy51cy1lYXN0LTEuYW1hem9uYXdzLmNvbS9jcmwvZWUxMWRmZmQtZWU5Zi00ZDI2LWJhZjgtMzVkMGM2YjA3NjI3LmNybDAKBggqhkjOPQQDAwNnADBkAjBCB8zg2rZHaIl2ZEX9VknatEMPodaJMVgT3QscQDoalf18hPwA6xCofBdLAkUekCACMGVsO5Yw27sKe0D397iOn2W71FyT8djGuoy40nveEAWmZUT3g225Oip31I8g9Z8Nq1kCgjCCAn4wggIFoAMCAQICFQDlzxVc+uaBS2Jazz0xpn/G1/PX/zAKBggqhkjOPQQDAzCBiTE8MDoGA1UEAwwzM2Y4ZWIwZmY5NzNiYmE3Yy56b25hbC51cy1lYXN0LTEuYXdzLm5pdHJvLWVuY2xhdmVzMQwwCgYDVQQLDANBV1MxDzANBgNVBAoMBkFtYXpvbjELMAkGA1UEBhMCVVMxCzAJBgNVBAgMAldBMRAwDgYDVQQHDAdTZWF0dGxlMB4XDTIyMDUwMjA4NDIwNFoXDTIyMDUwMzA4NDIwNFowgY4xCzAJBgNVBAYTAlVTMRMwEQYDVQQIDApXYXNoaW5ndG9uMRAwDgYDVQQHDAdTZWF0dGxlMQ8wDQYDVQQKDAZBbWF6b24xDDAKBgNVBAsMA0FXUzE5MDcGA1UEAwwwaS0wMTRhNWU3YWE3MGEzOTg2Mi51cy1lYXN0LTEuYXdzLm5pdHJvLWVuY2xhdmVzMHYwEAYHKoZIzj0CAQYFK4EEACIDYgAEzkmbSZaQbNgeUVBTFrKHZnRWsJ+SUDNwlMhw/++ZLLlJgTVQGFOaF/H6+bqG3ZZiszO69OJPLL/Fc/4zUCI0WVX3GqIdRU59xCdloz13mCgtIj1RyGDSACNidMz/iTPMoyYwJDASBgNVHRMBAf8ECDAGAQH/AgEAMA4GA1UdDwEB/wQEAwICBDAKBggqhkjOPQQDAwNnADBkAjBuiStn64XE+3gIoeg5w+/m28OuJv3MApeTaZcSPUKnIpiHGV4Cik3+t2FgMlhPXgwCMAQLsEG9lkJn1jAo00lMFmZrwsE4HI8ec/OnjJ3YomArBmzvD1lpb5FtPGKrCDiCbmpwdWJsaWNfa2V5WEEEP9aBV4hCF5S/04k8vB42qNaLC/b6do1fvL7TJgmThkjrrjqbf3GvvH+t5Sc54tXeDBkffP2rhW3E98+YU5JCsGl1c2VyX2RhdGFMAAECAwQFBgcICQoLZW5vbmNlSD68dMqAHqvVWGB28bQUHramVECu8o3UrFhccMmxePNxQW07Xhu1dx3pJbWeCV9DMziUVEBPlu6kX+EKjE/sfCRDW04YXiBhFdLHQYsSvRLYBl+GqpNghcmCkTnXu7WYqEUwsCgDwVGTfUI=\n"
-	resJSON, err := attestation.VerifyAttestation(doc, time.Now())
-	attestation.ExitIfError("attestation verification failed with error", err)
-	attestation.PrintJSON(resJSON)
-}
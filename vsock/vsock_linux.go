@@ -0,0 +1,200 @@
+// Package vsock provides net.Listener and net.Conn implementations over AF_VSOCK, the
+// socket family connecting a Nitro Enclave with its parent instance.
+package vsock
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Well-known context identifiers.
+const (
+	// CIDAny binds a listener to every local context identifier.
+	CIDAny = unix.VMADDR_CID_ANY
+	// CIDHost is the parent instance as seen from an enclave.
+	CIDHost = unix.VMADDR_CID_HOST
+)
+
+// Addr is a vsock address.
+type Addr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns "vsock".
+func (a *Addr) Network() string {
+	return "vsock"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("vsock(%d:%d)", a.CID, a.Port)
+}
+
+func addrOf(sa unix.Sockaddr) *Addr {
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		return &Addr{CID: vm.CID, Port: vm.Port}
+	}
+	return &Addr{}
+}
+
+// Listen listens for vsock connections on port.
+// Pre: Parameter port is the vsock port.
+// Post: A net.Listener or an error is returned.
+func Listen(port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: CIDAny, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	// A non-blocking descriptor is registered with the runtime poller, which makes
+	// Accept interruptible by Close.
+	f := os.NewFile(uintptr(fd), "vsock-listener")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &listener{file: f, rc: rc, addr: addrOf(sa)}, nil
+}
+
+// Dial connects to port on the vsock context cid, such as an enclave's CID.
+// Pre: Parameter timeout bounds the connection attempt; zero means no timeout.
+// Post: A net.Conn or an error is returned.
+func Dial(cid, port uint32, timeout time.Duration) (net.Conn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	remote := &Addr{CID: cid, Port: port}
+	err = unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port})
+	if err != nil && err != unix.EINPROGRESS {
+		unix.Close(fd)
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: remote, Err: os.NewSyscallError("connect", err)}
+	}
+	f := os.NewFile(uintptr(fd), "vsock")
+	if err == unix.EINPROGRESS {
+		if err := awaitConnect(f, timeout); err != nil {
+			f.Close()
+			return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: remote, Err: err}
+		}
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		f.Close()
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &conn{File: f, local: addrOf(sa), remote: remote}, nil
+}
+
+// awaitConnect waits for a non-blocking connect to complete.
+func awaitConnect(f *os.File, timeout time.Duration) error {
+	if timeout > 0 {
+		f.SetWriteDeadline(time.Now().Add(timeout))
+		defer f.SetWriteDeadline(time.Time{})
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cerr error
+	err = rc.Write(func(fd uintptr) bool {
+		if _, err := unix.Getpeername(int(fd)); err == nil {
+			return true
+		}
+		errno, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+		switch {
+		case err != nil:
+			cerr = os.NewSyscallError("getsockopt", err)
+		case errno != 0:
+			cerr = os.NewSyscallError("connect", unix.Errno(errno))
+		default:
+			return false // still connecting
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+type listener struct {
+	file *os.File
+	rc   syscall.RawConn
+	addr *Addr
+}
+
+// Accept waits for the next connection.
+func (l *listener) Accept() (net.Conn, error) {
+	var (
+		nfd  int
+		sa   unix.Sockaddr
+		aerr error
+	)
+	err := l.rc.Read(func(fd uintptr) bool {
+		nfd, sa, aerr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return aerr != unix.EAGAIN
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aerr != nil {
+		return nil, os.NewSyscallError("accept4", aerr)
+	}
+	return &conn{File: os.NewFile(uintptr(nfd), "vsock"), local: l.addr, remote: addrOf(sa)}, nil
+}
+
+// Close stops the listener, unblocking Accept.
+func (l *listener) Close() error {
+	return l.file.Close()
+}
+
+// Addr returns the listener's address.
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is a vsock connection. *os.File supplies reads, writes and deadlines.
+type conn struct {
+	*os.File
+	local, remote *Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// CloseWrite shuts down the writing side, signalling EOF to the peer.
+func (c *conn) CloseWrite() error {
+	rc, err := c.File.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.Shutdown(int(fd), unix.SHUT_WR)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("shutdown", serr)
+}
@@ -0,0 +1,41 @@
+package vsock
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLoopback(t *testing.T) {
+	l, err := Listen(0xfffe)
+	if err != nil {
+		t.Skipf("vsock unavailable: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	c, err := Dial(unix.VMADDR_CID_LOCAL, 0xfffe, time.Second)
+	if err != nil {
+		t.Skipf("vsock loopback unavailable: %v", err)
+	}
+	defer c.Close()
+	require.Equal(t, "vsock", c.RemoteAddr().Network())
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	l.Close()
+	_, err = l.Accept()
+	require.Error(t, err)
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+package vsock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Well-known context identifiers.
+const (
+	// CIDAny binds a listener to every local context identifier.
+	CIDAny = 0xffffffff
+	// CIDHost is the parent instance as seen from an enclave.
+	CIDHost = 2
+)
+
+// Addr is a vsock address.
+type Addr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns "vsock".
+func (a *Addr) Network() string {
+	return "vsock"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("vsock(%d:%d)", a.CID, a.Port)
+}
+
+// Listen is only supported on Linux.
+func Listen(port uint32) (net.Listener, error) {
+	return nil, errors.New("vsock is only supported on linux")
+}
+
+// Dial is only supported on Linux.
+func Dial(cid, port uint32, timeout time.Duration) (net.Conn, error) {
+	return nil, errors.New("vsock is only supported on linux")
+}